@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/internal/logging"
+	"pilot/internal/tracing"
+	"pilot/pkg/models"
+	"pilot/pkg/notify"
+	"pilot/pkg/scheduler"
+)
+
+// Exit codes returned by the CLI.
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+)
+
+const usage = `usage: pilot [--db PATH] [--log-format text|json] [--log-level LEVEL] [--traces none|otlp|stdout]
+             <command> [arguments]
+
+commands:
+  scheduler     run the scheduler and local workers
+  worker        run a worker executing queued steps
+  maps          list, show, pause, unpause or delete maps
+  runs          list or show map runs
+  trigger       start a manual run of a map
+  steps         clear, mark or show the logs of step instances
+  graph         render the steps of a map
+  connections   manage the connections registry
+  datasets      list the datasets produced and consumed by maps
+  api           serve the HTTP API and web UI
+  users         manage the users of the HTTP API
+  tokens        manage API tokens
+  db            initialise the database
+
+Run "pilot <command> -h" for the flags of a command.
+Exit codes: 0 success, 1 error, 2 usage error, 3 not found.
+`
+
+// command runs a subcommand and returns the process exit code.
+type command func(db *database.DB, args []string) int
+
+var commands = map[string]command{
+	"scheduler":   runScheduler,
+	"worker":      runWorker,
+	"maps":        runMaps,
+	"runs":        runRuns,
+	"trigger":     runTrigger,
+	"steps":       runSteps,
+	"graph":       runGraph,
+	"connections": runConnections,
+	"datasets":    runDatasets,
+	"api":         runAPI,
+	"users":       runUsers,
+	"tokens":      runTokens,
+	"db":          runDBCommand,
+}
+
+// run parses the global flags, opens the database and dispatches to the
+// subcommand named by the first argument.
+func run(args []string) int {
+	fs := flag.NewFlagSet("pilot", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	dbPath := fs.String("db", defaultDBPath(), "path of the metadata database")
+	logFormat := fs.String("log-format", envOr("PILOT_LOG_FORMAT", logging.FormatText), "format of the logs, text or json")
+	logLevel := fs.String("log-level", envOr("PILOT_LOG_LEVEL", "info"), "minimum level of the logs: debug, info, warn or error")
+	traces := fs.String("traces", envOr("PILOT_TRACES", tracing.ExporterNone), "where to export traces: none, otlp or stdout")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	logger, err := logging.New(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	slog.SetDefault(logger)
+	shutdown, err := tracing.Setup(context.Background(), *traces, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	defer func() {
+		notify.Wait()
+		if err := shutdown(context.Background()); err != nil {
+			slog.Error("Error exporting traces", "error", err)
+		}
+	}()
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", fs.Arg(0))
+		fs.Usage()
+		return exitUsage
+	}
+
+	db, err := database.NewDB(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		return exitError
+	}
+	return cmd(db, fs.Args()[1:])
+}
+
+// defaultDBPath returns PILOT_DB or meta.db.
+func defaultDBPath() string {
+	return envOr("PILOT_DB", "meta.db")
+}
+
+// envOr returns the environment variable key, or fallback when it is unset.
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func runDBCommand(db *database.DB, args []string) int {
+	if len(args) != 1 || args[0] != "init" {
+		fmt.Fprintln(os.Stderr, "usage: pilot db init")
+		return exitUsage
+	}
+	// Opening the database already created any missing table
+	fmt.Println("Database initialized")
+	return exitOK
+}
+
+// printJSON writes v as indented JSON to stdout.
+func printJSON(v any) int {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error encoding JSON:", err)
+		return exitError
+	}
+	fmt.Println(string(out))
+	return exitOK
+}
+
+// newTable returns a writer aligning tab separated columns.
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+// formatTime renders a time for tables, leaving unset times blank.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// fail reports err and returns exitNotFound for missing records and
+// exitError otherwise.
+func fail(what string, err error) int {
+	fmt.Fprintf(os.Stderr, "Error %s: %v\n", what, err)
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errNotFound) || errors.Is(err, scheduler.ErrStepNotFound) {
+		return exitNotFound
+	}
+	return exitError
+}
+
+var errNotFound = errors.New("not found")
+
+// findMap looks a map up by ID or by name.
+func findMap(db *database.DB, ref string) (*models.Map, error) {
+	var m *models.Map
+	var err error
+	if id, convErr := strconv.Atoi(ref); convErr == nil {
+		m, err = db.GetMapByID(id)
+	} else {
+		m, err = db.GetMapByName(ref)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("map %s %w", ref, errNotFound)
+	}
+	return m, err
+}
+
+// parseArgs parses flags that may appear before or after the positional
+// arguments and returns the positional ones.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRunExitCodes(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{}, exitUsage},
+		{[]string{"--db", dbPath, "bogus"}, exitUsage},
+		{[]string{"--db", dbPath, "db", "init"}, exitOK},
+		{[]string{"--db", dbPath, "maps", "list", "--json"}, exitOK},
+		{[]string{"--db", dbPath, "maps", "show", "missing"}, exitNotFound},
+		{[]string{"--db", dbPath, "runs", "show", "42"}, exitNotFound},
+		{[]string{"--db", dbPath, "trigger"}, exitUsage},
+	}
+	for _, tt := range tests {
+		if got := run(tt.args); got != tt.want {
+			t.Errorf("run(%q) = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+
+	"pilot/internal/api"
+	"pilot/internal/database"
+	"pilot/internal/metrics"
+	"pilot/internal/web"
+)
+
+// runAPI serves the HTTP API, and the web UI on top of it, until the process
+// is stopped.
+func runAPI(db *database.DB, args []string) int {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	ui := fs.Bool("ui", true, "serve the web UI")
+	insecure := fs.Bool("insecure", false, "accept requests without credentials, for local development only")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	server := api.NewServer(db)
+	if *insecure {
+		slog.Warn("Authentication is disabled, anyone reaching the API can change maps", "addr", *addr)
+		server.DisableAuth()
+	} else if users, err := db.ListUsers(); err == nil && len(users) == 0 {
+		slog.Info("No users yet, add one with: pilot users add <name> --role admin --password-stdin")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/", server)
+	mux.Handle("/health", server)
+	mux.Handle("/metrics", metrics.Handler())
+	if *ui {
+		mux.Handle("/", web.Handler())
+	} else {
+		mux.Handle("/", server)
+	}
+
+	slog.Info("Serving the API", "addr", *addr)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		slog.Error("API server stopped", "error", err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+const connectionsUsage = `usage: pilot connections <command> [arguments]
+
+commands:
+  add <name> --uri URI [--login LOGIN] [--password PASSWORD] [--extra KEY=VALUE ...]
+  get <name> [--reveal]
+  list
+  delete <name>
+`
+
+// extrasFlag collects repeated --extra KEY=VALUE flags.
+type extrasFlag map[string]string
+
+func (e extrasFlag) String() string {
+	return fmt.Sprint(map[string]string(e))
+}
+
+func (e extrasFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("extra %q must be in KEY=VALUE form", value)
+	}
+	e[key] = val
+	return nil
+}
+
+// runConnections manages the connections registry and returns the process
+// exit code.
+func runConnections(db *database.DB, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, connectionsUsage)
+		return exitUsage
+	}
+
+	command, args := args[0], args[1:]
+	if command == "list" {
+		connections, err := db.ListConnections()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error listing connections:", err)
+			return exitError
+		}
+		for _, c := range connections {
+			fmt.Printf("%s\t%s\n", c.Name, c.Login)
+		}
+		return exitOK
+	}
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprint(os.Stderr, connectionsUsage)
+		return exitUsage
+	}
+	name, args := args[0], args[1:]
+
+	switch command {
+	case "add":
+		extras := extrasFlag{}
+		fs := flag.NewFlagSet("connections add", flag.ContinueOnError)
+		uri := fs.String("uri", "", "connection URI")
+		login := fs.String("login", "", "login name")
+		password := fs.String("password", "", "password")
+		fs.Var(extras, "extra", "extra KEY=VALUE field, may be repeated")
+		if err := fs.Parse(args); err != nil {
+			return exitUsage
+		}
+
+		c := models.NewConnection(name, *uri, *login, *password)
+		c.Extras = extras
+		if err := db.SaveConnection(*c); err != nil {
+			fmt.Fprintln(os.Stderr, "Error saving connection:", err)
+			return exitError
+		}
+		fmt.Printf("Saved connection %s\n", name)
+
+	case "get":
+		fs := flag.NewFlagSet("connections get", flag.ContinueOnError)
+		reveal := fs.Bool("reveal", false, "print secrets in clear text")
+		if err := fs.Parse(args); err != nil {
+			return exitUsage
+		}
+
+		c, err := db.GetConnection(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error getting connection:", err)
+			return exitError
+		}
+		show := func(secret string) string {
+			if *reveal || secret == "" {
+				return secret
+			}
+			return "***"
+		}
+		fmt.Printf("name:     %s\n", c.Name)
+		fmt.Printf("uri:      %s\n", show(c.URI))
+		fmt.Printf("login:    %s\n", c.Login)
+		fmt.Printf("password: %s\n", show(c.Password))
+		keys := make([]string, 0, len(c.Extras))
+		for k := range c.Extras {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("extra:    %s=%s\n", k, show(c.Extras[k]))
+		}
+
+	case "delete":
+		if err := db.DeleteConnection(name); err != nil {
+			fmt.Fprintln(os.Stderr, "Error deleting connection:", err)
+			return exitError
+		}
+		fmt.Printf("Deleted connection %s\n", name)
+
+	default:
+		fmt.Fprint(os.Stderr, connectionsUsage)
+		return exitUsage
+	}
+	return exitOK
+}
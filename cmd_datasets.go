@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+// runDatasets lists the datasets produced and consumed by maps, with their
+// latest update, and returns the process exit code.
+func runDatasets(db *database.DB, args []string) int {
+	fs := flag.NewFlagSet("datasets", flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	datasets, err := listDatasets(db)
+	if err != nil {
+		return fail("listing datasets", err)
+	}
+	if *jsonOut {
+		return printJSON(datasets)
+	}
+
+	t := newTable()
+	fmt.Fprintln(t, "DATASET\tPRODUCED BY\tCONSUMED BY\tLAST UPDATE\tRUN")
+	for _, d := range datasets {
+		updated, run := "-", "-"
+		if d.LastUpdate != nil {
+			updated, run = formatTime(d.LastUpdate.CreatedAt), fmt.Sprint(d.LastUpdate.RunID)
+		}
+		fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\n", d.Name, joinOrDash(d.Producers), joinOrDash(d.Consumers), updated, run)
+	}
+	t.Flush()
+	return exitOK
+}
+
+// listDatasets gathers the datasets named by the steps and triggers of every
+// map, and by past updates, sorted by name.
+func listDatasets(db *database.DB) ([]models.Dataset, error) {
+	maps, err := db.GetMaps()
+	if err != nil {
+		return nil, err
+	}
+	latest, err := db.LatestDatasetEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]*models.Dataset{}
+	get := func(name string) *models.Dataset {
+		d, ok := byName[name]
+		if !ok {
+			d = &models.Dataset{Name: name, Producers: []string{}, Consumers: []string{}}
+			byName[name] = d
+		}
+		return d
+	}
+	for _, m := range maps {
+		if m.Trigger != nil {
+			for _, name := range m.Trigger.Datasets {
+				d := get(name)
+				d.Consumers = append(d.Consumers, m.Name)
+			}
+		}
+		steps, err := db.GetStepsByMapID(m.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, step := range steps {
+			for _, name := range step.Produces {
+				d := get(name)
+				d.Producers = append(d.Producers, m.Name+"."+step.Name)
+			}
+		}
+	}
+	for name, e := range latest {
+		e := e
+		get(name).LastUpdate = &e
+	}
+
+	datasets := []models.Dataset{}
+	for _, d := range byName {
+		datasets = append(datasets, *d)
+	}
+	sort.Slice(datasets, func(i, j int) bool { return datasets[i].Name < datasets[j].Name })
+	return datasets, nil
+}
+
+// joinOrDash lists names for tables, or "-" when there are none.
+func joinOrDash(names []string) string {
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"pilot/internal/database"
+	"pilot/pkg/graph"
+)
+
+// runGraph renders the steps and dependencies of a map and returns the
+// process exit code.
+func runGraph(db *database.DB, args []string) int {
+	fs := flag.NewFlagSet("graph", flag.ContinueOnError)
+	mapRef := fs.String("map", "", "map name or ID")
+	format := fs.String("format", "dot", "output format: dot, mermaid or json")
+	colour := fs.Bool("state", false, "colour steps by their current state")
+	runID := fs.Int("run", 0, "colour steps by their state in this map run")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *mapRef == "" {
+		fmt.Fprintln(os.Stderr, "usage: pilot graph --map NAME|ID [--format dot|mermaid|json] [--state | --run ID]")
+		return exitUsage
+	}
+
+	m, err := findMap(db, *mapRef)
+	if err != nil {
+		return fail("finding map", err)
+	}
+	steps, err := db.GetStepsByMapID(m.ID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error getting steps:", err)
+		return exitError
+	}
+
+	var states map[int]string
+	if *colour {
+		states = graph.States(steps)
+	}
+	if *runID != 0 {
+		run, err := db.GetMapRun(*runID)
+		if err != nil {
+			return fail("getting map run", err)
+		}
+		if run.MapID != m.ID {
+			return fail("getting map run", fmt.Errorf("run %d is not a run of map %s: %w", *runID, m.Name, errNotFound))
+		}
+		instances, err := db.GetStepRuns(*runID)
+		if err != nil {
+			return fail("getting map run", err)
+		}
+		states = map[int]string{}
+		for _, instance := range instances {
+			states[instance.StepID] = instance.State
+		}
+	}
+
+	g := graph.New(steps)
+	switch *format {
+	case "dot":
+		fmt.Print(g.Dot(m.Name, states))
+	case "mermaid":
+		fmt.Print(g.Mermaid(states))
+	case "json":
+		out, err := g.JSON(m.Name, states)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error rendering graph:", err)
+			return exitError
+		}
+		fmt.Println(string(out))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return exitUsage
+	}
+	return exitOK
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/internal/tracing"
+	"pilot/pkg/graph"
+	"pilot/pkg/models"
+	"pilot/pkg/scheduler"
+)
+
+const mapsUsage = `usage: pilot maps <command> [arguments]
+
+commands:
+  list [--json]
+  show <map> [--json]
+  pause <map> [--reason TEXT] [--by NAME] [--cancel]
+  unpause <map>
+  delete <map> --yes
+`
+
+// mapSummary is the listing of a map.
+type mapSummary struct {
+	models.Map
+	NextRun      *time.Time           `json:"next_run,omitempty"`
+	TriggerFiles []models.TriggerFile `json:"trigger_files,omitempty"` // Latest files that started runs, in maps show
+	Estimate     *graph.Estimate      `json:"estimate,omitempty"`      // Durations from past runs, in maps show
+}
+
+// triggerFilesShown is how many of the latest trigger files maps show lists.
+const triggerFilesShown = 10
+
+// scheduleLabel describes when a map runs in the maps listing.
+func scheduleLabel(m models.Map) string {
+	if m.ScheduleInterval != "" || m.Trigger == nil {
+		return m.ScheduleInterval
+	}
+	if m.Trigger.Files == "" {
+		return "(datasets)"
+	}
+	return "(file trigger)"
+}
+
+// externalLabel names an external dependency as map.step, or map for a
+// whole run, followed by its offset.
+func externalLabel(dep models.ExternalDependency) string {
+	label := dep.Map
+	if dep.Step != "" {
+		label += "." + dep.Step
+	}
+	var notes []string
+	if dep.Offset != 0 {
+		notes = append(notes, dep.Offset.String())
+	}
+	if dep.Timeout > 0 {
+		notes = append(notes, "timeout "+dep.Timeout.String())
+	}
+	if len(notes) > 0 {
+		label += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return label
+}
+
+func runMaps(db *database.DB, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, mapsUsage)
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("maps "+args[0], flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print JSON")
+	yes := fs.Bool("yes", false, "confirm deletion")
+	reason := fs.String("reason", "", "why the map is paused")
+	by := fs.String("by", os.Getenv("USER"), "who pauses the map")
+	cancel := fs.Bool("cancel", false, "cancel the runs in progress")
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return exitUsage
+	}
+
+	if args[0] == "list" {
+		return listMaps(db, *jsonOut)
+	}
+	if len(positional) != 1 {
+		fmt.Fprint(os.Stderr, mapsUsage)
+		return exitUsage
+	}
+	m, err := findMap(db, positional[0])
+	if err != nil {
+		return fail("finding map", err)
+	}
+
+	switch args[0] {
+	case "show":
+		return showMap(db, *m, *jsonOut)
+	case "pause":
+		if *by == "" {
+			*by = "unknown"
+		}
+		if err := db.PauseMap(m.ID, *by, *reason); err != nil {
+			return fail("pausing map", err)
+		}
+		fmt.Printf("Map %s paused\n", m.Name)
+		if *cancel {
+			cancelled, err := db.CancelMapRuns(m.ID)
+			if err != nil {
+				return fail("cancelling runs", err)
+			}
+			for _, run := range cancelled {
+				tracing.EndRun(run, m.Name)
+			}
+			fmt.Printf("Cancelled %d run(s)\n", len(cancelled))
+		}
+	case "unpause":
+		if err := db.UnpauseMap(m.ID); err != nil {
+			return fail("unpausing map", err)
+		}
+		fmt.Printf("Map %s unpaused\n", m.Name)
+	case "delete":
+		if !*yes {
+			fmt.Fprintf(os.Stderr, "Deleting map %s removes its steps and run history, pass --yes to confirm\n", m.Name)
+			return exitUsage
+		}
+		if err := db.DeleteMap(m.ID); err != nil {
+			return fail("deleting map", err)
+		}
+		fmt.Printf("Map %s deleted\n", m.Name)
+	default:
+		fmt.Fprint(os.Stderr, mapsUsage)
+		return exitUsage
+	}
+	return exitOK
+}
+
+// summarizeMap fills in the last and next run of a map.
+func summarizeMap(db *database.DB, m models.Map) (mapSummary, error) {
+	lastRun, err := db.LastScheduledRun(m.ID)
+	if err != nil {
+		return mapSummary{}, err
+	}
+	m.LastRun = lastRun
+	summary := mapSummary{Map: m}
+	if m.IsActive {
+		if next, err := scheduler.NextRunTime(m); err == nil {
+			summary.NextRun = &next
+		}
+	}
+	return summary, nil
+}
+
+func listMaps(db *database.DB, jsonOut bool) int {
+	maps, err := db.GetMaps()
+	if err != nil {
+		return fail("listing maps", err)
+	}
+
+	summaries := []mapSummary{}
+	for _, m := range maps {
+		summary, err := summarizeMap(db, m)
+		if err != nil {
+			return fail("listing maps", err)
+		}
+		summaries = append(summaries, summary)
+	}
+	if jsonOut {
+		return printJSON(summaries)
+	}
+
+	t := newTable()
+	fmt.Fprintln(t, "ID\tNAME\tSCHEDULE\tACTIVE\tLAST RUN\tNEXT RUN")
+	for _, s := range summaries {
+		next := "-"
+		if s.NextRun != nil {
+			next = formatTime(*s.NextRun)
+		}
+		fmt.Fprintf(t, "%d\t%s\t%s\t%t\t%s\t%s\n", s.ID, s.Name, scheduleLabel(s.Map), s.IsActive, formatTime(s.LastRun), next)
+	}
+	t.Flush()
+	return exitOK
+}
+
+func showMap(db *database.DB, m models.Map, jsonOut bool) int {
+	steps, err := db.GetStepsByMapID(m.ID)
+	if err != nil {
+		return fail("getting steps", err)
+	}
+	m.Steps = steps
+	summary, err := summarizeMap(db, m)
+	if err != nil {
+		return fail("getting map", err)
+	}
+	if m.Trigger != nil {
+		if summary.TriggerFiles, err = db.ListTriggerFiles(m.ID, triggerFilesShown); err != nil {
+			return fail("getting trigger files", err)
+		}
+	}
+	if summary.Estimate, err = scheduler.EstimateMap(db, m.ID, steps); err != nil {
+		return fail("estimating durations", err)
+	}
+	if jsonOut {
+		return printJSON(summary)
+	}
+
+	next := "-"
+	if summary.NextRun != nil {
+		next = formatTime(*summary.NextRun)
+	}
+	fmt.Printf("Map:        %s (ID %d)\n", m.Name, m.ID)
+	fmt.Printf("Schedule:   %s\n", scheduleLabel(m))
+	if m.Trigger != nil && m.Trigger.Files != "" {
+		fmt.Printf("Trigger:    files %s", m.Trigger.Files)
+		if m.Trigger.Archive != "" {
+			fmt.Printf(", archived to %s", m.Trigger.Archive)
+		}
+		fmt.Println()
+	}
+	if m.Trigger != nil && len(m.Trigger.Datasets) > 0 {
+		fmt.Printf("Consumes:   %s\n", strings.Join(m.Trigger.Datasets, ", "))
+	}
+	fmt.Printf("Active:     %t\n", m.IsActive)
+	if m.PausedBy != "" && m.PausedAt != nil {
+		fmt.Printf("Paused by:  %s at %s\n", m.PausedBy, formatTime(*m.PausedAt))
+		if m.PauseReason != "" {
+			fmt.Printf("Reason:     %s\n", m.PauseReason)
+		}
+	}
+	fmt.Printf("Start date: %s\n", formatTime(m.StartDate))
+	fmt.Printf("Last run:   %s\n", formatTime(summary.LastRun))
+	fmt.Printf("Next run:   %s\n\n", next)
+
+	names := map[int]string{}
+	for _, step := range steps {
+		names[step.ID] = step.Name
+	}
+	t := newTable()
+	fmt.Fprintln(t, "ID\tSTEP\tCOMMAND\tDEPENDS ON\tRETRIES\tPRODUCES")
+	for _, step := range steps {
+		var deps []string
+		for _, id := range step.Dependencies {
+			deps = append(deps, names[id])
+		}
+		for _, wait := range step.WaitFor {
+			deps = append(deps, externalLabel(wait))
+		}
+		fmt.Fprintf(t, "%d\t%s\t%s\t%s\t%d\t%s\n", step.ID, step.Name, step.Command, strings.Join(deps, ", "), step.Retries,
+			strings.Join(step.Produces, ", "))
+	}
+	t.Flush()
+
+	if e := summary.Estimate; e != nil {
+		var path []string
+		for _, step := range e.CriticalPath {
+			path = append(path, step.Name)
+		}
+		fmt.Println("\nEstimate from past runs:")
+		fmt.Printf("Levels:        %d, up to %d steps in parallel\n", e.Levels, e.MaxParallelism)
+		fmt.Printf("Serial:        %s\n", e.SerialDuration.Round(time.Second))
+		fmt.Printf("Critical path: %s (%s)\n", strings.Join(path, " -> "), e.CriticalDuration.Round(time.Second))
+		if e.Bottleneck != nil {
+			fmt.Printf("Bottleneck:    %s (%s)\n", e.Bottleneck.Name, e.BottleneckDuration.Round(time.Second))
+		}
+	}
+
+	if len(summary.TriggerFiles) > 0 {
+		fmt.Println("\nTrigger files:")
+		t := newTable()
+		fmt.Fprintln(t, "PATH\tSIZE\tDETECTED\tRUN\tARCHIVED TO")
+		for _, f := range summary.TriggerFiles {
+			run, archived := "-", "-"
+			if f.RunID != 0 {
+				run = fmt.Sprint(f.RunID)
+			}
+			if f.ArchivedTo != "" {
+				archived = f.ArchivedTo
+			}
+			fmt.Fprintf(t, "%s\t%d\t%s\t%s\t%s\n", f.Path, f.Size, formatTime(f.DetectedAt), run, archived)
+		}
+		t.Flush()
+	}
+	return exitOK
+}
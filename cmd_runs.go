@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/loader"
+	"pilot/pkg/models"
+	"pilot/pkg/scheduler"
+)
+
+const runsUsage = `usage: pilot runs <command> [arguments]
+
+commands:
+  list [--map MAP] [--limit N] [--json]
+  show <run id> [--json]
+`
+
+// runDetail is a map run together with its step instances, the earlier
+// attempts of the steps that were cleared, the states forced by operators,
+// the SLAs it missed, the dataset updates that started it and what its
+// pending steps wait for in other maps.
+type runDetail struct {
+	models.MapRun
+	Steps     []models.StepRun      `json:"steps"`
+	History   []models.StepRun      `json:"history,omitempty"`
+	Marks     []models.StepRunMark  `json:"marks,omitempty"`
+	SLAMisses []models.SLAMiss      `json:"sla_misses,omitempty"`
+	Datasets  []models.DatasetEvent `json:"dataset_events,omitempty"` // Dataset updates that started the run
+	WaitingOn []models.ExternalWait `json:"waiting_on,omitempty"`     // Steps and runs of other maps pending steps wait for
+}
+
+func runRuns(db *database.DB, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, runsUsage)
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("runs "+args[0], flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print JSON")
+	mapRef := fs.String("map", "", "only list the runs of this map")
+	limit := fs.Int("limit", 20, "maximum number of runs to list, 0 for all")
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "list":
+		mapID := 0
+		if *mapRef != "" {
+			m, err := findMap(db, *mapRef)
+			if err != nil {
+				return fail("finding map", err)
+			}
+			mapID = m.ID
+		}
+		runs, err := db.ListMapRuns(mapID, *limit)
+		if err != nil {
+			return fail("listing runs", err)
+		}
+		if *jsonOut {
+			if runs == nil {
+				runs = []models.MapRun{}
+			}
+			return printJSON(runs)
+		}
+		t := newTable()
+		fmt.Fprintln(t, "ID\tMAP\tTYPE\tLOGICAL DATE\tSTATE\tSTARTED\tENDED")
+		for _, run := range runs {
+			fmt.Fprintf(t, "%d\t%d\t%s\t%s\t%s\t%s\t%s\n", run.ID, run.MapID, run.RunType,
+				formatTime(run.LogicalDate), run.State, formatTime(run.StartDate), formatTime(run.EndDate))
+		}
+		t.Flush()
+
+	case "show":
+		if len(positional) != 1 {
+			fmt.Fprint(os.Stderr, runsUsage)
+			return exitUsage
+		}
+		run, err := findRun(db, positional[0])
+		if err != nil {
+			return fail("finding run", err)
+		}
+		steps, err := db.GetStepRuns(run.ID)
+		if err != nil {
+			return fail("getting steps", err)
+		}
+		history, err := db.GetStepRunAttempts(run.ID)
+		if err != nil {
+			return fail("getting attempts", err)
+		}
+		marks, err := db.GetStepRunMarks(run.ID)
+		if err != nil {
+			return fail("getting marks", err)
+		}
+		misses, err := db.GetSLAMisses(run.ID)
+		if err != nil {
+			return fail("getting SLA misses", err)
+		}
+		datasets, err := db.GetRunDatasetEvents(run.ID)
+		if err != nil {
+			return fail("getting dataset updates", err)
+		}
+		waits, err := scheduler.RunWaits(db, *run)
+		if err != nil {
+			return fail("getting external dependencies", err)
+		}
+		if *jsonOut {
+			if steps == nil {
+				steps = []models.StepRun{}
+			}
+			return printJSON(runDetail{MapRun: *run, Steps: steps, History: history, Marks: marks, SLAMisses: misses, Datasets: datasets,
+				WaitingOn: waits})
+		}
+		fmt.Printf("Run:          %d (%s)\n", run.ID, run.RunType)
+		fmt.Printf("Map:          %d\n", run.MapID)
+		fmt.Printf("Logical date: %s\n", formatTime(run.LogicalDate))
+		fmt.Printf("State:        %s\n", run.State)
+		if len(run.Conf) > 0 {
+			conf, _ := json.Marshal(run.Conf)
+			fmt.Printf("Conf:         %s\n", conf)
+		}
+		for i, e := range datasets {
+			label := ""
+			if i == 0 {
+				label = "Triggered by:"
+			}
+			fmt.Printf("%-13s %s updated by run %d at %s\n", label, e.Dataset, e.RunID, formatTime(e.CreatedAt))
+		}
+		fmt.Println()
+		t := newTable()
+		fmt.Fprintln(t, "STEP\tSTATE\tATTEMPT\tSTARTED\tENDED")
+		for _, step := range steps {
+			fmt.Fprintf(t, "%s\t%s\t%d\t%s\t%s\n", step.StepName, step.State, step.Attempt, formatTime(step.StartDate), formatTime(step.EndDate))
+		}
+		t.Flush()
+
+		if len(history) > 0 {
+			fmt.Println("\nEarlier attempts:")
+			t := newTable()
+			fmt.Fprintln(t, "STEP\tSTATE\tATTEMPT\tSTARTED\tENDED")
+			for _, step := range history {
+				fmt.Fprintf(t, "%s\t%s\t%d\t%s\t%s\n", step.StepName, step.State, step.Attempt, formatTime(step.StartDate), formatTime(step.EndDate))
+			}
+			t.Flush()
+		}
+		if len(marks) > 0 {
+			fmt.Println("\nMarked by hand:")
+			t := newTable()
+			fmt.Fprintln(t, "STEP\tFROM\tTO\tBY\tAT\tNOTE")
+			for _, m := range marks {
+				fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\t%s\n", m.StepName, m.PreviousState, m.State, m.MarkedBy, formatTime(m.MarkedAt), m.Note)
+			}
+			t.Flush()
+		}
+		if len(waits) > 0 {
+			fmt.Println("\nWaiting on other maps:")
+			t := newTable()
+			fmt.Fprintln(t, "STEP\tMAP\tWAITS FOR\tLOGICAL DATE\tRUN\tSTATE")
+			for _, w := range waits {
+				target, run := w.Step, "-"
+				if target == "" {
+					target = "(run)"
+				}
+				if w.RunID != 0 {
+					run = fmt.Sprint(w.RunID)
+				}
+				fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\t%s\n", w.StepName, w.Map, target, formatTime(w.LogicalDate), run, w.State)
+			}
+			t.Flush()
+		}
+		if len(misses) > 0 {
+			fmt.Println("\nSLA misses:")
+			t := newTable()
+			fmt.Fprintln(t, "STEP\tDEADLINE\tSTATE\tDETECTED")
+			for _, miss := range misses {
+				step := miss.StepName
+				if miss.StepID == 0 {
+					step = "(run)"
+				}
+				fmt.Fprintf(t, "%s\t%s\t%s\t%s\n", step, formatTime(miss.Deadline), miss.State, formatTime(miss.DetectedAt))
+			}
+			t.Flush()
+		}
+
+	default:
+		fmt.Fprint(os.Stderr, runsUsage)
+		return exitUsage
+	}
+	return exitOK
+}
+
+// findRun looks a map run up by ID.
+func findRun(db *database.DB, ref string) (*models.MapRun, error) {
+	id, err := strconv.Atoi(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run id %q", ref)
+	}
+	return db.GetMapRun(id)
+}
+
+// runTrigger starts a manual run of a map outside of its schedule.
+func runTrigger(db *database.DB, args []string) int {
+	fs := flag.NewFlagSet("trigger", flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print JSON")
+	confJSON := fs.String("conf", "", "JSON object passed to the steps of the run")
+	logicalDate := fs.String("logical-date", "", "logical date of the run, YYYY-MM-DD or RFC 3339 (default now)")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: pilot trigger <map> [--conf JSON] [--logical-date DATE] [--json]")
+		return exitUsage
+	}
+
+	var conf map[string]any
+	if *confJSON != "" {
+		if err := json.Unmarshal([]byte(*confJSON), &conf); err != nil {
+			fmt.Fprintf(os.Stderr, "--conf must be a JSON object: %v\n", err)
+			return exitUsage
+		}
+	}
+	date := time.Now().Truncate(time.Second)
+	if *logicalDate != "" {
+		if date, err = loader.ParseDate(*logicalDate); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+
+	m, err := findMap(db, positional[0])
+	if err != nil {
+		return fail("finding map", err)
+	}
+	run, err := scheduler.CreateRun(db, *m, "manual", date, conf)
+	if err != nil {
+		return fail("triggering map", err)
+	}
+	if *jsonOut {
+		return printJSON(run)
+	}
+	fmt.Printf("Created run %d of map %s\n", run.ID, m.Name)
+	return exitOK
+}
+
+// stepsFlag collects repeated --step flags.
+type stepsFlag []string
+
+func (f *stepsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stepsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+const stepsUsage = `usage: pilot steps <command> [arguments]
+
+commands:
+  clear --run ID --step NAME [--step NAME...] [--upstream] [--downstream] [--json]
+  mark --run ID --step NAME --state success|failed|skipped --note TEXT [--by NAME] [--json]
+  logs --run ID --step NAME [--json]
+`
+
+// runSteps clears, marks or shows the output of step instances of a map run.
+func runSteps(db *database.DB, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, stepsUsage)
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("steps "+args[0], flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print JSON")
+	runRef := fs.String("run", "", "map run ID")
+	var names stepsFlag
+	fs.Var(&names, "step", "name of a step, may be repeated for clear")
+	upstream := fs.Bool("upstream", false, "also clear the steps they depend on")
+	downstream := fs.Bool("downstream", false, "also clear the steps that depend on them")
+	state := fs.String("state", "", "state to mark the step with: success, failed or skipped")
+	note := fs.String("note", "", "why the step is marked")
+	by := fs.String("by", os.Getenv("USER"), "who marks the step")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if *runRef == "" || len(names) == 0 {
+		fmt.Fprint(os.Stderr, stepsUsage)
+		return exitUsage
+	}
+
+	run, err := findRun(db, *runRef)
+	if err != nil {
+		return fail("finding run", err)
+	}
+
+	switch args[0] {
+	case "clear":
+		cleared, err := scheduler.ClearSteps(db, run.ID, scheduler.ClearOptions{Steps: names, Upstream: *upstream, Downstream: *downstream})
+		if err != nil {
+			return fail("clearing steps", err)
+		}
+		if *jsonOut {
+			return printJSON(cleared)
+		}
+		for _, step := range cleared {
+			fmt.Printf("Cleared step %s of run %d\n", step.StepName, run.ID)
+		}
+	case "mark":
+		if len(names) != 1 || *state == "" || *note == "" {
+			fmt.Fprint(os.Stderr, stepsUsage)
+			return exitUsage
+		}
+		if *by == "" {
+			*by = "unknown"
+		}
+		mark, err := scheduler.MarkStep(db, run.ID, scheduler.MarkOptions{Step: names[0], State: *state, By: *by, Note: *note})
+		if errors.Is(err, scheduler.ErrInvalidMark) {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		if err != nil {
+			return fail("marking step", err)
+		}
+		if *jsonOut {
+			return printJSON(mark)
+		}
+		fmt.Printf("Marked step %s of run %d %s (was %s)\n", mark.StepName, run.ID, *state, mark.PreviousState)
+	case "logs":
+		if len(names) != 1 {
+			fmt.Fprint(os.Stderr, stepsUsage)
+			return exitUsage
+		}
+		return showLogs(db, run.ID, names[0], *jsonOut)
+	default:
+		fmt.Fprint(os.Stderr, stepsUsage)
+		return exitUsage
+	}
+	return exitOK
+}
+
+// showLogs prints the output of every attempt of a step of a run.
+func showLogs(db *database.DB, runID int, name string, jsonOut bool) int {
+	steps, err := db.GetStepRuns(runID)
+	if err != nil {
+		return fail("getting steps", err)
+	}
+	for _, step := range steps {
+		if step.StepName != name {
+			continue
+		}
+		logs, err := db.GetStepLogs(runID, step.StepID)
+		if err != nil {
+			return fail("getting logs", err)
+		}
+		if jsonOut {
+			if logs == nil {
+				logs = []models.StepLog{}
+			}
+			return printJSON(logs)
+		}
+		for _, l := range logs {
+			fmt.Printf("--- attempt %d, %s ---\n%s\n", l.Attempt, formatTime(l.CreatedAt), strings.TrimRight(l.Output, "\n"))
+		}
+		return exitOK
+	}
+	return fail("finding step", fmt.Errorf("step %s %w in run %d", name, errNotFound, runID))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/internal/metrics"
+	"pilot/pkg/loader"
+	"pilot/pkg/models"
+	"pilot/pkg/scheduler"
+	"pilot/pkg/worker"
+)
+
+// runScheduler loads the map files, then schedules map runs and executes
+// their steps with local workers until the process is stopped.
+func runScheduler(db *database.DB, args []string) int {
+	fs := flag.NewFlagSet("scheduler", flag.ContinueOnError)
+	workers := fs.Int("workers", 4, "number of local workers, 0 to leave the steps to \"pilot worker\" processes")
+	interval := fs.Duration("interval", scheduler.DefaultInterval, "how often to check for due maps")
+	queueSize := fs.Int("queue-size", 20, "size of the task queue")
+	mapsDir := fs.String("maps-dir", loader.Dir(), "folder holding the map definition files")
+	metricsAddr := fs.String("metrics-addr", ":9090", "address to serve /metrics on, empty to disable")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *workers < 0 || *queueSize < 1 {
+		fmt.Fprintln(os.Stderr, "--workers must be >= 0 and --queue-size >= 1")
+		return exitUsage
+	}
+
+	serveMetrics(*metricsAddr)
+
+	// Load map definition files into the database and keep watching them
+	watcher := loader.NewWatcher(db, *mapsDir)
+	watcher.Reload()
+	go watcher.Watch()
+
+	s := scheduler.NewScheduler(db, *queueSize)
+	s.Interval = *interval
+	if *workers == 0 {
+		// Queued steps stay in the database for the worker processes
+		s.QueueTaskFunc = func(step models.Step) {}
+	}
+	for i := 1; i <= *workers; i++ {
+		logger := slog.Default().With("worker", i)
+		w := &worker.Worker{}
+		go w.StartWorker(s.TaskQueue, db, s, logger)
+	}
+
+	s.Start()
+	return exitOK
+}
+
+// runWorker executes the steps queued in the database by a scheduler started
+// with --workers 0.
+func runWorker(db *database.DB, args []string) int {
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	poll := fs.Duration("poll", 5*time.Second, "how often to check for queued steps when idle")
+	metricsAddr := fs.String("metrics-addr", ":9091", "address to serve /metrics on, empty to disable")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	serveMetrics(*metricsAddr)
+
+	w := &worker.Worker{
+		DatabaseClient: db,
+		Logger:         slog.Default(),
+	}
+	w.Poll(*poll)
+	return exitOK
+}
+
+// serveMetrics exposes the Prometheus metrics on addr in the background. The
+// scheduler and workers keep running when the address is not available.
+func serveMetrics(addr string) {
+	if addr == "" {
+		return
+	}
+	errs := metrics.Serve(addr)
+	go func() {
+		slog.Error("Metrics server stopped", "addr", addr, "error", <-errs)
+	}()
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+const usersUsage = `usage: pilot users <command> [arguments]
+
+commands:
+  add <name> --role viewer|operator|admin [--password-stdin]
+  list [--json]
+  passwd <name> --password-stdin
+  role <name> viewer|operator|admin
+  grant <name> --map MAP --role viewer|operator|admin
+  revoke <name> --map MAP
+  delete <name>
+`
+
+const tokensUsage = `usage: pilot tokens <command> [arguments]
+
+commands:
+  create <user> [--name LABEL]
+  list [--user NAME] [--json]
+  revoke <id>
+`
+
+// runUsers manages the users of the HTTP API and returns the process exit
+// code.
+func runUsers(db *database.DB, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usersUsage)
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("users "+args[0], flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print JSON")
+	role := fs.String("role", "", "role of the user")
+	mapRef := fs.String("map", "", "map name or ID")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from the first line of stdin")
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return exitUsage
+	}
+
+	if args[0] == "list" {
+		users, err := db.ListUsers()
+		if err != nil {
+			return fail("listing users", err)
+		}
+		if *jsonOut {
+			if users == nil {
+				users = []models.User{}
+			}
+			return printJSON(users)
+		}
+		t := newTable()
+		fmt.Fprintln(t, "NAME\tROLE\tMAP PERMISSIONS\tCREATED")
+		for _, u := range users {
+			fmt.Fprintf(t, "%s\t%s\t%s\t%s\n", u.Name, u.Role, formatPermissions(db, u.Permissions), formatTime(u.CreatedAt))
+		}
+		t.Flush()
+		return exitOK
+	}
+	if len(positional) == 0 {
+		fmt.Fprint(os.Stderr, usersUsage)
+		return exitUsage
+	}
+	name := positional[0]
+
+	switch args[0] {
+	case "add":
+		if *role == "" {
+			fmt.Fprint(os.Stderr, usersUsage)
+			return exitUsage
+		}
+		var password string
+		if *passwordStdin {
+			if password, err = readPassword(); err != nil {
+				return fail("reading password", err)
+			}
+		}
+		if _, err := db.CreateUser(name, password, *role); err != nil {
+			return fail("adding user", err)
+		}
+		fmt.Printf("Added %s %s\n", *role, name)
+	case "passwd":
+		if !*passwordStdin {
+			fmt.Fprint(os.Stderr, usersUsage)
+			return exitUsage
+		}
+		password, err := readPassword()
+		if err != nil {
+			return fail("reading password", err)
+		}
+		if err := db.SetUserPassword(name, password); err != nil {
+			return fail("setting password", err)
+		}
+		fmt.Printf("Changed the password of %s\n", name)
+	case "role":
+		if len(positional) != 2 {
+			fmt.Fprint(os.Stderr, usersUsage)
+			return exitUsage
+		}
+		if err := db.SetUserRole(name, positional[1]); err != nil {
+			return fail("setting role", err)
+		}
+		fmt.Printf("%s is now %s\n", name, positional[1])
+	case "grant", "revoke":
+		if *mapRef == "" || (args[0] == "grant" && *role == "") {
+			fmt.Fprint(os.Stderr, usersUsage)
+			return exitUsage
+		}
+		user, err := db.GetUser(name)
+		if err != nil {
+			return fail("finding user", err)
+		}
+		m, err := findMap(db, *mapRef)
+		if err != nil {
+			return fail("finding map", err)
+		}
+		if args[0] == "grant" {
+			if err := db.GrantMap(user.ID, m.ID, *role); err != nil {
+				return fail("granting role", err)
+			}
+			fmt.Printf("%s is %s on map %s\n", name, *role, m.Name)
+		} else {
+			if err := db.RevokeMap(user.ID, m.ID); err != nil {
+				return fail("revoking role", err)
+			}
+			fmt.Printf("Revoked the role of %s on map %s\n", name, m.Name)
+		}
+	case "delete":
+		if err := db.DeleteUser(name); err != nil {
+			return fail("deleting user", err)
+		}
+		fmt.Printf("Deleted user %s\n", name)
+	default:
+		fmt.Fprint(os.Stderr, usersUsage)
+		return exitUsage
+	}
+	return exitOK
+}
+
+// readPassword reads a password from the first line of stdin.
+func readPassword() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("no password on stdin: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// formatPermissions lists the roles granted on single maps as map=role.
+func formatPermissions(db *database.DB, permissions map[int]string) string {
+	var grants []string
+	for mapID, role := range permissions {
+		name := strconv.Itoa(mapID)
+		if m, err := db.GetMapByID(mapID); err == nil {
+			name = m.Name
+		}
+		grants = append(grants, name+"="+role)
+	}
+	if len(grants) == 0 {
+		return "-"
+	}
+	sort.Strings(grants)
+	return strings.Join(grants, ", ")
+}
+
+// runTokens manages the API tokens of users and returns the process exit
+// code.
+func runTokens(db *database.DB, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, tokensUsage)
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("tokens "+args[0], flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print JSON")
+	label := fs.String("name", "", "label of the token")
+	userName := fs.String("user", "", "only list the tokens of this user")
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "create":
+		if len(positional) != 1 {
+			fmt.Fprint(os.Stderr, tokensUsage)
+			return exitUsage
+		}
+		user, err := db.GetUser(positional[0])
+		if err != nil {
+			return fail("finding user", err)
+		}
+		token, _, err := db.CreateToken(user.ID, *label)
+		if err != nil {
+			return fail("creating token", err)
+		}
+		fmt.Fprintf(os.Stderr, "Created a token for %s, it is not shown again:\n", user.Name)
+		fmt.Println(token)
+	case "list":
+		userID := 0
+		if *userName != "" {
+			user, err := db.GetUser(*userName)
+			if err != nil {
+				return fail("finding user", err)
+			}
+			userID = user.ID
+		}
+		tokens, err := db.ListTokens(userID)
+		if err != nil {
+			return fail("listing tokens", err)
+		}
+		if *jsonOut {
+			if tokens == nil {
+				tokens = []models.APIToken{}
+			}
+			return printJSON(tokens)
+		}
+		t := newTable()
+		fmt.Fprintln(t, "ID\tUSER\tNAME\tCREATED\tLAST USED")
+		for _, token := range tokens {
+			lastUsed := "-"
+			if token.LastUsedAt != nil {
+				lastUsed = formatTime(*token.LastUsedAt)
+			}
+			fmt.Fprintf(t, "%d\t%s\t%s\t%s\t%s\n", token.ID, token.UserName, token.Name, formatTime(token.CreatedAt), lastUsed)
+		}
+		t.Flush()
+	case "revoke":
+		if len(positional) != 1 {
+			fmt.Fprint(os.Stderr, tokensUsage)
+			return exitUsage
+		}
+		id, err := strconv.Atoi(positional[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid token id %q\n", positional[0])
+			return exitUsage
+		}
+		if err := db.RevokeToken(id); err != nil {
+			return fail("revoking token", err)
+		}
+		fmt.Printf("Revoked token %d\n", id)
+	default:
+		fmt.Fprint(os.Stderr, tokensUsage)
+		return exitUsage
+	}
+	return exitOK
+}
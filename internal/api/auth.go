@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+type contextKey int
+
+const userKey contextKey = iota
+
+// DisableAuth lets every request through without credentials, with admin
+// privileges. It is meant for local development only.
+func (s *Server) DisableAuth() {
+	s.noAuth = true
+}
+
+// authenticate identifies the user of a request from a bearer token or basic
+// auth credentials, and answers 401 when there are none or they are wrong.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if s.noAuth {
+		return r, true
+	}
+
+	var user *models.User
+	var err error
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		user, err = s.db.AuthenticateToken(token)
+	} else if name, password, ok := r.BasicAuth(); ok {
+		user, err = s.db.Authenticate(name, password)
+	} else {
+		err = database.ErrInvalidCredentials
+	}
+	if errors.Is(err, database.ErrInvalidCredentials) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="pilot"`)
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return r, false
+	}
+	if err != nil {
+		writeDBError(w, err)
+		return r, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), userKey, user)), true
+}
+
+// currentUser returns the authenticated user of a request, or nil when
+// authentication is disabled.
+func currentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userKey).(*models.User)
+	return user
+}
+
+// authorize checks that the user of a request holds at least the role need on
+// a map, or on every map when mapID is 0, and answers 403 otherwise.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, mapID int, need string) bool {
+	if s.noAuth {
+		return true
+	}
+	user := currentUser(r)
+	role := user.Role
+	if mapID != 0 {
+		role = user.MapRole(mapID)
+	}
+	if !models.RoleAllows(role, need) {
+		writeError(w, http.StatusForbidden, "the "+need+" role is required")
+		return false
+	}
+	return true
+}
+
+// actor returns the name recorded in audit trails for a request: the
+// authenticated user, or the name given in the request when authentication
+// is disabled.
+func actor(r *http.Request, given string) string {
+	if user := currentUser(r); user != nil {
+		return user.Name
+	}
+	return given
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+// newAuthServer returns a server enforcing authentication, with a token for
+// each role and the maps sales and billing.
+func newAuthServer(t *testing.T) (*Server, *database.DB, map[string]string) {
+	t.Helper()
+	s, db := newTestServer(t)
+	s.noAuth = false
+	billing := models.NewMap("billing", "0 * * * *", time.Time{}, time.Time{}, []models.Step{{Name: "fetch", Command: "fetch.py"}})
+	if _, _, err := db.SyncMap(*billing, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := map[string]string{}
+	for _, role := range []string{models.RoleViewer, models.RoleOperator, models.RoleAdmin} {
+		user, err := db.CreateUser(role+"-user", "secret", role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokens[role], _, err = db.CreateToken(user.ID, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, db, tokens
+}
+
+func doAs(s *Server, token, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthentication(t *testing.T) {
+	s, _, tokens := newAuthServer(t)
+
+	rec := doAs(s, "", http.MethodGet, "/api/maps", ``)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("anonymous: status = %d, want %d with a challenge", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := doAs(s, "pilot_bogus", http.MethodGet, "/api/maps", ``); rec.Code != http.StatusUnauthorized {
+		t.Errorf("bad token: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := doAs(s, tokens[models.RoleViewer], http.MethodGet, "/api/maps", ``); rec.Code != http.StatusOK {
+		t.Errorf("token: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := doAs(s, "", http.MethodGet, "/health", ``); rec.Code != http.StatusOK {
+		t.Errorf("health: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	for password, want := range map[string]int{"secret": http.StatusOK, "wrong": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/api/maps", nil)
+		req.SetBasicAuth("viewer-user", password)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("basic auth with %s: status = %d, want %d", password, rec.Code, want)
+		}
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	s, db, tokens := newAuthServer(t)
+	viewer, operator, admin := tokens[models.RoleViewer], tokens[models.RoleOperator], tokens[models.RoleAdmin]
+
+	tests := []struct {
+		token, method, path, body string
+		want                      int
+	}{
+		{viewer, http.MethodGet, "/api/maps/sales", ``, http.StatusOK},
+		{viewer, http.MethodPost, "/api/maps/sales/trigger", ``, http.StatusForbidden},
+		{viewer, http.MethodDelete, "/api/maps/sales", ``, http.StatusForbidden},
+		{operator, http.MethodPost, "/api/maps/sales/trigger", ``, http.StatusCreated},
+		{operator, http.MethodPut, "/api/maps/sales/steps/load", `{"command": "load.py"}`, http.StatusForbidden},
+		{operator, http.MethodPost, "/api/maps", `{"name": "x"}`, http.StatusForbidden},
+		{operator, http.MethodPost, "/api/runs/1/clear", `{"steps": ["extract"]}`, http.StatusOK},
+		{viewer, http.MethodPost, "/api/runs/1/mark", `{"step": "load", "state": "skipped", "note": "x"}`, http.StatusForbidden},
+		{admin, http.MethodDelete, "/api/maps/billing", ``, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if rec := doAs(s, tt.token, tt.method, tt.path, tt.body); rec.Code != tt.want {
+			t.Errorf("%s %s: status = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	// A role granted on a single map applies to that map only
+	user, err := db.GetUser("viewer-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sales, _ := db.GetMapByName("sales")
+	if err := db.GrantMap(user.ID, sales.ID, models.RoleOperator); err != nil {
+		t.Fatal(err)
+	}
+	rec := doAs(s, viewer, http.MethodPost, "/api/maps/sales/pause", `{"by": "someone else", "reason": "audit"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("granted pause: status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp pauseResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Map.PausedBy != "viewer-user" {
+		t.Errorf("paused by %q, want the authenticated user", resp.Map.PausedBy)
+	}
+	if rec := doAs(s, viewer, http.MethodPost, "/api/maps/sales/steps", `{"name": "x", "command": "x.py"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("granted operator adding a step: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
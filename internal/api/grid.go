@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"pilot/internal/database"
+	"pilot/pkg/graph"
+	"pilot/pkg/models"
+)
+
+// gridRun is a column of the run grid: a map run and the state of each of
+// its steps by step ID.
+type gridRun struct {
+	models.MapRun
+	States map[int]string `json:"states"`
+}
+
+// grid is the state of the steps of a map over its recent runs.
+type grid struct {
+	Steps []models.Step `json:"steps"` // in dependency order
+	Runs  []gridRun     `json:"runs"`  // most recent first
+}
+
+// getGrid returns the state of each step of a map in its recent runs, limited
+// by the limit query parameter.
+func (s *Server) getGrid(w http.ResponseWriter, r *http.Request, m models.Map) {
+	limit, _, err := pagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	steps, err := s.db.GetStepsByMapID(m.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if sorted, err := graph.Sort(steps); err == nil {
+		steps = sorted
+	}
+	runs, _, err := s.db.FindMapRuns(database.RunFilter{MapID: m.ID, Limit: limit})
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	g := grid{Steps: append([]models.Step{}, steps...), Runs: []gridRun{}}
+	for _, run := range runs {
+		instances, err := s.db.GetStepRuns(run.ID)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		column := gridRun{MapRun: run, States: map[int]string{}}
+		for _, instance := range instances {
+			column.States[instance.StepID] = instance.State
+		}
+		g.Runs = append(g.Runs, column)
+	}
+	writeJSON(w, http.StatusOK, g)
+}
+
+// getGraph renders the steps of a map and their dependencies as JSON, with
+// the states of the steps in the run given by the run query parameter.
+func (s *Server) getGraph(w http.ResponseWriter, r *http.Request, m models.Map) {
+	steps, err := s.db.GetStepsByMapID(m.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	var states map[int]string
+	if ref := r.URL.Query().Get("run"); ref != "" {
+		runID, err := strconv.Atoi(ref)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid run id "+strconv.Quote(ref))
+			return
+		}
+		run, err := s.db.GetMapRun(runID)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		if run.MapID != m.ID {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("run %d is not a run of map %s", runID, m.Name))
+			return
+		}
+		instances, err := s.db.GetStepRuns(runID)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		states = map[int]string{}
+		for _, instance := range instances {
+			states[instance.StepID] = instance.State
+		}
+	}
+
+	out, err := graph.New(steps).JSON(m.Name, states)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
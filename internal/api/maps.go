@@ -0,0 +1,260 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/graph"
+	"pilot/pkg/loader"
+	"pilot/pkg/models"
+	"pilot/pkg/scheduler"
+)
+
+// mapDetail is a map together with its next scheduled run, and the changes
+// made when it was created or updated.
+type mapDetail struct {
+	models.Map
+	NextRun  *time.Time           `json:"next_run,omitempty"`
+	Estimate *graph.Estimate      `json:"estimate,omitempty"` // Durations from past runs, with the steps
+	Changes  []database.MapChange `json:"changes,omitempty"`
+}
+
+// handleMaps dispatches /api/maps requests.
+func (s *Server) handleMaps(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r, "/api/maps")
+	if len(parts) == 0 {
+		switch {
+		case r.Method == http.MethodGet:
+			s.listMaps(w, r)
+		case allowMethods(w, r, http.MethodGet, http.MethodPost) && s.authorize(w, r, 0, models.RoleAdmin):
+			s.createMap(w, r)
+		}
+		return
+	}
+
+	m, err := s.findMap(parts[0])
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if r.Method != http.MethodGet && !s.authorize(w, r, m.ID, requiredRole(parts)) {
+		return
+	}
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			s.getMap(w, *m, nil)
+		case http.MethodPut:
+			s.updateMap(w, r, *m)
+		case http.MethodDelete:
+			s.deleteMap(w, *m)
+		default:
+			allowMethods(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+		}
+	case len(parts) == 2 && parts[1] == "steps":
+		switch {
+		case r.Method == http.MethodGet:
+			s.listSteps(w, *m)
+		case allowMethods(w, r, http.MethodGet, http.MethodPost):
+			s.createStep(w, r, *m)
+		}
+	case len(parts) == 3 && parts[1] == "steps":
+		switch r.Method {
+		case http.MethodGet:
+			s.getStep(w, *m, parts[2])
+		case http.MethodPut:
+			s.updateStep(w, r, *m, parts[2])
+		case http.MethodDelete:
+			s.deleteStep(w, *m, parts[2])
+		default:
+			allowMethods(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+		}
+	case len(parts) == 2 && parts[1] == "graph":
+		if allowMethods(w, r, http.MethodGet) {
+			s.getGraph(w, r, *m)
+		}
+	case len(parts) == 2 && parts[1] == "grid":
+		if allowMethods(w, r, http.MethodGet) {
+			s.getGrid(w, r, *m)
+		}
+	case len(parts) == 2 && parts[1] == "trigger":
+		if allowMethods(w, r, http.MethodPost) {
+			s.handleTrigger(w, r, *m)
+		}
+	case len(parts) == 2 && parts[1] == "pause":
+		if allowMethods(w, r, http.MethodPost) {
+			s.handlePause(w, r, *m)
+		}
+	case len(parts) == 2 && parts[1] == "unpause":
+		if allowMethods(w, r, http.MethodPost) {
+			s.handleUnpause(w, *m)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// summary fills in the last and next run of a map.
+func (s *Server) summary(m models.Map) (mapDetail, error) {
+	var err error
+	if m.LastRun, err = s.db.LastScheduledRun(m.ID); err != nil {
+		return mapDetail{}, err
+	}
+	d := mapDetail{Map: m}
+	if m.IsActive {
+		if next, err := scheduler.NextRunTime(m); err == nil {
+			d.NextRun = &next
+		}
+	}
+	return d, nil
+}
+
+// requiredRole returns the role needed to change the map resource at the
+// given path: operators run maps, admins change their definition.
+func requiredRole(parts []string) string {
+	if len(parts) == 2 && (parts[1] == "trigger" || parts[1] == "pause" || parts[1] == "unpause") {
+		return models.RoleOperator
+	}
+	return models.RoleAdmin
+}
+
+// detail fills in the steps, last and next run of a map, and the estimate of
+// its duration.
+func (s *Server) detail(m models.Map) (mapDetail, error) {
+	steps, err := s.db.GetStepsByMapID(m.ID)
+	if err != nil {
+		return mapDetail{}, err
+	}
+	m.Steps = steps
+	d, err := s.summary(m)
+	if err != nil {
+		return mapDetail{}, err
+	}
+	d.Estimate, err = scheduler.EstimateMap(s.db, m.ID, steps)
+	return d, err
+}
+
+func (s *Server) listMaps(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	maps, err := s.db.GetMaps()
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	summaries := []mapDetail{}
+	for _, m := range maps {
+		d, err := s.summary(m)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		summaries = append(summaries, d)
+	}
+	writeJSON(w, http.StatusOK, paginate(summaries, limit, offset))
+}
+
+func (s *Server) getMap(w http.ResponseWriter, m models.Map, changes []database.MapChange) {
+	d, err := s.detail(m)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	d.Changes = changes
+	writeJSON(w, http.StatusOK, d)
+}
+
+// createMap stores a new map from a definition in the map file format.
+func (s *Server) createMap(w http.ResponseWriter, r *http.Request) {
+	var def loader.MapDefinition
+	if !decodeBody(w, r, &def, true) {
+		return
+	}
+	if _, err := s.db.GetMapByName(def.Name); err == nil {
+		writeError(w, http.StatusConflict, "map "+def.Name+" already exists")
+		return
+	}
+	m, changes, ok := s.save(w, &def)
+	if !ok {
+		return
+	}
+	d, err := s.detail(*m)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	d.Changes = changes
+	writeJSON(w, http.StatusCreated, d)
+}
+
+// updateMap replaces the definition of a map.
+func (s *Server) updateMap(w http.ResponseWriter, r *http.Request, m models.Map) {
+	var def loader.MapDefinition
+	if !decodeBody(w, r, &def, true) {
+		return
+	}
+	if def.Name == "" {
+		def.Name = m.Name
+	}
+	if def.Name != m.Name {
+		writeError(w, http.StatusBadRequest, "maps cannot be renamed")
+		return
+	}
+	updated, changes, ok := s.save(w, &def)
+	if ok {
+		s.getMap(w, *updated, changes)
+	}
+}
+
+func (s *Server) deleteMap(w http.ResponseWriter, m models.Map) {
+	if err := s.db.DeleteMap(m.ID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// save validates a definition and stores it, answering 422 when it is
+// invalid.
+func (s *Server) save(w http.ResponseWriter, def *loader.MapDefinition) (*models.Map, []database.MapChange, bool) {
+	if err := def.Validate(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return nil, nil, false
+	}
+	id, changes, err := loader.Load(s.db, def)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return nil, nil, false
+	}
+	m, err := s.db.GetMapByID(id)
+	if err != nil {
+		writeDBError(w, err)
+		return nil, nil, false
+	}
+	return m, changes, true
+}
+
+// definition returns the stored definition of a map.
+func (s *Server) definition(m models.Map) (*loader.MapDefinition, error) {
+	steps, err := s.db.GetStepsByMapID(m.ID)
+	if err != nil {
+		return nil, err
+	}
+	m.Steps = steps
+	return loader.FromMap(m), nil
+}
+
+// findStep returns the index of the named step in a definition.
+func findStep(def *loader.MapDefinition, name string) (int, bool) {
+	for i, step := range def.Steps {
+		if step.Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+
+	"pilot/pkg/loader"
+	"pilot/pkg/models"
+)
+
+func (s *Server) listSteps(w http.ResponseWriter, m models.Map) {
+	steps, err := s.db.GetStepsByMapID(m.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if steps == nil {
+		steps = []models.Step{}
+	}
+	writeJSON(w, http.StatusOK, steps)
+}
+
+func (s *Server) getStep(w http.ResponseWriter, m models.Map, name string) {
+	steps, err := s.db.GetStepsByMapID(m.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	for _, step := range steps {
+		if step.Name == name {
+			writeJSON(w, http.StatusOK, step)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "step "+name+" not found")
+}
+
+// createStep adds a step, given in the map file format, to a map.
+func (s *Server) createStep(w http.ResponseWriter, r *http.Request, m models.Map) {
+	var step loader.StepDefinition
+	if !decodeBody(w, r, &step, true) {
+		return
+	}
+	s.editSteps(w, m, step.Name, http.StatusCreated, func(def *loader.MapDefinition) (int, string) {
+		if _, found := findStep(def, step.Name); found {
+			return http.StatusConflict, "step " + step.Name + " already exists"
+		}
+		def.Steps = append(def.Steps, step)
+		return 0, ""
+	})
+}
+
+// updateStep replaces the definition of a step.
+func (s *Server) updateStep(w http.ResponseWriter, r *http.Request, m models.Map, name string) {
+	var step loader.StepDefinition
+	if !decodeBody(w, r, &step, true) {
+		return
+	}
+	if step.Name == "" {
+		step.Name = name
+	}
+	if step.Name != name {
+		writeError(w, http.StatusBadRequest, "steps cannot be renamed")
+		return
+	}
+	s.editSteps(w, m, name, http.StatusOK, func(def *loader.MapDefinition) (int, string) {
+		i, found := findStep(def, name)
+		if !found {
+			return http.StatusNotFound, "step " + name + " not found"
+		}
+		def.Steps[i] = step
+		return 0, ""
+	})
+}
+
+// deleteStep removes a step from a map. It fails while other steps depend on
+// it.
+func (s *Server) deleteStep(w http.ResponseWriter, m models.Map, name string) {
+	s.editSteps(w, m, "", http.StatusNoContent, func(def *loader.MapDefinition) (int, string) {
+		i, found := findStep(def, name)
+		if !found {
+			return http.StatusNotFound, "step " + name + " not found"
+		}
+		def.Steps = append(def.Steps[:i], def.Steps[i+1:]...)
+		return 0, ""
+	})
+}
+
+// editSteps applies edit to the definition of a map and stores the result,
+// which is validated as a whole. edit returns a status and message to abort
+// with. On success the step named result is written with the given status.
+func (s *Server) editSteps(w http.ResponseWriter, m models.Map, result string, status int,
+	edit func(def *loader.MapDefinition) (int, string)) {
+	def, err := s.definition(m)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if code, message := edit(def); code != 0 {
+		writeError(w, code, message)
+		return
+	}
+	if _, _, ok := s.save(w, def); !ok {
+		return
+	}
+	if result == "" {
+		w.WriteHeader(status)
+		return
+	}
+
+	steps, err := s.db.GetStepsByMapID(m.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	for _, step := range steps {
+		if step.Name == result {
+			writeJSON(w, status, step)
+			return
+		}
+	}
+	writeError(w, http.StatusInternalServerError, "step "+result+" was not saved")
+}
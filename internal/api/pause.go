@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+
+	"pilot/internal/tracing"
+	"pilot/pkg/models"
+)
+
+// pauseRequest is the body of POST /api/maps/{map}/pause.
+type pauseRequest struct {
+	By     string `json:"by"` // Ignored in favour of the authenticated user
+	Reason string `json:"reason"`
+	Cancel bool   `json:"cancel"` // Also cancel the runs in progress
+}
+
+// pauseResponse reports the paused map and how many runs were cancelled.
+type pauseResponse struct {
+	Map       *models.Map `json:"map"`
+	Cancelled int         `json:"cancelled"`
+}
+
+// handlePause stops new runs of a map from being scheduled.
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request, m models.Map) {
+	var req pauseRequest
+	if !decodeBody(w, r, &req, false) {
+		return
+	}
+	if req.By = actor(r, req.By); req.By == "" {
+		writeError(w, http.StatusBadRequest, "by is required")
+		return
+	}
+
+	if err := s.db.PauseMap(m.ID, req.By, req.Reason); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	var resp pauseResponse
+	if req.Cancel {
+		cancelled, err := s.db.CancelMapRuns(m.ID)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		for _, run := range cancelled {
+			tracing.EndRun(run, m.Name)
+		}
+		resp.Cancelled = len(cancelled)
+	}
+	paused, err := s.db.GetMapByID(m.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	resp.Map = paused
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleUnpause lets the scheduler create runs of a map again.
+func (s *Server) handleUnpause(w http.ResponseWriter, m models.Map) {
+	if err := s.db.UnpauseMap(m.ID); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	unpaused, err := s.db.GetMapByID(m.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, unpaused)
+}
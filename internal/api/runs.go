@@ -0,0 +1,173 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+	"pilot/pkg/scheduler"
+)
+
+// runDetail is a map run together with its step instances, the earlier
+// attempts of the steps that were cleared, the states forced by operators,
+// the SLAs it missed, the dataset updates that started it and what its
+// pending steps wait for in other maps.
+type runDetail struct {
+	models.MapRun
+	Steps     []models.StepRun      `json:"steps"`
+	History   []models.StepRun      `json:"history"`
+	Marks     []models.StepRunMark  `json:"marks"`
+	SLAMisses []models.SLAMiss      `json:"sla_misses"`
+	Datasets  []models.DatasetEvent `json:"dataset_events"` // Dataset updates that started the run
+	WaitingOn []models.ExternalWait `json:"waiting_on"`     // Steps and runs of other maps pending steps wait for
+}
+
+// handleRuns dispatches /api/runs requests.
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r, "/api/runs")
+	if len(parts) == 0 {
+		if allowMethods(w, r, http.MethodGet) {
+			s.listRuns(w, r)
+		}
+		return
+	}
+
+	runID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid run id "+strconv.Quote(parts[0]))
+		return
+	}
+	if r.Method != http.MethodGet {
+		run, err := s.db.GetMapRun(runID)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		if !s.authorize(w, r, run.MapID, models.RoleOperator) {
+			return
+		}
+	}
+	switch {
+	case len(parts) == 1:
+		if allowMethods(w, r, http.MethodGet) {
+			s.getRun(w, runID)
+		}
+	case len(parts) == 2 && parts[1] == "clear":
+		if allowMethods(w, r, http.MethodPost) {
+			s.handleClear(w, r, runID)
+		}
+	case len(parts) == 2 && parts[1] == "mark":
+		if allowMethods(w, r, http.MethodPost) {
+			s.handleMark(w, r, runID)
+		}
+	case len(parts) == 4 && parts[1] == "steps" && parts[3] == "logs":
+		if allowMethods(w, r, http.MethodGet) {
+			s.getLogs(w, runID, parts[2])
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// listRuns returns a page of map runs, most recent first, optionally filtered
+// by map and state.
+func (s *Server) listRuns(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter := database.RunFilter{State: r.URL.Query().Get("state"), Limit: limit, Offset: offset}
+	if ref := r.URL.Query().Get("map"); ref != "" {
+		m, err := s.findMap(ref)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		filter.MapID = m.ID
+	}
+
+	runs, total, err := s.db.FindMapRuns(filter)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if runs == nil {
+		runs = []models.MapRun{}
+	}
+	writeJSON(w, http.StatusOK, page{Items: runs, Total: total, Limit: limit, Offset: offset})
+}
+
+func (s *Server) getRun(w http.ResponseWriter, runID int) {
+	run, err := s.db.GetMapRun(runID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	d := runDetail{MapRun: *run, Steps: []models.StepRun{}, History: []models.StepRun{}, Marks: []models.StepRunMark{},
+		SLAMisses: []models.SLAMiss{}, Datasets: []models.DatasetEvent{},
+		WaitingOn: []models.ExternalWait{}}
+	steps, err := s.db.GetStepRuns(run.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	history, err := s.db.GetStepRunAttempts(run.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	marks, err := s.db.GetStepRunMarks(run.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	d.Steps = append(d.Steps, steps...)
+	d.History = append(d.History, history...)
+	misses, err := s.db.GetSLAMisses(run.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	d.Marks = append(d.Marks, marks...)
+	d.SLAMisses = append(d.SLAMisses, misses...)
+	datasets, err := s.db.GetRunDatasetEvents(run.ID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	d.Datasets = append(d.Datasets, datasets...)
+	waits, err := scheduler.RunWaits(s.db, *run)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	d.WaitingOn = append(d.WaitingOn, waits...)
+	writeJSON(w, http.StatusOK, d)
+}
+
+// getLogs returns the output of every attempt of a step of a run.
+func (s *Server) getLogs(w http.ResponseWriter, runID int, name string) {
+	steps, err := s.db.GetStepRuns(runID)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	for _, step := range steps {
+		if step.StepName != name {
+			continue
+		}
+		logs, err := s.db.GetStepLogs(runID, step.StepID)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		if logs == nil {
+			logs = []models.StepLog{}
+		}
+		writeJSON(w, http.StatusOK, logs)
+		return
+	}
+	writeError(w, http.StatusNotFound, "step "+name+" not found in run "+strconv.Itoa(runID))
+}
@@ -0,0 +1,178 @@
+// Package api serves pilot over HTTP with JSON requests and responses.
+//
+// Requests authenticate with an API token as "Authorization: Bearer <token>"
+// or with the basic auth credentials of a user. Any user can read, while
+// changes need the operator or admin role on the map involved.
+//
+// Errors are reported with the matching status code and a body of the form
+// {"error": "..."}. Listings are paginated with the limit and offset query
+// parameters and return {"items": [...], "total": N, "limit": L, "offset": O}.
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+// Page sizes of listings.
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// Server routes the HTTP API.
+type Server struct {
+	db     *database.DB
+	mux    *http.ServeMux
+	noAuth bool
+}
+
+// NewServer creates the API server for db. Every request except the health
+// check must be authenticated, see authenticate.
+func NewServer(db *database.DB) *Server {
+	s := &Server{db: db, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/health", s.handleHealth)
+	s.mux.HandleFunc("/api/maps", s.handleMaps)
+	s.mux.HandleFunc("/api/maps/", s.handleMaps)
+	s.mux.HandleFunc("/api/runs", s.handleRuns)
+	s.mux.HandleFunc("/api/runs/", s.handleRuns)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not found")
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/health" {
+		var ok bool
+		if r, ok = s.authenticate(w, r); !ok {
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// errorBody is the body of every error response.
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// page is the body of a paginated listing.
+type page struct {
+	Items  any `json:"items"`
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorBody{Error: message})
+}
+
+// writeDBError maps missing records to 404 and everything else to 500.
+func writeDBError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+// pathParts splits the path of r after prefix into its segments.
+func pathParts(r *http.Request, prefix string) []string {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if rest == "" {
+		return nil
+	}
+	return strings.Split(rest, "/")
+}
+
+// allowMethods reports whether r uses one of methods, and answers 405
+// otherwise.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// decodeBody parses a JSON request body into v, rejecting unknown fields.
+// An empty body leaves v unchanged unless required is set.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any, required bool) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil || (!required && errors.Is(err, io.EOF)) {
+		return true
+	}
+	writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+	return false
+}
+
+// pagination reads the limit and offset query parameters.
+func pagination(r *http.Request) (limit int, offset int, err error) {
+	limit, offset = DefaultPageSize, 0
+	query := r.URL.Query()
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > MaxPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", MaxPageSize)
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a positive number")
+		}
+	}
+	return limit, offset, nil
+}
+
+// paginate returns the page of items selected by limit and offset.
+func paginate[T any](items []T, limit int, offset int) page {
+	p := page{Items: []T{}, Total: len(items), Limit: limit, Offset: offset}
+	if offset < len(items) {
+		end := min(offset+limit, len(items))
+		p.Items = items[offset:end]
+	}
+	return p
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if _, err := s.db.GetMaps(); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "database unavailable: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// findMap looks a map up by ID or by name.
+func (s *Server) findMap(ref string) (*models.Map, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		return s.db.GetMapByID(id)
+	}
+	return s.db.GetMapByName(ref)
+}
@@ -0,0 +1,409 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+func newTestServer(t *testing.T) (*Server, *database.DB) {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := models.NewMap("sales", "0 6 * * *", time.Time{}, time.Time{}, []models.Step{
+		{Name: "extract", Command: "extract.py"},
+		{Name: "load", Command: "load.py"},
+	})
+	if _, _, err := db.SyncMap(*m, map[string][]string{"load": {"extract"}}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(db)
+	s.DisableAuth()
+	return s, db
+}
+
+func do(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestTrigger(t *testing.T) {
+	s, db := newTestServer(t)
+
+	rec := do(s, http.MethodPost, "/api/maps/sales/trigger", `{"conf": {"region": "eu"}, "logical_date": "2024-03-01"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	var run models.MapRun
+	if err := json.NewDecoder(rec.Body).Decode(&run); err != nil {
+		t.Fatal(err)
+	}
+	if run.RunType != "manual" || run.Conf["region"] != "eu" || run.LogicalDate.Format("2006-01-02") != "2024-03-01" {
+		t.Errorf("run = %+v", run)
+	}
+
+	stored, err := db.GetMapRun(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RunType != "manual" || stored.Conf["region"] != "eu" {
+		t.Errorf("stored run = %+v", stored)
+	}
+}
+
+func TestTriggerErrors(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/api/maps/missing/trigger", ``, http.StatusNotFound},
+		{http.MethodPost, "/api/maps/sales/trigger", `{"conf": [}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/maps/sales/trigger", `{"logical_date": "yesterday"}`, http.StatusBadRequest},
+		{http.MethodGet, "/api/maps/sales/trigger", ``, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		rec := do(s, tt.method, tt.path, tt.body)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s %s: status = %d, want %d", tt.method, tt.path, tt.body, rec.Code, tt.want)
+		}
+		var body errorBody
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error == "" {
+			t.Errorf("%s %s: missing error body", tt.method, tt.path)
+		}
+	}
+}
+
+func TestPause(t *testing.T) {
+	s, db := newTestServer(t)
+
+	rec := do(s, http.MethodPost, "/api/maps/sales/pause", `{"by": "alice", "reason": "backfill", "cancel": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	m, err := db.GetMapByName("sales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.IsActive || m.PausedBy != "alice" || m.PauseReason != "backfill" {
+		t.Errorf("paused map = %+v", m)
+	}
+
+	if rec := do(s, http.MethodPost, "/api/maps/sales/pause", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("pause without by: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	if rec := do(s, http.MethodPost, "/api/maps/sales/unpause", ``); rec.Code != http.StatusOK {
+		t.Fatalf("unpause status = %d: %s", rec.Code, rec.Body)
+	}
+	if m, _ = db.GetMapByName("sales"); !m.IsActive {
+		t.Error("map still paused")
+	}
+}
+
+func TestClear(t *testing.T) {
+	s, _ := newTestServer(t)
+	rec := do(s, http.MethodPost, "/api/maps/sales/trigger", ``)
+	var run models.MapRun
+	if err := json.NewDecoder(rec.Body).Decode(&run); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/runs/" + strconv.Itoa(run.ID) + "/clear"
+
+	rec = do(s, http.MethodPost, path, `{"steps": ["extract"], "downstream": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var cleared []models.StepRun
+	if err := json.NewDecoder(rec.Body).Decode(&cleared); err != nil {
+		t.Fatal(err)
+	}
+	if len(cleared) != 2 {
+		t.Errorf("cleared %d steps, want 2", len(cleared))
+	}
+
+	if rec := do(s, http.MethodPost, path, `{"steps": ["missing"]}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown step: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := do(s, http.MethodPost, "/api/runs/x/clear", `{"steps": ["extract"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid run id: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestMark(t *testing.T) {
+	s, _ := newTestServer(t)
+	rec := do(s, http.MethodPost, "/api/maps/sales/trigger", ``)
+	var run models.MapRun
+	if err := json.NewDecoder(rec.Body).Decode(&run); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/runs/" + strconv.Itoa(run.ID) + "/mark"
+
+	rec = do(s, http.MethodPost, path, `{"step": "extract", "state": "success", "by": "alice", "note": "loaded by hand"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var mark models.StepRunMark
+	if err := json.NewDecoder(rec.Body).Decode(&mark); err != nil {
+		t.Fatal(err)
+	}
+	if mark.State != "completed" || mark.MarkedBy != "alice" {
+		t.Errorf("mark = %+v", mark)
+	}
+
+	if rec := do(s, http.MethodPost, path, `{"step": "load", "state": "done", "by": "alice", "note": "x"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid state: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do(s, http.MethodPost, path, `{"step": "load", "state": "success"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing note: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestMapCRUD(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	body := `{"name": "billing", "schedule": "0 * * * *", "steps": [
+		{"name": "fetch", "command": "fetch.py"},
+		{"name": "report", "command": "report.py", "depends_on": ["fetch"]}]}`
+	rec := do(s, http.MethodPost, "/api/maps", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body)
+	}
+	var created mapDetail
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Name != "billing" || len(created.Steps) != 2 || created.NextRun == nil {
+		t.Errorf("created = %+v", created)
+	}
+
+	if rec := do(s, http.MethodPost, "/api/maps", body); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate create status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec := do(s, http.MethodPost, "/api/maps", `{"name": "bad", "schedule": "0 * * * *", "steps": [{"name": "a", "command": "a.py", "depends_on": ["a"]}]}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid create status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	if rec := do(s, http.MethodPost, "/api/maps", `{"name": "x", "owner": "me"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown field status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = do(s, http.MethodPut, "/api/maps/billing", `{"schedule": "30 * * * *", "steps": [{"name": "fetch", "command": "fetch.py"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status = %d: %s", rec.Code, rec.Body)
+	}
+	var updated mapDetail
+	if err := json.NewDecoder(rec.Body).Decode(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.ScheduleInterval != "30 * * * *" || len(updated.Steps) != 1 || len(updated.Changes) == 0 {
+		t.Errorf("updated = %+v", updated)
+	}
+
+	rec = do(s, http.MethodGet, "/api/maps?limit=1&offset=1", ``)
+	var p struct {
+		Items []models.Map
+		Total int
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Total != 2 || len(p.Items) != 1 || p.Items[0].Name != "billing" {
+		t.Errorf("page = %+v", p)
+	}
+
+	if rec := do(s, http.MethodDelete, "/api/maps/billing", ``); rec.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := do(s, http.MethodGet, "/api/maps/billing", ``); rec.Code != http.StatusNotFound {
+		t.Errorf("get deleted status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestStepCRUD(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := do(s, http.MethodPost, "/api/maps/sales/steps", `{"name": "notify", "command": "notify.py", "depends_on": ["load"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body)
+	}
+	var step models.Step
+	if err := json.NewDecoder(rec.Body).Decode(&step); err != nil {
+		t.Fatal(err)
+	}
+	if step.Name != "notify" || len(step.Dependencies) != 1 {
+		t.Errorf("created step = %+v", step)
+	}
+
+	rec = do(s, http.MethodPut, "/api/maps/sales/steps/notify", `{"command": "notify.py --all", "depends_on": ["load"], "retries": 2}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status = %d: %s", rec.Code, rec.Body)
+	}
+	rec = do(s, http.MethodGet, "/api/maps/sales/steps/notify", ``)
+	if err := json.NewDecoder(rec.Body).Decode(&step); err != nil {
+		t.Fatal(err)
+	}
+	if step.Command != "notify.py --all" || step.Retries != 2 {
+		t.Errorf("updated step = %+v", step)
+	}
+
+	// load cannot go while notify depends on it
+	if rec := do(s, http.MethodDelete, "/api/maps/sales/steps/load", ``); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("delete depended on step status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	if rec := do(s, http.MethodDelete, "/api/maps/sales/steps/notify", ``); rec.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	rec = do(s, http.MethodGet, "/api/maps/sales/steps", ``)
+	var steps []models.Step
+	if err := json.NewDecoder(rec.Body).Decode(&steps); err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 {
+		t.Errorf("%d steps left, want 2", len(steps))
+	}
+}
+
+func TestRuns(t *testing.T) {
+	s, db := newTestServer(t)
+	var ids []int
+	for i := 0; i < 3; i++ {
+		rec := do(s, http.MethodPost, "/api/maps/sales/trigger", ``)
+		var run models.MapRun
+		if err := json.NewDecoder(rec.Body).Decode(&run); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, run.ID)
+	}
+
+	rec := do(s, http.MethodGet, "/api/runs?map=sales&limit=2", ``)
+	var p struct {
+		Items []models.MapRun
+		Total int
+		Limit int
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Total != 3 || p.Limit != 2 || len(p.Items) != 2 || p.Items[0].ID != ids[2] {
+		t.Errorf("page = %+v", p)
+	}
+	if rec := do(s, http.MethodGet, "/api/runs?limit=0", ``); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid limit status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	steps, _ := db.GetStepRuns(ids[0])
+	if err := db.SaveStepLog(ids[0], steps[0].StepID, 1, "extracted 10 rows"); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/runs/" + strconv.Itoa(ids[0])
+	rec = do(s, http.MethodGet, path, ``)
+	var detail runDetail
+	if err := json.NewDecoder(rec.Body).Decode(&detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.ID != ids[0] || len(detail.Steps) != 2 {
+		t.Errorf("detail = %+v", detail)
+	}
+
+	rec = do(s, http.MethodGet, path+"/steps/extract/logs", ``)
+	var logs []models.StepLog
+	if err := json.NewDecoder(rec.Body).Decode(&logs); err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Output != "extracted 10 rows" {
+		t.Errorf("logs = %+v", logs)
+	}
+	if rec := do(s, http.MethodGet, path+"/steps/missing/logs", ``); rec.Code != http.StatusNotFound {
+		t.Errorf("missing step logs status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/health", http.StatusOK},
+		{http.MethodPost, "/health", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/unknown", http.StatusNotFound},
+		{http.MethodPatch, "/api/maps/sales", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/maps/sales/unknown", http.StatusNotFound},
+		{http.MethodGet, "/api/runs/42", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := do(s, tt.method, tt.path, ``)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: content type = %q", tt.method, tt.path, ct)
+		}
+	}
+}
+
+func TestGridAndGraph(t *testing.T) {
+	s, db := newTestServer(t)
+	rec := do(s, http.MethodPost, "/api/maps/sales/trigger", ``)
+	var run models.MapRun
+	if err := json.NewDecoder(rec.Body).Decode(&run); err != nil {
+		t.Fatal(err)
+	}
+	steps, _ := db.GetStepRuns(run.ID)
+	if _, err := db.TransitionStepRun(run.ID, steps[0].StepID, "pending", "failed"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = do(s, http.MethodGet, "/api/maps/sales/grid", ``)
+	var g struct {
+		Steps []models.Step
+		Runs  []struct {
+			ID     int
+			States map[string]string
+		}
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&g); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Steps) != 2 || g.Steps[0].Name != "extract" || len(g.Runs) != 1 {
+		t.Fatalf("grid = %+v", g)
+	}
+	if state := g.Runs[0].States[strconv.Itoa(steps[0].StepID)]; state != "failed" {
+		t.Errorf("extract state in grid = %q, want failed", state)
+	}
+
+	rec = do(s, http.MethodGet, "/api/maps/sales/graph?run="+strconv.Itoa(run.ID), ``)
+	var graph struct {
+		Steps []struct{ Name, State string }
+		Edges [][2]int
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&graph); err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Steps) != 2 || graph.Steps[0].State != "failed" || len(graph.Edges) != 1 {
+		t.Errorf("graph = %+v", graph)
+	}
+
+	other := &models.MapRun{MapID: run.MapID + 1, RunType: "manual", LogicalDate: time.Now()}
+	if _, err := db.CreateMapRun(other, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, runID := range []int{other.ID, other.ID + 1} {
+		if rec := do(s, http.MethodGet, "/api/maps/sales/graph?run="+strconv.Itoa(runID), ``); rec.Code != http.StatusNotFound {
+			t.Errorf("graph of run %d = %d, want %d", runID, rec.Code, http.StatusNotFound)
+		}
+	}
+}
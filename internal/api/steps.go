@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"pilot/pkg/models"
+	"pilot/pkg/scheduler"
+)
+
+// clearRequest is the body of POST /api/runs/{id}/clear.
+type clearRequest struct {
+	Steps      []string `json:"steps"`
+	Upstream   bool     `json:"upstream"`
+	Downstream bool     `json:"downstream"`
+}
+
+// handleClear resets steps of a run so the scheduler executes them again.
+func (s *Server) handleClear(w http.ResponseWriter, r *http.Request, runID int) {
+	var req clearRequest
+	if !decodeBody(w, r, &req, true) {
+		return
+	}
+	if len(req.Steps) == 0 {
+		writeError(w, http.StatusBadRequest, "steps is required")
+		return
+	}
+
+	cleared, err := scheduler.ClearSteps(s.db, runID, scheduler.ClearOptions{
+		Steps: req.Steps, Upstream: req.Upstream, Downstream: req.Downstream,
+	})
+	if err != nil {
+		writeStepError(w, err)
+		return
+	}
+	if cleared == nil {
+		cleared = []models.StepRun{}
+	}
+	writeJSON(w, http.StatusOK, cleared)
+}
+
+// writeStepError reports the errors of operations on the steps of a run.
+func writeStepError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrStepNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, scheduler.ErrStepActive):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, scheduler.ErrInvalidMark):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeDBError(w, err)
+	}
+}
+
+// markRequest is the body of POST /api/runs/{id}/mark.
+type markRequest struct {
+	Step  string `json:"step"`
+	State string `json:"state"` // success, failed or skipped
+	By    string `json:"by"`    // Ignored in favour of the authenticated user
+	Note  string `json:"note"`
+}
+
+// handleMark forces the state of a step instance.
+func (s *Server) handleMark(w http.ResponseWriter, r *http.Request, runID int) {
+	var req markRequest
+	if !decodeBody(w, r, &req, true) {
+		return
+	}
+	req.By = actor(r, req.By)
+	if req.Step == "" || req.By == "" || req.Note == "" {
+		writeError(w, http.StatusBadRequest, "step, by and note are required")
+		return
+	}
+
+	mark, err := scheduler.MarkStep(s.db, runID, scheduler.MarkOptions{Step: req.Step, State: req.State, By: req.By, Note: req.Note})
+	if err != nil {
+		writeStepError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, mark)
+}
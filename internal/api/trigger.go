@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"pilot/pkg/loader"
+	"pilot/pkg/models"
+	"pilot/pkg/scheduler"
+)
+
+// triggerRequest is the optional body of POST /api/maps/{map}/trigger.
+type triggerRequest struct {
+	Conf        map[string]any `json:"conf"`
+	LogicalDate string         `json:"logical_date"`
+}
+
+// handleTrigger starts a manual run of a map.
+func (s *Server) handleTrigger(w http.ResponseWriter, r *http.Request, m models.Map) {
+	var req triggerRequest
+	if !decodeBody(w, r, &req, false) {
+		return
+	}
+
+	logicalDate := time.Now().Truncate(time.Second)
+	if req.LogicalDate != "" {
+		var err error
+		if logicalDate, err = loader.ParseDate(req.LogicalDate); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	run, err := scheduler.CreateRun(s.db, m, "manual", logicalDate, req.Conf)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, run)
+}
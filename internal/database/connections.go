@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"pilot/pkg/models"
+)
+
+func createConnectionsTable(db *sql.DB) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS connections (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name VARCHAR(255) UNIQUE NOT NULL,
+        uri TEXT,
+        login VARCHAR(255),
+        password TEXT,
+        extras TEXT
+    );`
+	_, err := db.Exec(query)
+	return err
+}
+
+// SaveConnection encrypts and stores a connection, replacing any existing
+// connection with the same name.
+func (db *DB) SaveConnection(c models.Connection) error {
+	uri, err := db.encrypt(c.URI)
+	if err != nil {
+		return err
+	}
+	login, err := db.encrypt(c.Login)
+	if err != nil {
+		return err
+	}
+	password, err := db.encrypt(c.Password)
+	if err != nil {
+		return err
+	}
+	extrasJSON, err := json.Marshal(c.Extras)
+	if err != nil {
+		return err
+	}
+	extras, err := db.encrypt(string(extrasJSON))
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO connections (name, uri, login, password, extras) VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT(name) DO UPDATE SET uri = excluded.uri, login = excluded.login, password = excluded.password, extras = excluded.extras`
+	_, err = db.conn.Exec(query, c.Name, uri, login, password, extras)
+	return err
+}
+
+// GetConnection retrieves and decrypts a connection by its name
+func (db *DB) GetConnection(name string) (*models.Connection, error) {
+	var c models.Connection
+	var uri, login, password, extras string
+	query := `SELECT id, name, uri, login, password, extras FROM connections WHERE name = ?`
+	err := db.conn.QueryRow(query, name).Scan(&c.ID, &c.Name, &uri, &login, &password, &extras)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("connection %q not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.URI, err = db.decrypt(uri); err != nil {
+		return nil, err
+	}
+	if c.Login, err = db.decrypt(login); err != nil {
+		return nil, err
+	}
+	if c.Password, err = db.decrypt(password); err != nil {
+		return nil, err
+	}
+	extrasJSON, err := db.decrypt(extras)
+	if err != nil {
+		return nil, err
+	}
+	c.Extras = map[string]string{}
+	if extrasJSON != "" {
+		if err := json.Unmarshal([]byte(extrasJSON), &c.Extras); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// ListConnections returns the names and logins of all connections, decrypting
+// only their logins
+func (db *DB) ListConnections() ([]models.Connection, error) {
+	var connections []models.Connection
+	query := `SELECT id, name, login FROM connections ORDER BY name`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.Connection
+		var login string
+		if err := rows.Scan(&c.ID, &c.Name, &login); err != nil {
+			return nil, err
+		}
+		if c.Login, err = db.decrypt(login); err != nil {
+			return nil, err
+		}
+		connections = append(connections, c)
+	}
+
+	return connections, rows.Err()
+}
+
+// DeleteConnection removes a connection from the database
+func (db *DB) DeleteConnection(name string) error {
+	query := `DELETE FROM connections WHERE name = ?`
+	result, err := db.conn.Exec(query, name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("connection %q not found", name)
+	}
+	return nil
+}
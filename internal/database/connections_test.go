@@ -0,0 +1,85 @@
+package database
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pilot/pkg/models"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	db.SetSecretKey("test-key")
+	return db
+}
+
+func TestConnectionRoundTrip(t *testing.T) {
+	db := newTestDB(t)
+
+	c := models.NewConnection("warehouse", "postgres://etl:hunter2@db/warehouse", "etl", "hunter2")
+	c.Extras["schema"] = "staging"
+	if err := db.SaveConnection(*c); err != nil {
+		t.Fatalf("SaveConnection failed: %v", err)
+	}
+
+	got, err := db.GetConnection("warehouse")
+	if err != nil {
+		t.Fatalf("GetConnection failed: %v", err)
+	}
+	if got.URI != c.URI || got.Login != "etl" || got.Password != "hunter2" || got.Extras["schema"] != "staging" {
+		t.Errorf("GetConnection = %+v, want %+v", got, c)
+	}
+
+	var password, uri, login string
+	query := `SELECT uri, login, password FROM connections WHERE name = ?`
+	if err := db.conn.QueryRow(query, "warehouse").Scan(&uri, &login, &password); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(uri, "hunter2") || login == "etl" || password == "hunter2" {
+		t.Errorf("secrets stored in clear text: uri=%q login=%q password=%q", uri, login, password)
+	}
+
+	other := &DB{conn: db.conn}
+	other.SetSecretKey("wrong-key")
+	if _, err := other.GetConnection("warehouse"); err == nil {
+		t.Error("GetConnection with the wrong key succeeded")
+	}
+}
+
+func TestSaveConnectionReplaces(t *testing.T) {
+	db := newTestDB(t)
+
+	if err := db.SaveConnection(*models.NewConnection("api", "https://api", "bot", "old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveConnection(*models.NewConnection("api", "https://api", "bot", "new")); err != nil {
+		t.Fatal(err)
+	}
+
+	connections, err := db.ListConnections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connections) != 1 || connections[0].Login != "bot" {
+		t.Fatalf("ListConnections = %+v, want the bot login of api", connections)
+	}
+	got, err := db.GetConnection("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Password != "new" {
+		t.Errorf("Password = %q, want %q", got.Password, "new")
+	}
+
+	if err := db.DeleteConnection("api"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetConnection("api"); err == nil {
+		t.Error("GetConnection succeeded after DeleteConnection")
+	}
+}
@@ -2,7 +2,11 @@ package database
 
 import (
 	"database/sql"
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
 
 	"pilot/pkg/models"
 
@@ -10,18 +14,27 @@ import (
 )
 
 type DB struct {
-	conn *sql.DB
+	conn      timedDB
+	secretKey []byte // Loaded once by NewDB, nil when none is configured
 }
 
 func NewDB(dataSourceName string) (*DB, error) {
 	db, err := sql.Open("sqlite3", dataSourceName)
-	createTables(db)
-
 	if err != nil {
 		return nil, err
 	}
 
-	return &DB{conn: db}, nil
+	if err := createTables(db); err != nil {
+		return nil, err
+	}
+
+	// The key is read once here as the DB is shared by goroutines. A missing
+	// key only fails the operations that need it
+	key, err := LoadSecretKey()
+	if err != nil && !errors.Is(err, ErrNoSecretKey) {
+		return nil, err
+	}
+	return &DB{conn: timedDB{db}, secretKey: key}, nil
 }
 
 func createMapsTable(db *sql.DB) error {
@@ -64,38 +77,305 @@ func createTables(db *sql.DB) error {
 		createErr = err
 	}
 
+	if err := createConnectionsTable(db); err != nil {
+		createErr = err
+	}
+
+	if err := createMapRunsTable(db); err != nil {
+		createErr = err
+	}
+
+	if err := createStepRunsTable(db); err != nil {
+		createErr = err
+	}
+
+	if err := createStepRunAttemptsTable(db); err != nil {
+		createErr = err
+	}
+
+	if err := createStepRunMarksTable(db); err != nil {
+		createErr = err
+	}
+
+	if err := createStepLogsTable(db); err != nil {
+		createErr = err
+	}
+
+	if err := createUsersTables(db); err != nil {
+		createErr = err
+	}
+
+	if err := createSLAMissesTable(db); err != nil {
+		createErr = err
+	}
+
+	if err := createTriggerFilesTable(db); err != nil {
+		createErr = err
+	}
+
+	if err := createDatasetTables(db); err != nil {
+		createErr = err
+	}
+
+	runColumns := []struct{ table, name, definition string }{
+		{"map_runs", "conf", "TEXT"},
+		{"map_runs", "trace_parent", "TEXT"},
+		{"step_runs", "queued_at", "TIMESTAMP"},
+		{"step_runs", "sensor_started_at", "TIMESTAMP"},
+		{"step_runs", "reschedule_at", "TIMESTAMP"},
+	}
+	for _, column := range runColumns {
+		if err := addColumn(db, column.table, column.name, column.definition); err != nil {
+			createErr = err
+		}
+	}
+
+	mapColumns := []struct{ name, definition string }{
+		{"paused_by", "TEXT"},
+		{"pause_reason", "TEXT"},
+		{"paused_at", "TIMESTAMP"},
+		{"notifiers", "TEXT"},
+		{"sla", "INTEGER DEFAULT 0"},
+		{"sla_from", "TEXT"},
+		{"hooks", "TEXT"},
+		{"trigger", "TEXT"},
+	}
+	for _, column := range mapColumns {
+		if err := addColumn(db, "maps", column.name, column.definition); err != nil {
+			createErr = err
+		}
+	}
+
+	stepColumns := []struct{ name, definition string }{
+		{"connections", "TEXT"},
+		{"command", "TEXT"},
+		{"retries", "INTEGER DEFAULT 0"},
+		{"retry_delay", "INTEGER DEFAULT 0"},
+		{"sla", "INTEGER DEFAULT 0"},
+		{"hooks", "TEXT"},
+		{"sensor", "TEXT"},
+		{"produces", "TEXT"},
+		{"wait_for", "TEXT"},
+	}
+	for _, column := range stepColumns {
+		if err := addColumn(db, "steps", column.name, column.definition); err != nil {
+			createErr = err
+		}
+	}
+
 	return createErr
 }
 
-func (db *DB) AddMap(m models.Map) error {
-	query := `INSERT INTO maps (name, schedule_interval, is_active, start_date) VALUES (?, ?, ?, ?)`
-	_, err := db.conn.Exec(query, m.Name, m.ScheduleInterval, m.IsActive, m.StartDate)
+// addColumn adds a column to a table created by an older version of pilot.
+// It is a no-op when the column already exists.
+func addColumn(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			name, ctype  string
+			notNull, pk  int
+			defaultValue sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
+// encodeList serialises a list column as JSON.
+func encodeList[T any](values []T) string {
+	if len(values) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+// decodeList parses a list column written by encodeList.
+func decodeList[T any](column sql.NullString) ([]T, error) {
+	var values []T
+	if !column.Valid || column.String == "" {
+		return values, nil
+	}
+	err := json.Unmarshal([]byte(column.String), &values)
+	return values, err
+}
+
+// encodeObject stores an optional object column as JSON, nil as an empty
+// string.
+func encodeObject[T any](value *T) string {
+	if value == nil {
+		return ""
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// decodeObject parses an object column written by encodeObject.
+func decodeObject[T any](column sql.NullString) (*T, error) {
+	if !column.Valid || column.String == "" {
+		return nil, nil
+	}
+	var value T
+	if err := json.Unmarshal([]byte(column.String), &value); err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// AddMap inserts a map and returns its assigned ID
+func (db *DB) AddMap(m models.Map) (int, error) {
+	query := `INSERT INTO maps (id, name, schedule_interval, is_active, start_date, notifiers, sla, sla_from, hooks, "trigger")
+        VALUES ((SELECT COALESCE(MAX(id), 0) + 1 FROM maps), ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := db.conn.Exec(query, m.Name, m.ScheduleInterval, m.IsActive, m.StartDate, encodeList(m.Notifiers),
+		int64(m.SLA/time.Second), m.SLAFrom, encodeList(m.Hooks), encodeObject(m.Trigger))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	// maps.id is not a rowid alias, so look the id up from the rowid
+	var mapID int
+	err = db.conn.QueryRow(`SELECT id FROM maps WHERE rowid = ?`, id).Scan(&mapID)
+	return mapID, err
+}
+
+// mapColumns lists the columns read by scanMap, in order.
+const mapColumns = `id, name, schedule_interval, is_active, start_date, paused_by, pause_reason, paused_at, notifiers, sla, sla_from, hooks,
+    "trigger"`
+
+func scanMap(row rowScanner) (models.Map, error) {
+	var m models.Map
+	var pausedBy, pauseReason, notifiers, slaFrom, hooks, trigger sql.NullString
+	var pausedAt sql.NullTime
+	var sla sql.NullInt64
+	err := row.Scan(&m.ID, &m.Name, &m.ScheduleInterval, &m.IsActive, &m.StartDate, &pausedBy, &pauseReason, &pausedAt, &notifiers,
+		&sla, &slaFrom, &hooks, &trigger)
+	if err != nil {
+		return m, err
+	}
+	m.PausedBy = pausedBy.String
+	m.PauseReason = pauseReason.String
+	m.SLA = time.Duration(sla.Int64) * time.Second
+	m.SLAFrom = slaFrom.String
+	if pausedAt.Valid {
+		m.PausedAt = &pausedAt.Time
+	}
+	if m.Notifiers, err = decodeList[models.Notifier](notifiers); err != nil {
+		return m, err
+	}
+	if m.Hooks, err = decodeList[models.Hook](hooks); err != nil {
+		return m, err
+	}
+	m.Trigger, err = decodeObject[models.Trigger](trigger)
+	return m, err
+}
+
+// stepColumns lists the columns read by scanStep, in order.
+const stepColumns = `id, name, map_id, state, command, start_date, end_date, dependencies, connections, retries, retry_delay, sla, hooks, sensor,
+    produces, wait_for`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanStep(row rowScanner) (models.Step, error) {
+	var step models.Step
+	var command, dependencies, connections, hooks, sensor, produces, waitFor sql.NullString
+	var retries, retryDelay, sla sql.NullInt64
+	err := row.Scan(&step.ID, &step.Name, &step.MapID, &step.State, &command, &step.StartDate, &step.EndDate,
+		&dependencies, &connections, &retries, &retryDelay, &sla, &hooks, &sensor, &produces, &waitFor)
+	if err != nil {
+		return step, err
+	}
+
+	step.Command = command.String
+	step.Retries = int(retries.Int64)
+	step.RetryDelay = time.Duration(retryDelay.Int64) * time.Second
+	step.SLA = time.Duration(sla.Int64) * time.Second
+	if step.Dependencies, err = decodeList[int](dependencies); err != nil {
+		return step, err
+	}
+	if step.Connections, err = decodeList[string](connections); err != nil {
+		return step, err
+	}
+	if step.Hooks, err = decodeList[models.Hook](hooks); err != nil {
+		return step, err
+	}
+	if step.Sensor, err = decodeObject[models.Sensor](sensor); err != nil {
+		return step, err
+	}
+	if step.Produces, err = decodeList[string](produces); err != nil {
+		return step, err
+	}
+	if step.WaitFor, err = decodeList[models.ExternalDependency](waitFor); err != nil {
+		return step, err
+	}
+	return step, nil
+}
+
+// AddStep inserts a step, rejecting it when its dependencies break the graph
+// of its map
 func (db *DB) AddStep(task *models.Step) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	// INSERT query without RETURNING clause
-	insertQuery := `INSERT INTO steps (name, map_id, state, start_date, end_date) VALUES (?, ?, ?, ?, ?)`
-	result, err := db.conn.Exec(insertQuery, task.Name, task.MapID, task.State, task.StartDate, task.EndDate)
+	insertQuery := `INSERT INTO steps (name, map_id, state, command, start_date, end_date, dependencies, connections, retries, retry_delay, sla,
+        hooks, sensor, produces, wait_for) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(insertQuery, task.Name, task.MapID, task.State, task.Command, task.StartDate, task.EndDate,
+		encodeList(task.Dependencies), encodeList(task.Connections), task.Retries, int64(task.RetryDelay/time.Second),
+		int64(task.SLA/time.Second), encodeList(task.Hooks), encodeObject(task.Sensor), encodeList(task.Produces),
+		encodeList(task.WaitFor))
 	if err != nil {
-		log.Printf("Error adding step to database: %v", err)
+		slog.Error("Error adding step to database", "map_id", task.MapID, "step", task.Name, "error", err)
 		return 0, err
 	}
 
 	// Retrieve the last insert id
 	id, err := result.LastInsertId()
 	if err != nil {
-		log.Printf("Error getting last insert ID: %v", err)
+		slog.Error("Error getting last insert ID", "error", err)
 		return 0, err
 	}
 
+	added := *task
+	added.ID = int(id)
+	if err := validateStepWrite(tx, added); err != nil {
+		return 0, fmt.Errorf("step %s: %w", task.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
 	return int(id), nil
 }
 
 // GetActivemaps retrieves all active maps from the database
 func (db *DB) GetActiveMaps() ([]models.Map, error) {
 	var maps []models.Map
-	query := `SELECT id, name, schedule_interval, is_active, start_date FROM maps WHERE is_active = true`
+	query := `SELECT ` + mapColumns + ` FROM maps WHERE is_active = true`
 	rows, err := db.conn.Query(query)
 	if err != nil {
 		return nil, err
@@ -103,8 +383,8 @@ func (db *DB) GetActiveMaps() ([]models.Map, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var m models.Map
-		if err := rows.Scan(&m.ID, &m.Name, &m.ScheduleInterval, &m.IsActive, &m.StartDate); err != nil {
+		m, err := scanMap(rows)
+		if err != nil {
 			return nil, err
 		}
 		maps = append(maps, m)
@@ -113,18 +393,62 @@ func (db *DB) GetActiveMaps() ([]models.Map, error) {
 	return maps, nil
 }
 
+// GetMaps retrieves every map, active or not
+func (db *DB) GetMaps() ([]models.Map, error) {
+	var maps []models.Map
+	query := `SELECT ` + mapColumns + ` FROM maps WHERE id IS NOT NULL ORDER BY id`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanMap(rows)
+		if err != nil {
+			return nil, err
+		}
+		maps = append(maps, m)
+	}
+
+	return maps, rows.Err()
+}
+
+// RowQuerier runs a query returning at most one row, such as *sql.DB or *sql.Tx
+type RowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 // Getmap retrieves a map by its ID
-func GetMap(db *sql.DB, id int) (*models.Map, error) {
-	m := &models.Map{}
-	query := `SELECT id, name, schedule_interval, is_active, start_date FROM maps WHERE id = ?`
-	err := db.QueryRow(query, id).Scan(&m.ID, &m.Name, &m.ScheduleInterval, &m.IsActive, &m.StartDate)
-	return m, err
+func GetMap(db RowQuerier, id int) (*models.Map, error) {
+	query := `SELECT ` + mapColumns + ` FROM maps WHERE id = ?`
+	m, err := scanMap(db.QueryRow(query, id))
+	return &m, err
+}
+
+// GetMapByID retrieves a map by its ID
+func (db *DB) GetMapByID(id int) (*models.Map, error) {
+	m, err := GetMap(db.conn, id)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetMapByName retrieves a map by its name
+func (db *DB) GetMapByName(name string) (*models.Map, error) {
+	query := `SELECT ` + mapColumns + ` FROM maps WHERE name = ?`
+	m, err := scanMap(db.conn.QueryRow(query, name))
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
 }
 
 // GetStepsBymapID retrieves all steps for a given map
 func (db *DB) GetStepsByMapID(id int) ([]models.Step, error) {
 	var steps []models.Step
-	query := `SELECT id, name, map_id, state, start_date, end_date FROM steps WHERE map_id = ?`
+	query := `SELECT ` + stepColumns + ` FROM steps WHERE map_id = ?`
 	rows, err := db.conn.Query(query, id)
 	if err != nil {
 		return nil, err
@@ -132,8 +456,8 @@ func (db *DB) GetStepsByMapID(id int) ([]models.Step, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var task models.Step
-		if err := rows.Scan(&task.ID, &task.Name, &task.MapID, &task.State, &task.StartDate, &task.EndDate); err != nil {
+		task, err := scanStep(rows)
+		if err != nil {
 			return nil, err
 		}
 		steps = append(steps, task)
@@ -144,11 +468,10 @@ func (db *DB) GetStepsByMapID(id int) ([]models.Step, error) {
 
 // GetStepByID retrieves a specific step by its ID
 func (db *DB) GetStepByID(id int) (*models.Step, error) {
-	var step models.Step
-	query := `SELECT id, name, map_id, state, start_date, end_date FROM steps WHERE id = ?`
+	query := `SELECT ` + stepColumns + ` FROM steps WHERE id = ?`
 	row := db.conn.QueryRow(query, id)
 
-	err := row.Scan(&step.ID, &step.Name, &step.MapID, &step.State, &step.StartDate, &step.EndDate)
+	step, err := scanStep(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// No rows were returned, handle this case as needed
@@ -160,40 +483,172 @@ func (db *DB) GetStepByID(id int) (*models.Step, error) {
 	return &step, nil
 }
 
-// Updatemap modifies an existing map
+// StepDurations returns the average duration of the completed executions of
+// each step of a map, falling back to the last execution recorded on the step
+// itself for steps that never ran as part of a map run
+func (db *DB) StepDurations(mapID int) (map[int]time.Duration, error) {
+	steps, err := db.GetStepsByMapID(mapID)
+	if err != nil {
+		return nil, err
+	}
+
+	durations := map[int]time.Duration{}
+	for _, step := range steps {
+		if step.State == "completed" && step.EndDate.After(step.StartDate) && !step.StartDate.IsZero() {
+			durations[step.ID] = step.EndDate.Sub(step.StartDate)
+		}
+	}
+
+	query := `SELECT sr.step_id, sr.start_date, sr.end_date FROM step_runs sr
+        JOIN steps s ON s.id = sr.step_id
+        WHERE s.map_id = ? AND sr.state = 'completed'`
+	rows, err := db.conn.Query(query, mapID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	total := map[int]time.Duration{}
+	count := map[int]int{}
+	for rows.Next() {
+		var stepID int
+		var start, end time.Time
+		if err := rows.Scan(&stepID, &start, &end); err != nil {
+			return nil, err
+		}
+		if start.IsZero() || !end.After(start) {
+			continue
+		}
+		total[stepID] += end.Sub(start)
+		count[stepID]++
+	}
+	for stepID, n := range count {
+		durations[stepID] = total[stepID] / time.Duration(n)
+	}
+	return durations, rows.Err()
+}
+
+// Updatemap modifies an existing map. Like SyncMap, it refuses to save a map
+// whose steps do not form a valid graph
 func (db *DB) UpdateMap(m models.Map) error {
-	query := `UPDATE maps SET name = ?, schedule_interval = ?, is_active = ?, start_date = ? WHERE id = ?`
-	_, err := db.conn.Exec(query, m.Name, m.ScheduleInterval, m.IsActive, m.StartDate, m.ID)
-	return err
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE maps SET name = ?, schedule_interval = ?, is_active = ?, start_date = ?, notifiers = ?, sla = ?, sla_from = ?,
+        hooks = ?, "trigger" = ? WHERE id = ?`
+	_, err = tx.Exec(query, m.Name, m.ScheduleInterval, m.IsActive, m.StartDate, encodeList(m.Notifiers),
+		int64(m.SLA/time.Second), m.SLAFrom, encodeList(m.Hooks), encodeObject(m.Trigger), m.ID)
+	if err != nil {
+		return err
+	}
+	if err := validateMapSteps(tx, m.ID, nil); err != nil {
+		return fmt.Errorf("map %s: %w", m.Name, err)
+	}
+	return tx.Commit()
 }
 
-// UpdateStep modifies an existing task
+// PauseMap stops new runs of a map from being scheduled, recording who paused
+// it and why. Runs already in progress are left alone
+func (db *DB) PauseMap(id int, by string, reason string) error {
+	query := `UPDATE maps SET is_active = false, paused_by = ?, pause_reason = ?, paused_at = ? WHERE id = ?`
+	return expectRow(db.conn.Exec(query, by, reason, time.Now().UTC(), id))
+}
+
+// UnpauseMap lets the scheduler create runs of a map again
+func (db *DB) UnpauseMap(id int) error {
+	query := `UPDATE maps SET is_active = true, paused_by = NULL, pause_reason = NULL, paused_at = NULL WHERE id = ?`
+	return expectRow(db.conn.Exec(query, id))
+}
+
+// expectRow turns an update that matched no row into sql.ErrNoRows.
+func expectRow(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateStep modifies an existing task, rejecting dependencies that break
+// the graph of its map
 func (db *DB) UpdateStep(step models.Step) error {
-	query := `UPDATE steps SET name = ?, map_id = ?, state = ?, start_date = ?, end_date = ? WHERE id = ?`
-	result, err := db.conn.Exec(query, step.Name, step.MapID, step.State, step.StartDate, step.EndDate, step.ID)
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE steps SET name = ?, map_id = ?, state = ?, command = ?, start_date = ?, end_date = ?,
+        dependencies = ?, connections = ?, retries = ?, retry_delay = ?, sla = ?, hooks = ?, sensor = ?, produces = ?,
+        wait_for = ? WHERE id = ?`
+	result, err := tx.Exec(query, step.Name, step.MapID, step.State, step.Command, step.StartDate, step.EndDate,
+		encodeList(step.Dependencies), encodeList(step.Connections), step.Retries, int64(step.RetryDelay/time.Second),
+		int64(step.SLA/time.Second), encodeList(step.Hooks), encodeObject(step.Sensor), encodeList(step.Produces),
+		encodeList(step.WaitFor), step.ID)
 	if err != nil {
 		// Detailed logging of the error
-		log.Printf("Failed to update step: %v, error: %v\n", step, err)
+		slog.Error("Failed to update step", "map_id", step.MapID, "step_id", step.ID, "error", err)
 		return err
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		log.Printf("Failed to retrieve affected rows for step: %v, error: %v\n", step, err)
+		slog.Error("Failed to retrieve affected rows", "map_id", step.MapID, "step_id", step.ID, "error", err)
 		return err
 	}
 	if rowsAffected == 0 {
-		log.Printf("No rows affected for step: %v\n", step)
+		slog.Warn("No rows affected updating step", "map_id", step.MapID, "step_id", step.ID)
+	}
+
+	if err := validateStepWrite(tx, step); err != nil {
+		return fmt.Errorf("step %s: %w", step.Name, err)
 	}
+	return tx.Commit()
+}
 
+// SetStepState records the runtime state of a step without touching its
+// definition, which may have been reloaded while the step was running
+func (db *DB) SetStepState(step models.Step) error {
+	query := `UPDATE steps SET state = ?, start_date = ?, end_date = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, step.State, step.StartDate, step.EndDate, step.ID)
 	return err
 }
 
-// Deletemap removes a map from the database
+// Deletemap removes a map from the database together with its steps and runs
 func (db *DB) DeleteMap(id int) error {
-	query := `DELETE FROM maps WHERE id = ?`
-	_, err := db.conn.Exec(query, id)
-	return err
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		`DELETE FROM map_permissions WHERE map_id = ?`,
+		`DELETE FROM sla_misses WHERE map_id = ?`,
+		`DELETE FROM trigger_files WHERE map_id = ?`,
+		`DELETE FROM dataset_triggers WHERE map_id = ?`,
+		`DELETE FROM dataset_events WHERE map_id = ?`,
+		`DELETE FROM step_logs WHERE run_id IN (SELECT id FROM map_runs WHERE map_id = ?)`,
+		`DELETE FROM step_run_marks WHERE run_id IN (SELECT id FROM map_runs WHERE map_id = ?)`,
+		`DELETE FROM step_run_attempts WHERE run_id IN (SELECT id FROM map_runs WHERE map_id = ?)`,
+		`DELETE FROM step_runs WHERE run_id IN (SELECT id FROM map_runs WHERE map_id = ?)`,
+		`DELETE FROM map_runs WHERE map_id = ?`,
+		`DELETE FROM steps WHERE map_id = ?`,
+		`DELETE FROM maps WHERE id = ?`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 // DeleteStep removes a task from the database
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+// createDatasetTables keeps the updates of datasets by completed steps, and
+// which of them started each run of the maps consuming the datasets.
+func createDatasetTables(db *sql.DB) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS dataset_events (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        dataset TEXT,
+        map_id INT,
+        run_id INT,
+        step_id INT,
+        created_at TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS dataset_events_dataset ON dataset_events (dataset);
+    CREATE TABLE IF NOT EXISTS dataset_triggers (
+        run_id INT,
+        map_id INT,
+        dataset TEXT,
+        event_id INT,
+        PRIMARY KEY (run_id, dataset),
+        FOREIGN KEY (map_id) REFERENCES maps(id)
+    );`
+	_, err := db.Exec(query)
+	return err
+}
+
+const datasetEventColumns = `id, dataset, map_id, run_id, step_id, created_at`
+
+func scanDatasetEvent(row rowScanner) (models.DatasetEvent, error) {
+	var e models.DatasetEvent
+	err := row.Scan(&e.ID, &e.Dataset, &e.MapID, &e.RunID, &e.StepID, &e.CreatedAt)
+	return e, err
+}
+
+// queryDatasetEvents runs a query selecting datasetEventColumns
+func (db *DB) queryDatasetEvents(query string, args ...any) ([]models.DatasetEvent, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.DatasetEvent
+	for rows.Next() {
+		e, err := scanDatasetEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// RecordDatasetEvent records an update of a dataset
+func (db *DB) RecordDatasetEvent(e *models.DatasetEvent) error {
+	e.CreatedAt = time.Now().UTC()
+	query := `INSERT INTO dataset_events (dataset, map_id, run_id, step_id, created_at) VALUES (?, ?, ?, ?, ?)`
+	result, err := db.conn.Exec(query, e.Dataset, e.MapID, e.RunID, e.StepID, e.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	e.ID = int(id)
+	return err
+}
+
+// PendingDatasetEvents returns, for each of the given datasets updated since
+// it last started a run of a map, its latest update. Datasets without such an
+// update are left out.
+func (db *DB) PendingDatasetEvents(mapID int, datasets []string) ([]models.DatasetEvent, error) {
+	query := `SELECT ` + datasetEventColumns + ` FROM dataset_events WHERE dataset = ?
+        AND id > (SELECT COALESCE(MAX(event_id), 0) FROM dataset_triggers WHERE map_id = ? AND dataset = ?)
+        ORDER BY id DESC LIMIT 1`
+	var events []models.DatasetEvent
+	for _, dataset := range datasets {
+		e, err := scanDatasetEvent(db.conn.QueryRow(query, dataset, mapID, dataset))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// GetRunDatasetEvents returns the dataset updates that started a run
+func (db *DB) GetRunDatasetEvents(runID int) ([]models.DatasetEvent, error) {
+	query := `SELECT ` + datasetEventColumns + ` FROM dataset_events
+        WHERE id IN (SELECT event_id FROM dataset_triggers WHERE run_id = ?) ORDER BY dataset`
+	return db.queryDatasetEvents(query, runID)
+}
+
+// LatestDatasetEvents returns the latest update of every dataset, by name
+func (db *DB) LatestDatasetEvents() (map[string]models.DatasetEvent, error) {
+	query := `SELECT ` + datasetEventColumns + ` FROM dataset_events
+        WHERE id IN (SELECT MAX(id) FROM dataset_events GROUP BY dataset)`
+	events, err := db.queryDatasetEvents(query)
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]models.DatasetEvent{}
+	for _, e := range events {
+		latest[e.Dataset] = e
+	}
+	return latest, nil
+}
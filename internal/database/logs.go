@@ -0,0 +1,51 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+func createStepLogsTable(db *sql.DB) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS step_logs (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        run_id INT,
+        step_id INT,
+        attempt INT,
+        output TEXT,
+        created_at TIMESTAMP,
+        FOREIGN KEY (run_id) REFERENCES map_runs(id)
+    );`
+	_, err := db.Exec(query)
+	return err
+}
+
+// SaveStepLog stores the output of an attempt of a step instance.
+func (db *DB) SaveStepLog(runID int, stepID int, attempt int, output string) error {
+	query := `INSERT INTO step_logs (run_id, step_id, attempt, output, created_at) VALUES (?, ?, ?, ?, ?)`
+	_, err := db.conn.Exec(query, runID, stepID, attempt, output, time.Now().UTC())
+	return err
+}
+
+// GetStepLogs returns the output of every attempt of a step instance, oldest
+// first.
+func (db *DB) GetStepLogs(runID int, stepID int) ([]models.StepLog, error) {
+	query := `SELECT run_id, step_id, attempt, output, created_at FROM step_logs WHERE run_id = ? AND step_id = ? ORDER BY id`
+	rows, err := db.conn.Query(query, runID, stepID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []models.StepLog
+	for rows.Next() {
+		var l models.StepLog
+		if err := rows.Scan(&l.RunID, &l.StepID, &l.Attempt, &l.Output, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"pilot/internal/metrics"
+)
+
+// timedDB records the latency of the queries run on the connection and, through
+// the timedTx it begins, inside transactions.
+type timedDB struct {
+	*sql.DB
+}
+
+func (t timedDB) Exec(query string, args ...any) (sql.Result, error) {
+	defer metrics.ObserveQuery(query, time.Now())
+	return t.DB.Exec(query, args...)
+}
+
+func (t timedDB) Query(query string, args ...any) (*sql.Rows, error) {
+	defer metrics.ObserveQuery(query, time.Now())
+	return t.DB.Query(query, args...)
+}
+
+func (t timedDB) QueryRow(query string, args ...any) *sql.Row {
+	defer metrics.ObserveQuery(query, time.Now())
+	return t.DB.QueryRow(query, args...)
+}
+
+func (t timedDB) Begin() (timedTx, error) {
+	tx, err := t.DB.Begin()
+	return timedTx{tx}, err
+}
+
+// timedTx records the latency of the queries run inside a transaction.
+type timedTx struct {
+	*sql.Tx
+}
+
+func (t timedTx) Exec(query string, args ...any) (sql.Result, error) {
+	defer metrics.ObserveQuery(query, time.Now())
+	return t.Tx.Exec(query, args...)
+}
+
+func (t timedTx) Query(query string, args ...any) (*sql.Rows, error) {
+	defer metrics.ObserveQuery(query, time.Now())
+	return t.Tx.Query(query, args...)
+}
+
+func (t timedTx) QueryRow(query string, args ...any) *sql.Row {
+	defer metrics.ObserveQuery(query, time.Now())
+	return t.Tx.QueryRow(query, args...)
+}
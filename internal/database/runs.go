@@ -0,0 +1,543 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+func createMapRunsTable(db *sql.DB) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS map_runs (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        map_id INT,
+        run_type VARCHAR(255),
+        logical_date TIMESTAMP,
+        state VARCHAR(255),
+        conf TEXT,
+        start_date TIMESTAMP,
+        end_date TIMESTAMP,
+        trace_parent TEXT,
+        FOREIGN KEY (map_id) REFERENCES maps(id)
+    );`
+	_, err := db.Exec(query)
+	return err
+}
+
+func createStepRunsTable(db *sql.DB) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS step_runs (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        run_id INT,
+        step_id INT,
+        step_name VARCHAR(255),
+        state VARCHAR(255),
+        attempt INT DEFAULT 0,
+        start_date TIMESTAMP,
+        end_date TIMESTAMP,
+        queued_at TIMESTAMP,
+        sensor_started_at TIMESTAMP,
+        reschedule_at TIMESTAMP,
+        UNIQUE (run_id, step_id),
+        FOREIGN KEY (run_id) REFERENCES map_runs(id)
+    );`
+	_, err := db.Exec(query)
+	return err
+}
+
+// createStepRunAttemptsTable keeps the earlier attempts of step instances
+// that were cleared and run again.
+func createStepRunAttemptsTable(db *sql.DB) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS step_run_attempts (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        run_id INT,
+        step_id INT,
+        step_name VARCHAR(255),
+        state VARCHAR(255),
+        attempt INT,
+        start_date TIMESTAMP,
+        end_date TIMESTAMP,
+        FOREIGN KEY (run_id) REFERENCES map_runs(id)
+    );`
+	_, err := db.Exec(query)
+	return err
+}
+
+// createStepRunMarksTable keeps the audit trail of step states forced by
+// operators.
+func createStepRunMarksTable(db *sql.DB) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS step_run_marks (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        run_id INT,
+        step_id INT,
+        step_name VARCHAR(255),
+        previous_state VARCHAR(255),
+        state VARCHAR(255),
+        marked_by VARCHAR(255),
+        note TEXT,
+        marked_at TIMESTAMP,
+        FOREIGN KEY (run_id) REFERENCES map_runs(id)
+    );`
+	_, err := db.Exec(query)
+	return err
+}
+
+const mapRunColumns = `id, map_id, run_type, logical_date, state, conf, start_date, end_date, trace_parent`
+
+func scanMapRun(row rowScanner) (models.MapRun, error) {
+	var run models.MapRun
+	var conf, traceParent sql.NullString
+	err := row.Scan(&run.ID, &run.MapID, &run.RunType, &run.LogicalDate, &run.State, &conf, &run.StartDate, &run.EndDate, &traceParent)
+	if err != nil {
+		return run, err
+	}
+	run.TraceParent = traceParent.String
+	if conf.Valid && conf.String != "" {
+		err = json.Unmarshal([]byte(conf.String), &run.Conf)
+	}
+	return run, err
+}
+
+const stepRunColumns = `id, run_id, step_id, step_name, state, attempt, start_date, end_date`
+
+func scanStepRun(row rowScanner) (models.StepRun, error) {
+	var run models.StepRun
+	err := row.Scan(&run.ID, &run.RunID, &run.StepID, &run.StepName, &run.State, &run.Attempt, &run.StartDate, &run.EndDate)
+	return run, err
+}
+
+// CreateMapRun stores a new running map run with a pending instance of every
+// step, and returns the run ID
+func (db *DB) CreateMapRun(run *models.MapRun, steps []models.Step) (int, error) {
+	return db.createMapRun(run, steps, nil)
+}
+
+// CreateDatasetRun stores a new map run like CreateMapRun, together with the
+// dataset updates that started it, so that the updates cannot start another
+// run should recording them fail
+func (db *DB) CreateDatasetRun(run *models.MapRun, steps []models.Step, events []models.DatasetEvent) (int, error) {
+	return db.createMapRun(run, steps, events)
+}
+
+func (db *DB) createMapRun(run *models.MapRun, steps []models.Step, events []models.DatasetEvent) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var conf string
+	if len(run.Conf) > 0 {
+		data, err := json.Marshal(run.Conf)
+		if err != nil {
+			return 0, err
+		}
+		conf = string(data)
+	}
+
+	run.State = "running"
+	run.StartDate = time.Now().UTC()
+	query := `INSERT INTO map_runs (map_id, run_type, logical_date, state, conf, start_date, end_date, trace_parent) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, run.MapID, run.RunType, run.LogicalDate.UTC(), run.State, conf, run.StartDate, time.Time{}, run.TraceParent)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, step := range steps {
+		query := `INSERT INTO step_runs (run_id, step_id, step_name, state, attempt, start_date, end_date) VALUES (?, ?, ?, ?, 0, ?, ?)`
+		if _, err := tx.Exec(query, id, step.ID, step.Name, "pending", time.Time{}, time.Time{}); err != nil {
+			return 0, err
+		}
+	}
+	for _, e := range events {
+		query := `INSERT INTO dataset_triggers (run_id, map_id, dataset, event_id) VALUES (?, ?, ?, ?)`
+		if _, err := tx.Exec(query, id, run.MapID, e.Dataset, e.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	run.ID = int(id)
+	return run.ID, nil
+}
+
+// GetMapRun retrieves a map run by its ID
+func (db *DB) GetMapRun(id int) (*models.MapRun, error) {
+	query := `SELECT ` + mapRunColumns + ` FROM map_runs WHERE id = ?`
+	run, err := scanMapRun(db.conn.QueryRow(query, id))
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// GetMapRunByLogicalDate retrieves the latest run of a map for a logical date
+func (db *DB) GetMapRunByLogicalDate(mapID int, logicalDate time.Time) (*models.MapRun, error) {
+	query := `SELECT ` + mapRunColumns + ` FROM map_runs WHERE map_id = ? AND logical_date = ? ORDER BY id DESC LIMIT 1`
+	run, err := scanMapRun(db.conn.QueryRow(query, mapID, logicalDate.UTC()))
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// ListMapRuns returns the most recent runs first. A mapID of 0 lists the runs
+// of every map, and a limit of 0 returns all of them
+func (db *DB) ListMapRuns(mapID int, limit int) ([]models.MapRun, error) {
+	query := `SELECT ` + mapRunColumns + ` FROM map_runs WHERE (? = 0 OR map_id = ?) ORDER BY id DESC`
+	args := []any{mapID, mapID}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	return db.queryMapRuns(query, args...)
+}
+
+// RunFilter selects map runs for FindMapRuns. Zero fields match everything.
+type RunFilter struct {
+	MapID  int
+	State  string
+	Limit  int
+	Offset int
+}
+
+// FindMapRuns returns a page of the runs matching f, most recent first, and
+// the total number of matching runs
+func (db *DB) FindMapRuns(f RunFilter) ([]models.MapRun, int, error) {
+	where := ` WHERE (? = 0 OR map_id = ?) AND (? = '' OR state = ?)`
+	args := []any{f.MapID, f.MapID, f.State, f.State}
+
+	var total int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM map_runs`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	query := `SELECT ` + mapRunColumns + ` FROM map_runs` + where + ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	limit := f.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	runs, err := db.queryMapRuns(query, append(args, limit, f.Offset)...)
+	return runs, total, err
+}
+
+// GetActiveMapRuns returns the runs that are still in progress
+func (db *DB) GetActiveMapRuns() ([]models.MapRun, error) {
+	query := `SELECT ` + mapRunColumns + ` FROM map_runs WHERE state = 'running' ORDER BY id`
+	return db.queryMapRuns(query)
+}
+
+func (db *DB) queryMapRuns(query string, args ...any) ([]models.MapRun, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []models.MapRun
+	for rows.Next() {
+		run, err := scanMapRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// LastScheduledRun returns the logical date of the latest scheduled run of a
+// map, or the zero time if it never ran
+func (db *DB) LastScheduledRun(mapID int) (time.Time, error) {
+	var logicalDate time.Time
+	query := `SELECT logical_date FROM map_runs WHERE map_id = ? AND run_type = 'scheduled' ORDER BY id DESC LIMIT 1`
+	err := db.conn.QueryRow(query, mapID).Scan(&logicalDate)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return logicalDate, err
+}
+
+// SetMapRunState records the state of a map run, setting its end date once
+// it has finished
+func (db *DB) SetMapRunState(id int, state string) error {
+	endDate := time.Time{}
+	if state != "running" {
+		endDate = time.Now().UTC()
+	}
+	query := `UPDATE map_runs SET state = ?, end_date = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, state, endDate, id)
+	return err
+}
+
+// CancelMapRuns stops the in-progress runs of a map. Step instances that have
+// not started are cancelled, while running ones are left to finish. It returns
+// the runs cancelled
+func (db *DB) CancelMapRuns(mapID int) ([]models.MapRun, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + mapRunColumns + ` FROM map_runs WHERE map_id = ? AND state = 'running' ORDER BY id`
+	rows, err := tx.Query(query, mapID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []models.MapRun
+	for rows.Next() {
+		run, err := scanMapRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	query = `UPDATE step_runs SET state = 'cancelled', end_date = ?
+        WHERE state IN ('pending', 'queued', 'up_for_reschedule') AND run_id IN (SELECT id FROM map_runs WHERE map_id = ? AND state = 'running')`
+	if _, err := tx.Exec(query, now, mapID); err != nil {
+		return nil, err
+	}
+	query = `UPDATE map_runs SET state = 'cancelled', end_date = ? WHERE map_id = ? AND state = 'running'`
+	if _, err := tx.Exec(query, now, mapID); err != nil {
+		return nil, err
+	}
+	for i := range runs {
+		runs[i].State = "cancelled"
+		runs[i].EndDate = now
+	}
+	return runs, tx.Commit()
+}
+
+// GetStepRuns retrieves the step instances of a map run
+func (db *DB) GetStepRuns(runID int) ([]models.StepRun, error) {
+	query := `SELECT ` + stepRunColumns + ` FROM step_runs WHERE run_id = ? ORDER BY id`
+	return db.queryStepRuns(query, runID)
+}
+
+func (db *DB) queryStepRuns(query string, args ...any) ([]models.StepRun, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []models.StepRun
+	for rows.Next() {
+		run, err := scanStepRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// SetStepRunState records the state of a step within a map run
+func (db *DB) SetStepRunState(step models.Step) error {
+	query := `UPDATE step_runs SET state = ?, attempt = ?, start_date = ?, end_date = ? WHERE run_id = ? AND step_id = ?`
+	_, err := db.conn.Exec(query, step.State, step.Attempt, step.StartDate, step.EndDate, step.RunID, step.ID)
+	return err
+}
+
+// ClaimStepRun moves a queued step instance to running. It returns false when
+// the instance was not queued, for example because another worker claimed it
+func (db *DB) ClaimStepRun(runID int, stepID int) (bool, error) {
+	query := `UPDATE step_runs SET state = 'running', start_date = ? WHERE run_id = ? AND step_id = ? AND state = 'queued'`
+	result, err := db.conn.Exec(query, time.Now(), runID, stepID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// NextQueuedStep returns the oldest queued step instance together with its
+// definition, or nil when nothing is queued
+func (db *DB) NextQueuedStep() (*models.Step, error) {
+	var runID, stepID, attempt int
+	query := `SELECT sr.run_id, sr.step_id, sr.attempt FROM step_runs sr
+        JOIN steps s ON s.id = sr.step_id
+        WHERE sr.state = 'queued' ORDER BY sr.id LIMIT 1`
+	err := db.conn.QueryRow(query).Scan(&runID, &stepID, &attempt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	step, err := db.GetStepByID(stepID)
+	if err != nil {
+		return nil, err
+	}
+	step.RunID = runID
+	step.Attempt = attempt
+	step.State = "queued"
+	return step, nil
+}
+
+// ClearStepRuns resets step instances of a run to pending so the scheduler
+// runs them again, and reopens the run. Instances that already ran are copied
+// to the attempt history first
+func (db *DB) ClearStepRuns(runID int, stepIDs []int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stepID := range stepIDs {
+		query := `INSERT INTO step_run_attempts (run_id, step_id, step_name, state, attempt, start_date, end_date)
+            SELECT run_id, step_id, step_name, state, attempt, start_date, end_date FROM step_runs
+            WHERE run_id = ? AND step_id = ? AND state != 'pending'`
+		if _, err := tx.Exec(query, runID, stepID); err != nil {
+			return err
+		}
+		query = `UPDATE step_runs SET state = 'pending', start_date = ?, end_date = ?, sensor_started_at = NULL, reschedule_at = NULL
+            WHERE run_id = ? AND step_id = ?`
+		if err := expectRow(tx.Exec(query, time.Time{}, time.Time{}, runID, stepID)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE map_runs SET state = 'running', end_date = ? WHERE id = ?`, time.Time{}, runID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetStepRunAttempts returns the earlier attempts of the steps of a run that
+// were cleared, oldest first
+func (db *DB) GetStepRunAttempts(runID int) ([]models.StepRun, error) {
+	query := `SELECT ` + stepRunColumns + ` FROM step_run_attempts WHERE run_id = ? ORDER BY id`
+	return db.queryStepRuns(query, runID)
+}
+
+// MarkStepRun forces the state of a step instance and records who did it and
+// why in the step_run_marks table
+func (db *DB) MarkStepRun(mark *models.StepRunMark) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	mark.MarkedAt = time.Now().UTC()
+	query := `UPDATE step_runs SET state = ?, end_date = ? WHERE run_id = ? AND step_id = ? AND state = ?`
+	if err := expectRow(tx.Exec(query, mark.State, mark.MarkedAt, mark.RunID, mark.StepID, mark.PreviousState)); err != nil {
+		return err
+	}
+	query = `INSERT INTO step_run_marks (run_id, step_id, step_name, previous_state, state, marked_by, note, marked_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, mark.RunID, mark.StepID, mark.StepName, mark.PreviousState, mark.State, mark.MarkedBy, mark.Note, mark.MarkedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	mark.ID = int(id)
+	return tx.Commit()
+}
+
+// GetStepRunMarks returns the step states forced by operators in a run,
+// oldest first
+func (db *DB) GetStepRunMarks(runID int) ([]models.StepRunMark, error) {
+	query := `SELECT id, run_id, step_id, step_name, previous_state, state, marked_by, note, marked_at
+        FROM step_run_marks WHERE run_id = ? ORDER BY id`
+	rows, err := db.conn.Query(query, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var marks []models.StepRunMark
+	for rows.Next() {
+		var m models.StepRunMark
+		err := rows.Scan(&m.ID, &m.RunID, &m.StepID, &m.StepName, &m.PreviousState, &m.State, &m.MarkedBy, &m.Note, &m.MarkedAt)
+		if err != nil {
+			return nil, err
+		}
+		marks = append(marks, m)
+	}
+	return marks, rows.Err()
+}
+
+// TransitionStepRun moves a step instance from one state to another. It
+// returns false when the instance was not in the expected state. The time an
+// instance is queued is kept to trace how long it waits for a worker
+func (db *DB) TransitionStepRun(runID int, stepID int, from string, to string) (bool, error) {
+	query := `UPDATE step_runs SET state = ?, queued_at = CASE WHEN ? = 'queued' THEN ? ELSE queued_at END
+        WHERE run_id = ? AND step_id = ? AND state = ?`
+	result, err := db.conn.Exec(query, to, to, time.Now().UTC(), runID, stepID, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// StepRunQueuedAt returns when a step instance was last queued, or the zero
+// time if it never was
+func (db *DB) StepRunQueuedAt(runID int, stepID int) (time.Time, error) {
+	var queuedAt sql.NullTime
+	query := `SELECT queued_at FROM step_runs WHERE run_id = ? AND step_id = ?`
+	err := db.conn.QueryRow(query, runID, stepID).Scan(&queuedAt)
+	return queuedAt.Time, err
+}
+
+// StartSensor returns when a sensor step instance first poked, and whether
+// that is now. Rescheduled sensors keep the time of their first poke, which
+// their timeout counts from
+func (db *DB) StartSensor(runID int, stepID int, now time.Time) (time.Time, bool, error) {
+	var started sql.NullTime
+	query := `SELECT sensor_started_at FROM step_runs WHERE run_id = ? AND step_id = ?`
+	if err := db.conn.QueryRow(query, runID, stepID).Scan(&started); err != nil {
+		return time.Time{}, false, err
+	}
+	if started.Valid {
+		return started.Time, false, nil
+	}
+	query = `UPDATE step_runs SET sensor_started_at = ? WHERE run_id = ? AND step_id = ?`
+	_, err := db.conn.Exec(query, now.UTC(), runID, stepID)
+	return now, true, err
+}
+
+// RescheduleStepRun releases a running sensor step instance until its next
+// poke at the given time, when the scheduler queues it again
+func (db *DB) RescheduleStepRun(runID int, stepID int, at time.Time) error {
+	query := `UPDATE step_runs SET state = 'up_for_reschedule', reschedule_at = ? WHERE run_id = ? AND step_id = ? AND state = 'running'`
+	return expectRow(db.conn.Exec(query, at.UTC(), runID, stepID))
+}
+
+// StepRunRescheduleAt returns when a rescheduled step instance pokes next, or
+// the zero time if it was never rescheduled
+func (db *DB) StepRunRescheduleAt(runID int, stepID int) (time.Time, error) {
+	var at sql.NullTime
+	query := `SELECT reschedule_at FROM step_runs WHERE run_id = ? AND step_id = ?`
+	err := db.conn.QueryRow(query, runID, stepID).Scan(&at)
+	return at.Time, err
+}
+
+// ResetQueuedStepRuns moves queued step instances back to pending so that
+// they are queued again, for example after the scheduler restarted
+func (db *DB) ResetQueuedStepRuns() error {
+	query := `UPDATE step_runs SET state = 'pending' WHERE state = 'queued'`
+	_, err := db.conn.Exec(query)
+	return err
+}
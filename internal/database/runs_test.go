@@ -0,0 +1,149 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+// addTestMap stores the map extract -> load and returns its ID and steps.
+func addTestMap(t *testing.T, db *DB) (int, []models.Step) {
+	t.Helper()
+	m := models.NewMap("sales", "0 6 * * *", time.Time{}, time.Time{}, []models.Step{
+		{Name: "extract", Command: "extract.py"},
+		{Name: "load", Command: "load.py"},
+	})
+	id, _, err := db.SyncMap(*m, map[string][]string{"load": {"extract"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps, err := db.GetStepsByMapID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, steps
+}
+
+func TestPauseMap(t *testing.T) {
+	db := newTestDB(t)
+	id, _ := addTestMap(t, db)
+
+	if err := db.PauseMap(id, "alice", "warehouse migration"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := db.GetMapByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.IsActive || m.PausedBy != "alice" || m.PauseReason != "warehouse migration" || m.PausedAt == nil {
+		t.Errorf("paused map = %+v", m)
+	}
+
+	// Syncing the definition again must not undo an operator pause
+	if _, _, err := db.SyncMap(*models.NewMap("sales", "0 7 * * *", time.Time{}, time.Time{}, m.Steps), nil); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ = db.GetMapByID(id); m.IsActive {
+		t.Error("SyncMap reactivated a paused map")
+	}
+
+	if err := db.UnpauseMap(id); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ = db.GetMapByID(id); !m.IsActive || m.PausedBy != "" || m.PausedAt != nil {
+		t.Errorf("unpaused map = %+v", m)
+	}
+
+	if err := db.PauseMap(id+1, "alice", ""); err == nil {
+		t.Error("expected an error pausing a missing map")
+	}
+}
+
+func TestCancelMapRuns(t *testing.T) {
+	db := newTestDB(t)
+	id, steps := addTestMap(t, db)
+
+	run := &models.MapRun{MapID: id, RunType: "manual", LogicalDate: time.Now()}
+	if _, err := db.CreateMapRun(run, steps); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.TransitionStepRun(run.ID, steps[0].ID, "pending", "running"); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, err := db.CancelMapRuns(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cancelled) != 1 || cancelled[0].ID != run.ID || cancelled[0].State != "cancelled" || cancelled[0].EndDate.IsZero() {
+		t.Errorf("CancelMapRuns = %+v, want run %d cancelled", cancelled, run.ID)
+	}
+	if got, _ := db.GetMapRun(run.ID); got.State != "cancelled" {
+		t.Errorf("run state = %s, want cancelled", got.State)
+	}
+	instances, err := db.GetStepRuns(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"extract": "running", "load": "cancelled"}
+	for _, instance := range instances {
+		if instance.State != want[instance.StepName] {
+			t.Errorf("%s state = %s, want %s", instance.StepName, instance.State, want[instance.StepName])
+		}
+	}
+}
+
+func TestStepDurations(t *testing.T) {
+	db := newTestDB(t)
+	id, steps := addTestMap(t, db)
+	extract := steps[0]
+
+	start := time.Date(2024, time.March, 1, 6, 0, 0, 0, time.UTC)
+	for _, minutes := range []time.Duration{1, 3} {
+		run := &models.MapRun{MapID: id, RunType: "manual", LogicalDate: start}
+		if _, err := db.CreateMapRun(run, steps); err != nil {
+			t.Fatal(err)
+		}
+		step := extract
+		step.RunID, step.State, step.StartDate, step.EndDate = run.ID, "completed", start, start.Add(minutes*time.Minute)
+		if err := db.SetStepRunState(step); err != nil {
+			t.Fatal(err)
+		}
+		start = start.Add(24 * time.Hour)
+	}
+
+	durations, err := db.StepDurations(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(durations) != 1 || durations[extract.ID] != 2*time.Minute {
+		t.Errorf("StepDurations() = %v, want extract averaging 2m and no entry for load", durations)
+	}
+}
+
+func TestCreateDatasetRunIsAtomic(t *testing.T) {
+	db := newTestDB(t)
+	id, steps := addTestMap(t, db)
+	e := models.DatasetEvent{Dataset: "warehouse.orders", MapID: id, StepID: steps[1].ID}
+	if err := db.RecordDatasetEvent(&e); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recording the same dataset twice for a run fails, and so must the run
+	run := &models.MapRun{MapID: id, RunType: models.RunTypeDataset, LogicalDate: time.Now()}
+	if _, err := db.CreateDatasetRun(run, steps, []models.DatasetEvent{e, e}); err == nil {
+		t.Fatal("CreateDatasetRun() succeeded recording a dataset twice")
+	}
+	if runs, err := db.ListMapRuns(id, 0); err != nil || len(runs) != 0 {
+		t.Errorf("runs after a failed CreateDatasetRun = %+v, %v", runs, err)
+	}
+
+	run = &models.MapRun{MapID: id, RunType: models.RunTypeDataset, LogicalDate: time.Now()}
+	if _, err := db.CreateDatasetRun(run, steps, []models.DatasetEvent{e}); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := db.GetRunDatasetEvents(run.ID); err != nil || len(events) != 1 || events[0].ID != e.ID {
+		t.Errorf("dataset updates of the run = %+v, %v", events, err)
+	}
+}
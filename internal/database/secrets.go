@@ -0,0 +1,107 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// SecretKeyEnv holds the key used to encrypt connection secrets.
+	SecretKeyEnv = "PILOT_SECRET_KEY"
+	// SecretKeyFileEnv points to a local file holding the key instead.
+	SecretKeyFileEnv = "PILOT_SECRET_KEY_FILE"
+)
+
+var ErrNoSecretKey = errors.New("no secret key configured: set " + SecretKeyEnv + " or " + SecretKeyFileEnv)
+
+// LoadSecretKey reads the encryption key from the environment or from the
+// file named by PILOT_SECRET_KEY_FILE.
+func LoadSecretKey() ([]byte, error) {
+	if key := os.Getenv(SecretKeyEnv); key != "" {
+		return deriveKey(key), nil
+	}
+	if path := os.Getenv(SecretKeyFileEnv); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading secret key file: %w", err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return nil, fmt.Errorf("secret key file %s is empty", path)
+		}
+		return deriveKey(key), nil
+	}
+	return nil, ErrNoSecretKey
+}
+
+// deriveKey turns arbitrary key material into a 256-bit AES key.
+func deriveKey(material string) []byte {
+	sum := sha256.Sum256([]byte(material))
+	return sum[:]
+}
+
+// SetSecretKey overrides the key used to encrypt and decrypt secrets. It must
+// be called before the DB is used concurrently.
+func (db *DB) SetSecretKey(material string) {
+	db.secretKey = deriveKey(material)
+}
+
+func (db *DB) cipher() (cipher.AEAD, error) {
+	if db.secretKey == nil {
+		return nil, ErrNoSecretKey
+	}
+	block, err := aes.NewCipher(db.secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt seals plaintext with AES-GCM and returns it base64 encoded with the
+// nonce prepended.
+func (db *DB) encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	gcm, err := db.cipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (db *DB) decrypt(ciphertext string) (string, error) {
+	if ciphertext == "" {
+		return "", nil
+	}
+	gcm, err := db.cipher()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypting secret (wrong key?): %w", err)
+	}
+	return string(plaintext), nil
+}
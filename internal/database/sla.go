@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+// createSLAMissesTable keeps the map runs and step instances that missed
+// their SLA, once each.
+func createSLAMissesTable(db *sql.DB) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS sla_misses (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        map_id INT,
+        run_id INT,
+        step_id INT DEFAULT 0,
+        step_name VARCHAR(255),
+        deadline TIMESTAMP,
+        state VARCHAR(255),
+        detected_at TIMESTAMP,
+        UNIQUE (run_id, step_id),
+        FOREIGN KEY (run_id) REFERENCES map_runs(id)
+    );`
+	_, err := db.Exec(query)
+	return err
+}
+
+const slaMissColumns = `id, map_id, run_id, step_id, step_name, deadline, state, detected_at`
+
+// RecordSLAMiss stores a miss unless the same run or step instance already
+// missed its SLA. It returns whether the miss is new.
+func (db *DB) RecordSLAMiss(miss *models.SLAMiss) (bool, error) {
+	miss.DetectedAt = time.Now().UTC()
+	query := `INSERT OR IGNORE INTO sla_misses (map_id, run_id, step_id, step_name, deadline, state, detected_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := db.conn.Exec(query, miss.MapID, miss.RunID, miss.StepID, miss.StepName, miss.Deadline.UTC(), miss.State, miss.DetectedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	id, err := result.LastInsertId()
+	miss.ID = int(id)
+	return true, err
+}
+
+// GetSLAMisses returns the SLA misses of a run, map first
+func (db *DB) GetSLAMisses(runID int) ([]models.SLAMiss, error) {
+	query := `SELECT ` + slaMissColumns + ` FROM sla_misses WHERE run_id = ? ORDER BY step_id, id`
+	return db.querySLAMisses(query, runID)
+}
+
+// ListSLAMisses returns the latest SLA misses, of a single map unless mapID
+// is 0
+func (db *DB) ListSLAMisses(mapID int, limit int) ([]models.SLAMiss, error) {
+	query := `SELECT ` + slaMissColumns + ` FROM sla_misses WHERE (? = 0 OR map_id = ?) ORDER BY id DESC LIMIT ?`
+	return db.querySLAMisses(query, mapID, mapID, limit)
+}
+
+func (db *DB) querySLAMisses(query string, args ...any) ([]models.SLAMiss, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var misses []models.SLAMiss
+	for rows.Next() {
+		var m models.SLAMiss
+		if err := rows.Scan(&m.ID, &m.MapID, &m.RunID, &m.StepID, &m.StepName, &m.Deadline, &m.State, &m.DetectedAt); err != nil {
+			return nil, err
+		}
+		misses = append(misses, m)
+	}
+	return misses, rows.Err()
+}
+
+// GetSLARuns returns the runs whose SLAs may still be missed: the running
+// ones, and the ones that failed since the given time, which may be cleared
+// and rerun.
+func (db *DB) GetSLARuns(failedSince time.Time) ([]models.MapRun, error) {
+	query := `SELECT ` + mapRunColumns + ` FROM map_runs WHERE state = 'running' OR (state = 'failed' AND end_date >= ?) ORDER BY id`
+	return db.queryMapRuns(query, failedSince.UTC())
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStepWritesKeepGraphValid(t *testing.T) {
+	db := newTestDB(t)
+	id, steps := addTestMap(t, db)
+	extract, load := steps[0], steps[1]
+
+	extract.Dependencies = []int{load.ID}
+	if err := db.UpdateStep(extract); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("UpdateStep() closing a cycle = %v, want a cycle error", err)
+	}
+
+	publish := load
+	publish.Name = "publish"
+	publish.Dependencies = []int{load.ID + 100}
+	if _, err := db.AddStep(&publish); err == nil || !strings.Contains(err.Error(), "unknown step") {
+		t.Errorf("AddStep() depending on a missing step = %v, want an unknown step error", err)
+	}
+	publish.Dependencies = []int{load.ID}
+	if _, err := db.AddStep(&publish); err != nil {
+		t.Errorf("AddStep() = %v", err)
+	}
+
+	after, err := db.GetStepsByMapID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 3 || len(after[0].Dependencies) != 0 {
+		t.Errorf("steps after the rejected writes = %+v", after)
+	}
+
+	m, err := db.GetMapByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ScheduleInterval = "0 7 * * *"
+	if err := db.UpdateMap(*m); err != nil {
+		t.Errorf("UpdateMap() = %v", err)
+	}
+}
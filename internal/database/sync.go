@@ -0,0 +1,355 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"pilot/pkg/graph"
+	"pilot/pkg/models"
+)
+
+// MapChange describes one modification applied by SyncMap.
+type MapChange struct {
+	Action string `json:"action"` // created, changed, added, updated, removed, rewired or deferred
+	Target string `json:"target"` // the map or step name
+	Detail string `json:"detail,omitempty"`
+}
+
+func (c MapChange) String() string {
+	var s string
+	switch c.Action {
+	case "created":
+		s = "created map " + c.Target
+	case "changed":
+		s = "changed map " + c.Target
+	case "deferred":
+		s = "kept running step " + c.Target + " until it finishes"
+	default:
+		s = c.Action + " step " + c.Target
+	}
+	if c.Detail != "" {
+		s += ": " + c.Detail
+	}
+	return s
+}
+
+// SyncMap stores a map definition, creating the map if no map with the same
+// name exists yet. Steps are matched by name: new steps are added, changed
+// steps are updated in place (keeping their state), and steps missing from
+// the definition are removed, unless they are still running, in which case
+// the removal is deferred to a later sync. dependsOn maps a step name to the
+// names of the steps it depends on. Everything happens in one transaction,
+// and the returned list describes what changed.
+func (db *DB) SyncMap(m models.Map, dependsOn map[string][]string) (int, []MapChange, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	var changes []MapChange
+	mapID, mapChanges, err := syncMapRow(tx, m)
+	if err != nil {
+		return 0, nil, err
+	}
+	changes = append(changes, mapChanges...)
+
+	existing := map[string]models.Step{}
+	var stale []models.Step
+	rows, err := tx.Query(`SELECT `+stepColumns+` FROM steps WHERE map_id = ? ORDER BY id`, mapID)
+	if err != nil {
+		return 0, nil, err
+	}
+	for rows.Next() {
+		step, err := scanStep(rows)
+		if err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		if _, dup := existing[step.Name]; dup {
+			stale = append(stale, step)
+			continue
+		}
+		existing[step.Name] = step
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	ids := map[string]int{}
+	added := map[string]bool{}
+	for _, step := range m.Steps {
+		old, ok := existing[step.Name]
+		if !ok {
+			result, err := tx.Exec(`INSERT INTO steps (name, map_id, state, command, start_date, end_date, connections, retries, retry_delay, sla, hooks,
+                sensor, produces, wait_for) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				step.Name, mapID, "pending", step.Command, time.Time{}, time.Time{},
+				encodeList(step.Connections), step.Retries, int64(step.RetryDelay/time.Second), int64(step.SLA/time.Second),
+				encodeList(step.Hooks), encodeObject(step.Sensor), encodeList(step.Produces), encodeList(step.WaitFor))
+			if err != nil {
+				return 0, nil, err
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				return 0, nil, err
+			}
+			ids[step.Name] = int(id)
+			added[step.Name] = true
+			changes = append(changes, MapChange{Action: "added", Target: step.Name})
+			continue
+		}
+
+		delete(existing, step.Name)
+		ids[step.Name] = old.ID
+		if old.Command == step.Command && old.Retries == step.Retries && old.RetryDelay == step.RetryDelay && old.SLA == step.SLA &&
+			reflect.DeepEqual(nonNil(old.Connections), nonNil(step.Connections)) && encodeList(old.Hooks) == encodeList(step.Hooks) &&
+			encodeObject(old.Sensor) == encodeObject(step.Sensor) && encodeList(old.Produces) == encodeList(step.Produces) &&
+			encodeList(old.WaitFor) == encodeList(step.WaitFor) {
+			continue
+		}
+		_, err := tx.Exec(`UPDATE steps SET command = ?, connections = ?, retries = ?, retry_delay = ?, sla = ?, hooks = ?, sensor = ?,
+            produces = ?, wait_for = ? WHERE id = ?`,
+			step.Command, encodeList(step.Connections), step.Retries, int64(step.RetryDelay/time.Second), int64(step.SLA/time.Second),
+			encodeList(step.Hooks), encodeObject(step.Sensor), encodeList(step.Produces), encodeList(step.WaitFor), old.ID)
+		if err != nil {
+			return 0, nil, err
+		}
+		changes = append(changes, MapChange{Action: "updated", Target: step.Name})
+	}
+
+	for _, step := range existing {
+		stale = append(stale, step)
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].ID < stale[j].ID })
+	for _, step := range stale {
+		running, err := stepIsRunning(tx, step)
+		if err != nil {
+			return 0, nil, err
+		}
+		if running {
+			changes = append(changes, MapChange{Action: "deferred", Target: step.Name})
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM steps WHERE id = ?`, step.ID); err != nil {
+			return 0, nil, err
+		}
+		changes = append(changes, MapChange{Action: "removed", Target: step.Name})
+	}
+
+	for _, step := range m.Steps {
+		var deps []int
+		for _, name := range dependsOn[step.Name] {
+			id, ok := ids[name]
+			if !ok {
+				return 0, nil, fmt.Errorf("step %s depends on unknown step %s", step.Name, name)
+			}
+			deps = append(deps, id)
+		}
+
+		var previous sql.NullString
+		if err := tx.QueryRow(`SELECT dependencies FROM steps WHERE id = ?`, ids[step.Name]).Scan(&previous); err != nil {
+			return 0, nil, err
+		}
+		if previous.String == encodeList(deps) {
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE steps SET dependencies = ? WHERE id = ?`, encodeList(deps), ids[step.Name]); err != nil {
+			return 0, nil, err
+		}
+		if !added[step.Name] {
+			changes = append(changes, MapChange{
+				Action: "rewired",
+				Target: step.Name,
+				Detail: "depends on [" + strings.Join(dependsOn[step.Name], ", ") + "]",
+			})
+		}
+	}
+
+	if err := validateMapSteps(tx, mapID, ids); err != nil {
+		return 0, nil, fmt.Errorf("map %s: %w", m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	return mapID, changes, nil
+}
+
+// stepIsRunning reports whether a step is executing, either on its own or as
+// part of a map run.
+func stepIsRunning(tx timedTx, step models.Step) (bool, error) {
+	if step.State == "running" {
+		return true, nil
+	}
+	var n int
+	query := `SELECT COUNT(*) FROM step_runs WHERE step_id = ? AND state IN ('queued', 'running')`
+	err := tx.QueryRow(query, step.ID).Scan(&n)
+	return n > 0, err
+}
+
+// validateMapSteps checks the graph formed by the steps of a map. When ids is
+// not nil only the steps it holds are checked, leaving out running steps whose
+// removal was deferred by a sync.
+func validateMapSteps(tx timedTx, mapID int, ids map[string]int) error {
+	steps, err := mapSteps(tx, mapID)
+	if err != nil {
+		return err
+	}
+	if ids != nil {
+		keep := map[int]bool{}
+		for _, id := range ids {
+			keep[id] = true
+		}
+		steps = slices.DeleteFunc(steps, func(step models.Step) bool { return !keep[step.ID] })
+	}
+	return graph.Validate(steps, nil)
+}
+
+// validateStepWrite checks the dependencies of a step written on its own:
+// the problems of the graph of its map that involve the step are reported.
+// Step names are left to SyncMap, which matches steps by name.
+func validateStepWrite(tx timedTx, step models.Step) error {
+	steps, err := mapSteps(tx, step.MapID)
+	if err != nil {
+		return err
+	}
+	var invalid *graph.ValidationError
+	if err := graph.Validate(steps, nil); !errors.As(err, &invalid) {
+		return err
+	}
+
+	var problems []graph.Problem
+	for _, p := range invalid.Problems {
+		switch {
+		case p.Kind == graph.DuplicateName:
+		case p.Step.ID == step.ID, p.Kind == graph.Cycle && slices.Contains(p.Path, step.Name):
+			problems = append(problems, p)
+		}
+	}
+	if len(problems) > 0 {
+		return &graph.ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// mapSteps returns the steps of a map within tx
+func mapSteps(tx timedTx, mapID int) ([]models.Step, error) {
+	rows, err := tx.Query(`SELECT `+stepColumns+` FROM steps WHERE map_id = ? ORDER BY id`, mapID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []models.Step
+	for rows.Next() {
+		step, err := scanStep(rows)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, rows.Err()
+}
+
+// syncMapRow inserts or updates the maps row for m and returns its ID.
+func syncMapRow(tx timedTx, m models.Map) (int, []MapChange, error) {
+	query := `SELECT ` + mapColumns + ` FROM maps WHERE name = ?`
+	old, err := scanMap(tx.QueryRow(query, m.Name))
+	if err == sql.ErrNoRows {
+		var id int
+		if err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) + 1 FROM maps`).Scan(&id); err != nil {
+			return 0, nil, err
+		}
+		_, err := tx.Exec(`INSERT INTO maps (id, name, schedule_interval, is_active, start_date, notifiers, sla, sla_from, hooks, "trigger")
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, m.Name, m.ScheduleInterval, m.IsActive, m.StartDate, encodeList(m.Notifiers), int64(m.SLA/time.Second), m.SLAFrom,
+			encodeList(m.Hooks), encodeObject(m.Trigger))
+		if err != nil {
+			return 0, nil, err
+		}
+		return id, []MapChange{{Action: "created", Target: m.Name}}, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var changes []MapChange
+	if old.ScheduleInterval != m.ScheduleInterval {
+		changes = append(changes, MapChange{Action: "changed", Target: m.Name,
+			Detail: fmt.Sprintf("schedule %q -> %q", old.ScheduleInterval, m.ScheduleInterval)})
+	}
+	if !old.StartDate.Equal(m.StartDate) {
+		changes = append(changes, MapChange{Action: "changed", Target: m.Name,
+			Detail: "start date " + m.StartDate.Format(time.RFC3339)})
+	}
+	if old.PausedBy != "" {
+		// A map paused by an operator stays paused until it is unpaused.
+		m.IsActive = old.IsActive
+	}
+	if old.IsActive != m.IsActive {
+		changes = append(changes, MapChange{Action: "changed", Target: m.Name,
+			Detail: fmt.Sprintf("active %t", m.IsActive)})
+	}
+	if old.SLA != m.SLA || old.SLAFrom != m.SLAFrom {
+		changes = append(changes, MapChange{Action: "changed", Target: m.Name,
+			Detail: fmt.Sprintf("sla %v from %s", m.SLA, slaBase(m.SLAFrom))})
+	}
+	if encodeList(old.Notifiers) != encodeList(m.Notifiers) {
+		changes = append(changes, MapChange{Action: "changed", Target: m.Name,
+			Detail: fmt.Sprintf("%d notifiers", len(m.Notifiers))})
+	}
+	if encodeList(old.Hooks) != encodeList(m.Hooks) {
+		changes = append(changes, MapChange{Action: "changed", Target: m.Name,
+			Detail: fmt.Sprintf("%d hooks", len(m.Hooks))})
+	}
+	if encodeObject(old.Trigger) != encodeObject(m.Trigger) {
+		changes = append(changes, MapChange{Action: "changed", Target: m.Name, Detail: triggerDetail(m.Trigger)})
+	}
+	if len(changes) > 0 {
+		_, err := tx.Exec(`UPDATE maps SET schedule_interval = ?, is_active = ?, start_date = ?, notifiers = ?, sla = ?, sla_from = ?,
+            hooks = ?, "trigger" = ? WHERE id = ?`,
+			m.ScheduleInterval, m.IsActive, m.StartDate, encodeList(m.Notifiers), int64(m.SLA/time.Second), m.SLAFrom,
+			encodeList(m.Hooks), encodeObject(m.Trigger), old.ID)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	return old.ID, changes, nil
+}
+
+// triggerDetail describes the trigger of a map in a change.
+func triggerDetail(t *models.Trigger) string {
+	if t == nil || (t.Files == "" && len(t.Datasets) == 0) {
+		return "no trigger"
+	}
+	var parts []string
+	if t.Files != "" {
+		parts = append(parts, fmt.Sprintf("file trigger %q", t.Files))
+	}
+	if len(t.Datasets) > 0 {
+		parts = append(parts, "dataset trigger ["+strings.Join(t.Datasets, ", ")+"]")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// slaBase names the base of the SLAs of a map.
+func slaBase(from string) string {
+	if from == "" {
+		return models.SLAFromLogicalDate
+	}
+	return from
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
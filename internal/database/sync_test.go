@@ -0,0 +1,30 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+func TestSyncMapDescribesTriggerChanges(t *testing.T) {
+	db := newTestDB(t)
+	addTestMap(t, db)
+
+	m := models.NewMap("sales", "", time.Time{}, time.Time{}, []models.Step{
+		{Name: "extract", Command: "extract.py"},
+		{Name: "load", Command: "load.py"},
+	})
+	m.Trigger = &models.Trigger{Datasets: []string{"warehouse.orders"}}
+	_, changes, err := db.SyncMap(*m, map[string][]string{"load": {"extract"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "dataset trigger [warehouse.orders]"
+	for _, c := range changes {
+		if c.Detail == want {
+			return
+		}
+	}
+	t.Errorf("changes = %+v, want one with detail %q", changes, want)
+}
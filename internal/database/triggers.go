@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+// createTriggerFilesTable keeps the files that started runs of maps with a
+// file trigger, so that each file starts a single run.
+func createTriggerFilesTable(db *sql.DB) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS trigger_files (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        map_id INT,
+        path TEXT,
+        size INT,
+        mod_time TIMESTAMP,
+        run_id INT DEFAULT 0,
+        detected_at TIMESTAMP,
+        archived_to TEXT,
+        UNIQUE (map_id, path, size, mod_time),
+        FOREIGN KEY (map_id) REFERENCES maps(id)
+    );`
+	_, err := db.Exec(query)
+	return err
+}
+
+const triggerFileColumns = `id, map_id, path, size, mod_time, run_id, detected_at, archived_to`
+
+func scanTriggerFile(row rowScanner) (models.TriggerFile, error) {
+	var f models.TriggerFile
+	var archivedTo sql.NullString
+	err := row.Scan(&f.ID, &f.MapID, &f.Path, &f.Size, &f.ModTime, &f.RunID, &f.DetectedAt, &archivedTo)
+	f.ArchivedTo = archivedTo.String
+	return f, err
+}
+
+// RecordTriggerFile stores a file seen by the trigger of a map unless the
+// same version of it was already recorded. It returns whether the file is
+// new.
+func (db *DB) RecordTriggerFile(f *models.TriggerFile) (bool, error) {
+	f.DetectedAt = time.Now().UTC()
+	query := `INSERT OR IGNORE INTO trigger_files (map_id, path, size, mod_time, detected_at) VALUES (?, ?, ?, ?, ?)`
+	result, err := db.conn.Exec(query, f.MapID, f.Path, f.Size, f.ModTime.UTC(), f.DetectedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	id, err := result.LastInsertId()
+	f.ID = int(id)
+	return true, err
+}
+
+// SetTriggerFileRun records the run a file started
+func (db *DB) SetTriggerFileRun(id int, runID int) error {
+	return expectRow(db.conn.Exec(`UPDATE trigger_files SET run_id = ? WHERE id = ?`, runID, id))
+}
+
+// DeleteTriggerFile forgets a file, so that the trigger picks it up again
+func (db *DB) DeleteTriggerFile(id int) error {
+	_, err := db.conn.Exec(`DELETE FROM trigger_files WHERE id = ?`, id)
+	return err
+}
+
+// SetTriggerFileArchived records where a file was moved after its run
+// succeeded
+func (db *DB) SetTriggerFileArchived(id int, to string) error {
+	return expectRow(db.conn.Exec(`UPDATE trigger_files SET archived_to = ? WHERE id = ?`, to, id))
+}
+
+// GetRunTriggerFile returns the file that started a run
+func (db *DB) GetRunTriggerFile(runID int) (*models.TriggerFile, error) {
+	query := `SELECT ` + triggerFileColumns + ` FROM trigger_files WHERE run_id = ?`
+	f, err := scanTriggerFile(db.conn.QueryRow(query, runID))
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ListTriggerFiles returns the latest files that started runs of a map
+func (db *DB) ListTriggerFiles(mapID int, limit int) ([]models.TriggerFile, error) {
+	query := `SELECT ` + triggerFileColumns + ` FROM trigger_files WHERE map_id = ? ORDER BY id DESC LIMIT ?`
+	rows, err := db.conn.Query(query, mapID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.TriggerFile
+	for rows.Next() {
+		f, err := scanTriggerFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
@@ -0,0 +1,308 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"pilot/pkg/models"
+)
+
+// ErrInvalidCredentials is returned when a user name, password or token does
+// not match.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// tokenPrefix starts every API token, to make them easy to recognise.
+const tokenPrefix = "pilot_"
+
+func createUsersTables(db *sql.DB) error {
+	queries := []string{`
+    CREATE TABLE IF NOT EXISTS users (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name VARCHAR(255) UNIQUE NOT NULL,
+        password_hash TEXT,
+        role VARCHAR(255),
+        created_at TIMESTAMP
+    );`, `
+    CREATE TABLE IF NOT EXISTS api_tokens (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INT,
+        name VARCHAR(255),
+        token_hash VARCHAR(64) UNIQUE NOT NULL,
+        created_at TIMESTAMP,
+        last_used_at TIMESTAMP,
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );`, `
+    CREATE TABLE IF NOT EXISTS map_permissions (
+        user_id INT,
+        map_id INT,
+        role VARCHAR(255),
+        PRIMARY KEY (user_id, map_id),
+        FOREIGN KEY (user_id) REFERENCES users(id),
+        FOREIGN KEY (map_id) REFERENCES maps(id)
+    );`}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateUser adds a user of the HTTP API. The password is stored as a bcrypt
+// hash; a user without password can only authenticate with tokens.
+func (db *DB) CreateUser(name string, password string, role string) (*models.User, error) {
+	if !models.ValidRole(role) {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{Name: name, Role: role, CreatedAt: time.Now().UTC()}
+	query := `INSERT INTO users (name, password_hash, role, created_at) VALUES (?, ?, ?, ?)`
+	result, err := db.conn.Exec(query, name, hash, role, user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	user.ID = int(id)
+	return user, nil
+}
+
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// SetUserPassword replaces the password of a user.
+func (db *DB) SetUserPassword(name string, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return expectRow(db.conn.Exec(`UPDATE users SET password_hash = ? WHERE name = ?`, hash, name))
+}
+
+// SetUserRole changes the role of a user.
+func (db *DB) SetUserRole(name string, role string) error {
+	if !models.ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	return expectRow(db.conn.Exec(`UPDATE users SET role = ? WHERE name = ?`, role, name))
+}
+
+// GetUser retrieves a user by name together with its map permissions.
+func (db *DB) GetUser(name string) (*models.User, error) {
+	user, _, err := db.getUser(`WHERE name = ?`, name)
+	return user, err
+}
+
+// getUser reads the user matching where, and its password hash.
+func (db *DB) getUser(where string, args ...any) (*models.User, string, error) {
+	var user models.User
+	var hash sql.NullString
+	query := `SELECT id, name, password_hash, role, created_at FROM users ` + where
+	err := db.conn.QueryRow(query, args...).Scan(&user.ID, &user.Name, &hash, &user.Role, &user.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	if user.Permissions, err = db.mapPermissions(user.ID); err != nil {
+		return nil, "", err
+	}
+	return &user, hash.String, nil
+}
+
+// ListUsers returns every user ordered by name.
+func (db *DB) ListUsers() ([]models.User, error) {
+	rows, err := db.conn.Query(`SELECT id, name, role, created_at FROM users ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Role, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range users {
+		if users[i].Permissions, err = db.mapPermissions(users[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// DeleteUser removes a user along with its tokens and map permissions.
+func (db *DB) DeleteUser(name string) error {
+	user, err := db.GetUser(name)
+	if err != nil {
+		return err
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		`DELETE FROM api_tokens WHERE user_id = ?`,
+		`DELETE FROM map_permissions WHERE user_id = ?`,
+		`DELETE FROM users WHERE id = ?`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, user.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Authenticate checks the password of a user.
+func (db *DB) Authenticate(name string, password string) (*models.User, error) {
+	user, hash, err := db.getUser(`WHERE name = ?`, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if hash == "" || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// GrantMap gives a user a role on a single map, on top of its own role.
+func (db *DB) GrantMap(userID int, mapID int, role string) error {
+	if !models.ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	query := `INSERT INTO map_permissions (user_id, map_id, role) VALUES (?, ?, ?)
+        ON CONFLICT (user_id, map_id) DO UPDATE SET role = excluded.role`
+	_, err := db.conn.Exec(query, userID, mapID, role)
+	return err
+}
+
+// RevokeMap removes the role granted to a user on a map.
+func (db *DB) RevokeMap(userID int, mapID int) error {
+	return expectRow(db.conn.Exec(`DELETE FROM map_permissions WHERE user_id = ? AND map_id = ?`, userID, mapID))
+}
+
+func (db *DB) mapPermissions(userID int) (map[int]string, error) {
+	rows, err := db.conn.Query(`SELECT map_id, role FROM map_permissions WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions map[int]string
+	for rows.Next() {
+		var mapID int
+		var role string
+		if err := rows.Scan(&mapID, &role); err != nil {
+			return nil, err
+		}
+		if permissions == nil {
+			permissions = map[int]string{}
+		}
+		permissions[mapID] = role
+	}
+	return permissions, rows.Err()
+}
+
+// CreateToken issues a new API token for a user. The token is returned once
+// and only its SHA-256 hash is stored.
+func (db *DB) CreateToken(userID int, name string) (string, *models.APIToken, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	token := tokenPrefix + hex.EncodeToString(secret)
+
+	t := &models.APIToken{UserID: userID, Name: name, CreatedAt: time.Now().UTC()}
+	query := `INSERT INTO api_tokens (user_id, name, token_hash, created_at) VALUES (?, ?, ?, ?)`
+	result, err := db.conn.Exec(query, userID, name, hashToken(token), t.CreatedAt)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", nil, err
+	}
+	t.ID = int(id)
+	return token, t, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ListTokens returns the tokens of a user, or of every user when userID is 0.
+func (db *DB) ListTokens(userID int) ([]models.APIToken, error) {
+	query := `SELECT t.id, t.user_id, u.name, t.name, t.created_at, t.last_used_at
+        FROM api_tokens t JOIN users u ON u.id = t.user_id
+        WHERE (? = 0 OR t.user_id = ?) ORDER BY t.id`
+	rows, err := db.conn.Query(query, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		var t models.APIToken
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&t.ID, &t.UserID, &t.UserName, &t.Name, &t.CreatedAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		if lastUsed.Valid {
+			t.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeToken deletes an API token.
+func (db *DB) RevokeToken(id int) error {
+	return expectRow(db.conn.Exec(`DELETE FROM api_tokens WHERE id = ?`, id))
+}
+
+// AuthenticateToken returns the user an API token belongs to, and records
+// that the token was used.
+func (db *DB) AuthenticateToken(token string) (*models.User, error) {
+	var tokenID, userID int
+	err := db.conn.QueryRow(`SELECT id, user_id FROM api_tokens WHERE token_hash = ?`, hashToken(token)).Scan(&tokenID, &userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.conn.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, time.Now().UTC(), tokenID); err != nil {
+		return nil, err
+	}
+	user, _, err := db.getUser(`WHERE id = ?`, userID)
+	return user, err
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"pilot/pkg/models"
+)
+
+func TestUsers(t *testing.T) {
+	db := newTestDB(t)
+
+	user, err := db.CreateUser("alice", "s3cret", models.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateUser("bob", "", "superuser"); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+
+	if _, err := db.Authenticate("alice", "s3cret"); err != nil {
+		t.Errorf("Authenticate failed: %v", err)
+	}
+	for _, creds := range [][2]string{{"alice", "wrong"}, {"nobody", "s3cret"}} {
+		if _, err := db.Authenticate(creds[0], creds[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%s, %s) error = %v, want ErrInvalidCredentials", creds[0], creds[1], err)
+		}
+	}
+	var hash string
+	if err := db.conn.QueryRow(`SELECT password_hash FROM users WHERE name = 'alice'`).Scan(&hash); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(hash, "s3cret") {
+		t.Error("password stored in clear text")
+	}
+
+	mapID, _ := addTestMap(t, db)
+	if err := db.GrantMap(user.ID, mapID, models.RoleOperator); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MapRole(mapID) != models.RoleOperator || got.MapRole(mapID+1) != models.RoleViewer {
+		t.Errorf("map roles = %v", got.Permissions)
+	}
+
+	if err := db.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetUser("alice"); err == nil {
+		t.Error("user still exists after DeleteUser")
+	}
+}
+
+func TestTokens(t *testing.T) {
+	db := newTestDB(t)
+	user, err := db.CreateUser("ci", "", models.RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Authenticate("ci", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Error("a user without password must not log in with an empty one")
+	}
+
+	token, created, err := db.CreateToken(user.ID, "deploys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.AuthenticateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "ci" || got.Role != models.RoleOperator {
+		t.Errorf("AuthenticateToken = %+v", got)
+	}
+
+	tokens, err := db.ListTokens(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "deploys" || tokens[0].LastUsedAt == nil {
+		t.Errorf("tokens = %+v", tokens)
+	}
+
+	if err := db.RevokeToken(created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AuthenticateToken(token); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("revoked token error = %v, want ErrInvalidCredentials", err)
+	}
+}
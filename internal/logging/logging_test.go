@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("Skipped")
+	logger.Warn("Kept", "run_id", 7)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d records, want 1: %s", len(lines), buf.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["msg"] != "Kept" || record["level"] != "WARN" || record["run_id"] != float64(7) {
+		t.Errorf("record = %v", record)
+	}
+
+	buf.Reset()
+	if logger, err = New(&buf, "text", "DEBUG"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("Checked map", "map_id", 3)
+	if !strings.Contains(buf.String(), `level=DEBUG msg="Checked map" map_id=3`) {
+		t.Errorf("text record = %q", buf.String())
+	}
+
+	if _, err := New(&buf, "xml", "info"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if _, err := New(&buf, "text", "loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
@@ -0,0 +1,114 @@
+// Package metrics defines the Prometheus metrics of the scheduler, the
+// workers and the database, and serves them on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every pilot metric along with the Go runtime and process
+// collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// SchedulerLoopDuration observes how long each pass of the scheduler over
+	// the maps and runs takes.
+	SchedulerLoopDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pilot_scheduler_loop_duration_seconds",
+		Help:    "Duration of a scheduler pass over the active maps and runs.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// MapsEvaluated counts the maps checked by the scheduler.
+	MapsEvaluated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pilot_scheduler_maps_evaluated_total",
+		Help: "Number of times the scheduler checked whether a map is due.",
+	})
+
+	// StepsQueued counts the steps handed to the workers.
+	StepsQueued = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pilot_scheduler_steps_queued_total",
+		Help: "Number of steps queued for the workers.",
+	})
+
+	// TaskQueueDepth is the number of steps waiting in the scheduler's
+	// TaskQueue.
+	TaskQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pilot_task_queue_depth",
+		Help: "Number of steps waiting in the task queue.",
+	})
+
+	// Workers is the number of workers by state, busy or idle.
+	Workers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pilot_workers",
+		Help: "Number of workers by state.",
+	}, []string{"state"})
+
+	// StepDuration observes how long steps take, retries included.
+	StepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pilot_step_duration_seconds",
+		Help:    "Duration of step executions, retries included.",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+	}, []string{"map", "step", "outcome"})
+
+	// StepRetries counts the retries of failed step attempts.
+	StepRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pilot_step_retries_total",
+		Help: "Number of retried step attempts.",
+	}, []string{"map", "step"})
+
+	// DBQueryDuration observes the latency of database queries by operation.
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pilot_db_query_duration_seconds",
+		Help:    "Latency of database queries.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		SchedulerLoopDuration,
+		MapsEvaluated,
+		StepsQueued,
+		TaskQueueDepth,
+		Workers,
+		StepDuration,
+		StepRetries,
+		DBQueryDuration,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveQuery records the latency of a database query, labelled by its
+// first keyword, e.g. select or insert.
+func ObserveQuery(query string, start time.Time) {
+	operation := "other"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToLower(fields[0])
+	}
+	DBQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Serve exposes /metrics on addr in the background. Errors are reported on
+// the returned channel.
+func Serve(addr string) <-chan error {
+	errs := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	go func() {
+		errs <- http.ListenAndServe(addr, mux)
+	}()
+	return errs
+}
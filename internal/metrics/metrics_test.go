@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	ObserveQuery("SELECT id FROM maps", time.Now())
+	ObserveQuery("\n\t\tINSERT INTO maps (name) VALUES (?)", time.Now())
+	ObserveQuery("", time.Now())
+	StepsQueued.Inc()
+	Workers.WithLabelValues("idle").Set(2)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`pilot_db_query_duration_seconds_count{operation="select"} 1`,
+		`pilot_db_query_duration_seconds_count{operation="insert"} 1`,
+		`pilot_db_query_duration_seconds_count{operation="other"} 1`,
+		`pilot_scheduler_steps_queued_total 1`,
+		`pilot_workers{state="idle"} 2`,
+		`pilot_task_queue_depth 0`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
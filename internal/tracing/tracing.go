@@ -0,0 +1,229 @@
+// Package tracing exports map runs as OpenTelemetry traces: a root span per
+// map run and a child span per step attempt.
+//
+// A map run outlives the process that created it and its steps may run in
+// other processes, so the IDs of its root span are chosen when the run is
+// created and stored with it as a W3C traceparent. Step attempts use it as
+// their remote parent, and the root span itself is exported under those IDs
+// once the run finishes.
+package tracing
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"pilot/pkg/models"
+)
+
+// Exporters accepted by Setup.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+var propagator = propagation.TraceContext{}
+
+// Setup installs the global tracer provider for the given exporter. The OTLP
+// exporter sends spans over HTTP to the collector configured by the standard
+// OTEL_EXPORTER_OTLP_* variables, localhost:4318 by default. The stdout
+// exporter writes each span to w as soon as it ends. The returned function
+// flushes the remaining spans.
+func Setup(ctx context.Context, exporter string, w io.Writer) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	switch exporter {
+	case ExporterNone, "":
+		return noop, nil
+	case ExporterOTLP:
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return noop, err
+		}
+		return Install(sdktrace.WithBatcher(exp)).Shutdown, nil
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return noop, err
+		}
+		return Install(sdktrace.WithSyncer(exp)).Shutdown, nil
+	default:
+		return noop, fmt.Errorf("unknown trace exporter %q, expected none, otlp or stdout", exporter)
+	}
+}
+
+// Install makes a tracer provider exporting through the given span processor
+// option the global one.
+func Install(processor sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	provider := sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithIDGenerator(idGenerator{}),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("pilot"))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer("pilot")
+}
+
+// NewTraceParent returns the traceparent of the root span of a new map run.
+func NewTraceParent() string {
+	traceID, spanID := randomIDs()
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(trace.ContextWithSpanContext(context.Background(), sc), carrier)
+	return carrier.Get("traceparent")
+}
+
+// RunContext returns a context whose parent span is the root span of the run
+// identified by traceParent.
+func RunContext(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
+
+// EndRun exports the root span of a finished map run, from its start to its
+// end date.
+func EndRun(run models.MapRun, mapName string) {
+	parent := trace.SpanContextFromContext(RunContext(context.Background(), run.TraceParent))
+	if !parent.IsValid() {
+		return
+	}
+	// The span is started without a parent so the ID generator hands out
+	// the IDs chosen when the run was created
+	ctx := context.WithValue(context.Background(), fixedIDsKey{}, parent)
+	_, span := tracer().Start(ctx, "map run "+mapName,
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithTimestamp(run.StartDate),
+		trace.WithAttributes(
+			attribute.Int("pilot.map.id", run.MapID),
+			attribute.String("pilot.map.name", mapName),
+			attribute.Int("pilot.run.id", run.ID),
+			attribute.String("pilot.run.type", run.RunType),
+			attribute.String("pilot.run.logical_date", run.LogicalDate.Format(time.RFC3339)),
+			attribute.String("pilot.run.state", run.State),
+		))
+	if run.State != "success" {
+		span.SetStatus(codes.Error, "map run "+run.State)
+	}
+	end := run.EndDate
+	if end.IsZero() {
+		end = time.Now()
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// QueueWait records the time a step instance waited in the queue, from when
+// it was queued until a worker picked it up.
+func QueueWait(ctx context.Context, step models.Step, queuedAt time.Time, started time.Time) {
+	if queuedAt.IsZero() {
+		return
+	}
+	_, span := tracer().Start(ctx, "queued "+step.Name,
+		trace.WithTimestamp(queuedAt),
+		trace.WithAttributes(stepAttributes(step)...))
+	span.End(trace.WithTimestamp(started))
+}
+
+// StartAttempt starts the span of an attempt of a step.
+func StartAttempt(ctx context.Context, step models.Step) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "step "+step.Name,
+		trace.WithAttributes(append(stepAttributes(step), attribute.Int("pilot.step.attempt", step.Attempt))...))
+}
+
+// EndAttempt ends the span of an attempt, recording its error if it failed.
+func EndAttempt(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Env returns the TRACEPARENT and TRACESTATE variables passing the span of
+// ctx on to a subprocess.
+func Env(ctx context.Context) []string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	var env []string
+	if v := carrier.Get("traceparent"); v != "" {
+		env = append(env, "TRACEPARENT="+v)
+	}
+	if v := carrier.Get("tracestate"); v != "" {
+		env = append(env, "TRACESTATE="+v)
+	}
+	return env
+}
+
+func stepAttributes(step models.Step) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("pilot.map.id", step.MapID),
+		attribute.Int("pilot.run.id", step.RunID),
+		attribute.Int("pilot.step.id", step.ID),
+		attribute.String("pilot.step.name", step.Name),
+	}
+}
+
+type fixedIDsKey struct{}
+
+// idGenerator generates random IDs, except for the root spans of map runs
+// whose IDs were chosen up front.
+type idGenerator struct{}
+
+func (idGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if sc, ok := ctx.Value(fixedIDsKey{}).(trace.SpanContext); ok {
+		return sc.TraceID(), sc.SpanID()
+	}
+	return randomIDs()
+}
+
+func (idGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	_, spanID := randomIDs()
+	return spanID
+}
+
+var (
+	randMu sync.Mutex
+	random = newRandom()
+)
+
+func newRandom() *rand.Rand {
+	var seed int64
+	_ = binary.Read(crand.Reader, binary.LittleEndian, &seed)
+	return rand.New(rand.NewSource(seed))
+}
+
+func randomIDs() (trace.TraceID, trace.SpanID) {
+	randMu.Lock()
+	defer randMu.Unlock()
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	for !traceID.IsValid() {
+		_, _ = random.Read(traceID[:])
+	}
+	for !spanID.IsValid() {
+		_, _ = random.Read(spanID[:])
+	}
+	return traceID, spanID
+}
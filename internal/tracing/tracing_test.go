@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"pilot/pkg/models"
+)
+
+func TestRunTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	Install(sdktrace.WithSpanProcessor(recorder))
+
+	traceParent := NewTraceParent()
+	root := trace.SpanContextFromContext(RunContext(context.Background(), traceParent))
+	if !root.IsValid() {
+		t.Fatalf("invalid traceparent %q", traceParent)
+	}
+
+	step := models.Step{ID: 2, Name: "load", MapID: 1, RunID: 7, Attempt: 1}
+	ctx := RunContext(context.Background(), traceParent)
+	started := time.Now()
+	QueueWait(ctx, step, started.Add(-3*time.Second), started)
+	attemptCtx, span := StartAttempt(ctx, step)
+	env := Env(attemptCtx)
+	EndAttempt(span, errors.New("exit status 1"))
+
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	run := models.MapRun{ID: 7, MapID: 1, RunType: "manual", State: "failed", StartDate: start, EndDate: start.Add(time.Minute), TraceParent: traceParent}
+	EndRun(run, "sales")
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	queued, attempt, mapRun := spans[0], spans[1], spans[2]
+
+	if queued.Name() != "queued load" || queued.EndTime().Sub(queued.StartTime()) != 3*time.Second {
+		t.Errorf("queue span = %s lasting %v", queued.Name(), queued.EndTime().Sub(queued.StartTime()))
+	}
+	for _, s := range []sdktrace.ReadOnlySpan{queued, attempt} {
+		if s.Parent().SpanID() != root.SpanID() || s.SpanContext().TraceID() != root.TraceID() {
+			t.Errorf("%s is not a child of the run span", s.Name())
+		}
+	}
+	if attempt.Status().Code != codes.Error {
+		t.Errorf("attempt status = %v, want error", attempt.Status())
+	}
+	want := "TRACEPARENT=00-" + root.TraceID().String() + "-" + attempt.SpanContext().SpanID().String() + "-01"
+	if len(env) != 1 || env[0] != want {
+		t.Errorf("env = %v, want %s", env, want)
+	}
+
+	if mapRun.SpanContext().SpanID() != root.SpanID() || mapRun.SpanContext().TraceID() != root.TraceID() {
+		t.Errorf("run span has IDs %s/%s, want the ones of %s", mapRun.SpanContext().TraceID(), mapRun.SpanContext().SpanID(), traceParent)
+	}
+	if mapRun.Parent().IsValid() || mapRun.Name() != "map run sales" {
+		t.Errorf("run span = %s with parent %v", mapRun.Name(), mapRun.Parent())
+	}
+	if !mapRun.StartTime().Equal(run.StartDate) || !mapRun.EndTime().Equal(run.EndDate) {
+		t.Errorf("run span lasts from %v to %v", mapRun.StartTime(), mapRun.EndTime())
+	}
+}
+
+func TestSetup(t *testing.T) {
+	var buf bytes.Buffer
+	shutdown, err := Setup(context.Background(), ExporterStdout, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := StartAttempt(RunContext(context.Background(), NewTraceParent()), models.Step{Name: "extract"})
+	EndAttempt(span, nil)
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"Name":"step extract"`) {
+		t.Errorf("stdout exporter wrote %q", buf.String())
+	}
+
+	if _, err := Setup(context.Background(), "jaeger", &buf); err == nil {
+		t.Error("expected an error for an unknown exporter")
+	}
+}
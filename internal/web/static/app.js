@@ -0,0 +1,253 @@
+// pilot web UI. Renders the maps list and a page per map with the grid of
+// its recent runs, its graph and the logs of a selected step, all read from
+// the HTTP API.
+"use strict";
+
+// Colours of step states, matching the diagrams rendered by pilot graph.
+const stateColors = {
+  pending: "#e0e0e0",
+  queued: "#d0c4f7",
+  running: "#9fd3f7",
+  up_for_reschedule: "#b8e0f0",
+  completed: "#a6e3a1",
+  failed: "#f4a3a3",
+  skipped: "#f7d794",
+  upstream_failed: "#f7b267",
+  cancelled: "#bdbdbd",
+};
+
+const content = document.getElementById("content");
+const refreshInterval = 10000;
+let refreshTimer;
+
+async function api(path) {
+  const response = await fetch(path, { headers: { Accept: "application/json" } });
+  const body = await response.json();
+  if (!response.ok) {
+    throw new Error(body.error || response.statusText);
+  }
+  return body;
+}
+
+// el creates an element with attributes and children.
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs || {})) {
+    if (key.startsWith("on")) {
+      node.addEventListener(key.slice(2), value);
+    } else {
+      node.setAttribute(key, value);
+    }
+  }
+  for (const child of children) {
+    if (child !== null && child !== undefined) {
+      node.append(child);
+    }
+  }
+  return node;
+}
+
+function svg(tag, attrs, ...children) {
+  const node = document.createElementNS("http://www.w3.org/2000/svg", tag);
+  for (const [key, value] of Object.entries(attrs || {})) {
+    node.setAttribute(key, value);
+  }
+  node.append(...children);
+  return node;
+}
+
+function formatTime(value) {
+  if (!value || value.startsWith("0001-")) {
+    return "-";
+  }
+  return new Date(value).toLocaleString();
+}
+
+function table(headers, rows) {
+  return el("table", {},
+    el("thead", {}, el("tr", {}, ...headers.map((h) => el("th", {}, h)))),
+    el("tbody", {}, ...rows));
+}
+
+function legend() {
+  return el("p", { class: "legend" }, ...Object.entries(stateColors).map(([state, color]) =>
+    el("span", {}, el("i", { style: `background: ${color}` }), state)));
+}
+
+// scheduleText describes when a map runs: its cron schedule, the files it
+// watches and the datasets it consumes.
+function scheduleText(m) {
+  const parts = [];
+  if (m.schedule_interval) parts.push(m.schedule_interval);
+  if (m.trigger && m.trigger.files) parts.push(`files ${m.trigger.files}`);
+  if (m.trigger && m.trigger.datasets) parts.push(`datasets ${m.trigger.datasets.join(", ")}`);
+  return parts.join(", ") || "-";
+}
+
+async function showMaps() {
+  const page = await api("/api/maps?limit=500");
+  const rows = page.items.map((m) => el("tr", {},
+    el("td", {}, el("a", { href: `#/maps/${encodeURIComponent(m.name)}` }, m.name)),
+    el("td", {}, scheduleText(m)),
+    el("td", {}, m.is_active ? "active" : `paused${m.paused_by ? " by " + m.paused_by : ""}`),
+    el("td", {}, formatTime(m.last_run)),
+    el("td", {}, m.next_run ? formatTime(m.next_run) : "-")));
+  return [
+    el("h2", {}, "Maps"),
+    rows.length ? table(["Map", "Schedule", "Status", "Last run", "Next run"], rows)
+      : el("p", { class: "muted" }, "No maps yet."),
+  ];
+}
+
+async function showMap(name, selection) {
+  const ref = encodeURIComponent(name);
+  const [m, grid] = await Promise.all([api(`/api/maps/${ref}`), api(`/api/maps/${ref}/grid?limit=25`)]);
+  const runID = selection.run || (grid.runs.length ? grid.runs[0].id : "");
+  const [graph, run] = await Promise.all([
+    api(`/api/maps/${ref}/graph${runID ? "?run=" + runID : ""}`),
+    runID ? api(`/api/runs/${runID}`) : null,
+  ]);
+
+  const nodes = [
+    el("h2", {}, m.name),
+    el("p", {},
+      `Schedule ${scheduleText(m)} · `,
+      m.is_active ? `next run ${m.next_run ? formatTime(m.next_run) : "-"}`
+        : `paused${m.paused_by ? " by " + m.paused_by : ""}${m.pause_reason ? ": " + m.pause_reason : ""}`),
+    el("h2", {}, "Recent runs"),
+    runGrid(name, grid),
+    legend(),
+    el("h2", {}, runID ? `Graph of run ${runID}` : "Graph"),
+    graphView(graph),
+  ];
+  if (m.estimate) {
+    nodes.push(estimateText(m.estimate));
+  }
+  if (run && run.waiting_on.length) {
+    nodes.push(el("h2", {}, "Waiting on other maps"), waitsTable(run.waiting_on));
+  }
+  if (selection.run && selection.step) {
+    nodes.push(...await showLogs(selection.run, selection.step));
+  }
+  return nodes;
+}
+
+// runGrid renders a row per step and a column per run, coloured by state.
+function runGrid(name, grid) {
+  if (!grid.runs.length) {
+    return el("p", { class: "muted" }, "No runs yet.");
+  }
+  const runs = grid.runs.slice().reverse();
+  const header = el("tr", {}, el("th", {}, "Step"), ...runs.map((run) =>
+    el("th", { title: `${run.run_type} run, ${run.state}` },
+      el("a", { href: `#/maps/${encodeURIComponent(name)}?run=${run.id}` }, String(run.id)))));
+  const rows = grid.steps.map((step) => el("tr", {}, el("td", {}, step.name), ...runs.map((run) => {
+    const state = run.states[step.id];
+    if (!state) {
+      return el("td", { class: "grid" });
+    }
+    const href = `#/maps/${encodeURIComponent(name)}?run=${run.id}&step=${encodeURIComponent(step.name)}`;
+    return el("td", { class: "grid" }, el("a", {
+      class: "cell",
+      href,
+      title: `${step.name} in run ${run.id}: ${state}`,
+      style: `background: ${stateColors[state] || "#fff"}`,
+    }));
+  })));
+  return el("table", {}, el("thead", {}, header), el("tbody", {}, ...rows));
+}
+
+// graphView draws the steps of a map by execution level, left to right.
+function graphView(graph) {
+  const width = 160, height = 36, gapX = 60, gapY = 20;
+  const levels = graph.levels || [graph.steps.map((s) => s.id)];
+  const positions = {};
+  levels.forEach((level, x) => level.forEach((id, y) => {
+    positions[id] = { x: 10 + x * (width + gapX), y: 10 + y * (height + gapY) };
+  }));
+  const rows = Math.max(1, ...levels.map((level) => level.length));
+
+  const root = svg("svg", {
+    width: 20 + levels.length * (width + gapX) - gapX,
+    height: 20 + rows * (height + gapY) - gapY,
+  });
+  root.append(svg("defs", {}, svg("marker", {
+    id: "arrow", viewBox: "0 0 10 10", refX: 10, refY: 5, markerWidth: 6, markerHeight: 6, orient: "auto",
+  }, svg("path", { d: "M 0 0 L 10 5 L 0 10 z", fill: "#888" }))));
+
+  for (const [from, to] of graph.edges) {
+    const a = positions[from], b = positions[to];
+    if (!a || !b) {
+      continue;
+    }
+    const x1 = a.x + width, y1 = a.y + height / 2, x2 = b.x, y2 = b.y + height / 2;
+    const mid = (x1 + x2) / 2;
+    root.append(svg("path", { class: "edge", d: `M ${x1} ${y1} C ${mid} ${y1}, ${mid} ${y2}, ${x2} ${y2}` }));
+  }
+  for (const step of graph.steps) {
+    const p = positions[step.id];
+    if (!p) {
+      continue;
+    }
+    const title = svg("title", {});
+    title.textContent = `${step.command || ""}${step.state ? " (" + step.state + ")" : ""}`;
+    const label = svg("text", { x: p.x + width / 2, y: p.y + height / 2 + 5, "text-anchor": "middle" });
+    label.textContent = step.name;
+    root.append(svg("g", { class: "node" },
+      svg("rect", { x: p.x, y: p.y, width, height, fill: stateColors[step.state] || "#fff" }),
+      label, title));
+  }
+  return root;
+}
+
+// estimateText summarises the critical path and bottleneck of a map, with
+// durations in nanoseconds as sent by the API.
+function estimateText(e) {
+  const minutes = (ns) => `${Math.round(ns / 6e10)}m`;
+  const path = e.critical_path.map((step) => step.name).join(" → ");
+  return el("p", { class: "muted" },
+    `Critical path ${path} (${minutes(e.critical_duration)} of ${minutes(e.serial_duration)} serial)`,
+    e.bottleneck ? ` · bottleneck ${e.bottleneck.name} (${minutes(e.bottleneck_duration)})` : "");
+}
+
+// waitsTable lists the steps and runs of other maps that pending steps wait
+// for.
+function waitsTable(waits) {
+  return table(["Step", "Map", "Waits for", "Logical date", "State"], waits.map((w) => el("tr", {},
+    el("td", {}, w.step_name),
+    el("td", {}, el("a", { href: `#/maps/${encodeURIComponent(w.map)}${w.run_id ? "?run=" + w.run_id : ""}` }, w.map)),
+    el("td", {}, w.step || "(run)"),
+    el("td", {}, formatTime(w.logical_date)),
+    el("td", {}, w.state))));
+}
+
+async function showLogs(runID, step) {
+  const logs = await api(`/api/runs/${runID}/steps/${encodeURIComponent(step)}/logs`);
+  return [
+    el("h2", {}, `Logs of ${step} in run ${runID}`),
+    logs.length ? el("pre", { class: "logs" }, logs.map((l) =>
+      `--- attempt ${l.attempt}, ${formatTime(l.created_at)} ---\n${l.output}`).join("\n"))
+      : el("p", { class: "muted" }, "No output recorded."),
+  ];
+}
+
+// route renders the page selected by the location hash.
+async function route() {
+  clearTimeout(refreshTimer);
+  const [path, query] = location.hash.replace(/^#/, "").split("?");
+  const params = new URLSearchParams(query || "");
+  const match = path.match(/^\/maps\/([^/]+)$/);
+  try {
+    const nodes = match
+      ? await showMap(decodeURIComponent(match[1]), { run: params.get("run"), step: params.get("step") })
+      : await showMaps();
+    content.replaceChildren(...nodes);
+    document.getElementById("updated").textContent = `updated ${new Date().toLocaleTimeString()}`;
+  } catch (err) {
+    content.replaceChildren(el("p", { class: "error" }, `Error: ${err.message}`));
+  }
+  refreshTimer = setTimeout(route, refreshInterval);
+}
+
+window.addEventListener("hashchange", route);
+route();
@@ -0,0 +1,22 @@
+// Package web serves the pilot web UI, a single page embedded in the binary
+// that reads its data from the HTTP API.
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the web UI.
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		// The static directory is embedded at build time
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
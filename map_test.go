@@ -2,7 +2,7 @@ package main // or the name of the package where your topological sort is
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"pilot/internal/database"
 	"pilot/pkg/models" // import your models package
@@ -86,7 +86,7 @@ func TestMapExecutionFlow(t *testing.T) {
 		}
 	}
 
-	logger := log.New(os.Stdout, "test-logger: ", log.LstdFlags)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	worker := worker.Worker{
 		TaskQueue:      scheduler.TaskQueue,
 		DatabaseClient: db,
@@ -0,0 +1,203 @@
+package graph
+
+import (
+	"time"
+
+	"pilot/pkg/models"
+)
+
+// Roots returns the steps that depend on no other step of the graph.
+func (g *Graph) Roots() []models.Step {
+	var roots []models.Step
+	for _, step := range g.steps {
+		if len(g.upstreamIDs(step)) == 0 {
+			roots = append(roots, step)
+		}
+	}
+	return roots
+}
+
+// Leaves returns the steps no other step of the graph depends on.
+func (g *Graph) Leaves() []models.Step {
+	dependents := g.dependents()
+	var leaves []models.Step
+	for _, step := range g.steps {
+		if len(dependents[step.ID]) == 0 {
+			leaves = append(leaves, step)
+		}
+	}
+	return leaves
+}
+
+// Levels groups the steps into execution levels: level 0 holds the roots and
+// every other step sits one level below its deepest dependency, so the steps
+// of a level can run in parallel once the previous levels are done.
+func (g *Graph) Levels() ([][]models.Step, error) {
+	sorted, err := g.Sort()
+	if err != nil {
+		return nil, err
+	}
+
+	level := map[int]int{}
+	var levels [][]models.Step
+	for _, step := range sorted {
+		l := 0
+		for _, depID := range g.upstreamIDs(step) {
+			if level[depID]+1 > l {
+				l = level[depID] + 1
+			}
+		}
+		level[step.ID] = l
+		if l == len(levels) {
+			levels = append(levels, nil)
+		}
+		levels[l] = append(levels[l], step)
+	}
+	return levels, nil
+}
+
+// Upstream returns every step the given step depends on, directly or
+// transitively, in dependency order.
+func (g *Graph) Upstream(id int) []models.Step {
+	return g.closure(id, func(step models.Step) []int { return g.upstreamIDs(step) })
+}
+
+// Downstream returns every step that depends on the given step, directly or
+// transitively, in dependency order.
+func (g *Graph) Downstream(id int) []models.Step {
+	dependents := g.dependents()
+	return g.closure(id, func(step models.Step) []int { return dependents[step.ID] })
+}
+
+func (g *Graph) closure(id int, next func(models.Step) []int) []models.Step {
+	start, ok := g.Step(id)
+	if !ok {
+		return nil
+	}
+	seen := map[int]bool{}
+	queue := next(start)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if seen[current] || current == id {
+			continue
+		}
+		seen[current] = true
+		if step, ok := g.Step(current); ok {
+			queue = append(queue, next(step)...)
+		}
+	}
+
+	sorted, err := g.Sort()
+	if err != nil {
+		sorted = g.steps
+	}
+	var result []models.Step
+	for _, step := range sorted {
+		if seen[step.ID] {
+			result = append(result, step)
+		}
+	}
+	return result
+}
+
+// CriticalPath returns the chain of dependent steps with the longest total
+// duration, which bounds how fast the map can finish however many workers
+// are available. Steps missing from durations count as zero.
+func (g *Graph) CriticalPath(durations map[int]time.Duration) ([]models.Step, time.Duration, error) {
+	sorted, err := g.Sort()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	finish := map[int]time.Duration{}
+	previous := map[int]int{}
+	var last int
+	var longest time.Duration = -1
+	for _, step := range sorted {
+		var start time.Duration
+		for _, depID := range g.upstreamIDs(step) {
+			if _, ok := previous[step.ID]; !ok || finish[depID] > start {
+				start = finish[depID]
+				previous[step.ID] = depID
+			}
+		}
+		finish[step.ID] = start + durations[step.ID]
+		if finish[step.ID] > longest {
+			longest = finish[step.ID]
+			last = step.ID
+		}
+	}
+	if longest < 0 {
+		return nil, 0, nil
+	}
+
+	var path []models.Step
+	for id, ok := last, true; ok; id, ok = previous[id] {
+		step, _ := g.Step(id)
+		path = append([]models.Step{step}, path...)
+	}
+	return path, longest, nil
+}
+
+// Estimate summarises how much of a map can run in parallel.
+type Estimate struct {
+	Levels             int           `json:"levels"`
+	MaxParallelism     int           `json:"max_parallelism"`   // widest level
+	SerialDuration     time.Duration `json:"serial_duration"`   // all steps one after another
+	CriticalDuration   time.Duration `json:"critical_duration"` // lower bound with unlimited workers
+	CriticalPath       []models.Step `json:"critical_path"`
+	Bottleneck         *models.Step  `json:"bottleneck,omitempty"` // slowest step on the critical path
+	BottleneckDuration time.Duration `json:"bottleneck_duration"`
+}
+
+// Estimate computes parallelism figures from historical step durations.
+func (g *Graph) Estimate(durations map[int]time.Duration) (*Estimate, error) {
+	levels, err := g.Levels()
+	if err != nil {
+		return nil, err
+	}
+	path, critical, err := g.CriticalPath(durations)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Estimate{Levels: len(levels), CriticalDuration: critical, CriticalPath: path}
+	for _, level := range levels {
+		if len(level) > e.MaxParallelism {
+			e.MaxParallelism = len(level)
+		}
+	}
+	for _, step := range g.steps {
+		e.SerialDuration += durations[step.ID]
+	}
+	for i, step := range path {
+		if e.Bottleneck == nil || durations[step.ID] > durations[e.Bottleneck.ID] {
+			e.Bottleneck = &path[i]
+			e.BottleneckDuration = durations[step.ID]
+		}
+	}
+	return e, nil
+}
+
+// upstreamIDs returns the dependencies of step that are part of the graph.
+func (g *Graph) upstreamIDs(step models.Step) []int {
+	var ids []int
+	for _, depID := range step.Dependencies {
+		if _, ok := g.index[depID]; ok && depID != step.ID {
+			ids = append(ids, depID)
+		}
+	}
+	return ids
+}
+
+// dependents maps each step ID to the IDs of the steps depending on it.
+func (g *Graph) dependents() map[int][]int {
+	dependents := map[int][]int{}
+	for _, step := range g.steps {
+		for _, depID := range g.upstreamIDs(step) {
+			dependents[depID] = append(dependents[depID], step.ID)
+		}
+	}
+	return dependents
+}
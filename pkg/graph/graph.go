@@ -0,0 +1,205 @@
+// Package graph implements the dependency graph of the steps in a map.
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"pilot/pkg/models"
+)
+
+// Kinds of problems reported by Validate.
+const (
+	SelfLoop           = "self-loop"
+	UnknownDependency  = "unknown dependency"
+	CrossMapDependency = "cross-map dependency"
+	DuplicateName      = "duplicate name"
+	Cycle              = "cycle"
+)
+
+// Lookup finds a step outside the graph by ID. It is used to tell references
+// to steps of other maps apart from references to steps that do not exist.
+type Lookup func(id int) (*models.Step, error)
+
+// Problem is a single defect in a graph.
+type Problem struct {
+	Kind         string
+	Step         models.Step
+	DependencyID int      // for unknown and cross-map dependencies
+	MapID        int      // map of a cross-map dependency
+	Path         []string // step names along a cycle, first and last are equal
+}
+
+func (p Problem) String() string {
+	switch p.Kind {
+	case SelfLoop:
+		return fmt.Sprintf("step %q depends on itself", p.Step.Name)
+	case UnknownDependency:
+		return fmt.Sprintf("step %q depends on unknown step %d", p.Step.Name, p.DependencyID)
+	case CrossMapDependency:
+		return fmt.Sprintf("step %q depends on step %d of map %d", p.Step.Name, p.DependencyID, p.MapID)
+	case DuplicateName:
+		return fmt.Sprintf("duplicate step name %q", p.Step.Name)
+	case Cycle:
+		return "cycle detected: " + strings.Join(p.Path, " -> ")
+	}
+	return p.Kind
+}
+
+// ValidationError lists every problem found in a graph.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		messages[i] = p.String()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Graph indexes the steps of a map by ID.
+type Graph struct {
+	steps []models.Step
+	index map[int]int // position in steps by step ID
+}
+
+// New builds a graph from the steps of a map.
+func New(steps []models.Step) *Graph {
+	g := &Graph{steps: steps, index: make(map[int]int, len(steps))}
+	for i, step := range steps {
+		if _, dup := g.index[step.ID]; !dup {
+			g.index[step.ID] = i
+		}
+	}
+	return g
+}
+
+// Step returns the step with the given ID.
+func (g *Graph) Step(id int) (models.Step, bool) {
+	i, ok := g.index[id]
+	if !ok {
+		return models.Step{}, false
+	}
+	return g.steps[i], true
+}
+
+// Validate checks the graph for self-loops, dependencies on steps that are
+// not part of it, duplicate step names and cycles. lookup may be nil, in
+// which case every dependency outside the graph is reported as unknown.
+func (g *Graph) Validate(lookup Lookup) error {
+	var problems []Problem
+
+	names := map[string]bool{}
+	for _, step := range g.steps {
+		if names[step.Name] {
+			problems = append(problems, Problem{Kind: DuplicateName, Step: step})
+		}
+		names[step.Name] = true
+
+		for _, depID := range step.Dependencies {
+			if depID == step.ID {
+				problems = append(problems, Problem{Kind: SelfLoop, Step: step, Path: []string{step.Name, step.Name}})
+				continue
+			}
+			if _, ok := g.index[depID]; ok {
+				continue
+			}
+			problem := Problem{Kind: UnknownDependency, Step: step, DependencyID: depID}
+			if lookup != nil {
+				if dep, err := lookup(depID); err == nil && dep != nil {
+					problem.Kind = CrossMapDependency
+					problem.MapID = dep.MapID
+				}
+			}
+			problems = append(problems, problem)
+		}
+	}
+
+	if _, err := g.Sort(); err != nil {
+		if cycle, ok := err.(*ValidationError); ok {
+			problems = append(problems, cycle.Problems...)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Sort returns the steps ordered so that every step comes after its
+// dependencies, keeping the input order otherwise. Dependencies outside the
+// graph and self-loops are ignored; a cycle is reported as a
+// ValidationError holding the path of the cycle.
+func (g *Graph) Sort() ([]models.Step, error) {
+	var result []models.Step
+	visited := make(map[int]bool)
+	var path []int
+	onPath := make(map[int]bool)
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		step := g.steps[i]
+		if onPath[step.ID] {
+			return &ValidationError{Problems: []Problem{{Kind: Cycle, Step: step, Path: g.cyclePath(path, step.ID)}}}
+		}
+		if visited[step.ID] {
+			return nil
+		}
+		onPath[step.ID] = true
+		path = append(path, step.ID)
+		for _, depID := range step.Dependencies {
+			j, ok := g.index[depID]
+			if !ok || depID == step.ID {
+				continue
+			}
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		onPath[step.ID] = false
+		visited[step.ID] = true
+		result = append(result, step)
+		return nil
+	}
+
+	for i := range g.steps {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// cyclePath returns the names of the steps from the first visit of id on the
+// current path back to id. The path is walked from dependent to dependency,
+// so it is reversed to read in execution order.
+func (g *Graph) cyclePath(path []int, id int) []string {
+	start := 0
+	for i, stepID := range path {
+		if stepID == id {
+			start = i
+			break
+		}
+	}
+	cycle := append(append([]int{}, path[start:]...), id)
+	names := make([]string, len(cycle))
+	for i, stepID := range cycle {
+		step, _ := g.Step(stepID)
+		names[len(cycle)-1-i] = step.Name
+	}
+	return names
+}
+
+// Sort orders steps topologically, see Graph.Sort.
+func Sort(steps []models.Step) ([]models.Step, error) {
+	return New(steps).Sort()
+}
+
+// Validate checks steps for structural problems, see Graph.Validate.
+func Validate(steps []models.Step, lookup Lookup) error {
+	return New(steps).Validate(lookup)
+}
@@ -0,0 +1,202 @@
+package graph
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+func stepIDs(steps []models.Step) []int {
+	ids := make([]int, len(steps))
+	for i, step := range steps {
+		ids[i] = step.ID
+	}
+	return ids
+}
+
+func TestSort(t *testing.T) {
+	steps := []models.Step{
+		{ID: 1, Name: "load", Dependencies: []int{3}},
+		{ID: 2, Name: "extract"},
+		{ID: 3, Name: "transform", Dependencies: []int{2}},
+		{ID: 4, Name: "report"},
+	}
+
+	sorted, err := Sort(steps)
+	if err != nil {
+		t.Fatalf("Sort failed: %v", err)
+	}
+	if got, want := stepIDs(sorted), []int{2, 3, 1, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sort = %v, want %v", got, want)
+	}
+}
+
+func TestSortReportsCyclePath(t *testing.T) {
+	steps := []models.Step{
+		{ID: 1, Name: "a", Dependencies: []int{2}},
+		{ID: 2, Name: "b", Dependencies: []int{3}},
+		{ID: 3, Name: "c", Dependencies: []int{1}},
+		{ID: 4, Name: "d", Dependencies: []int{1}},
+	}
+
+	_, err := Sort(steps)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Problems) != 1 {
+		t.Fatalf("Sort error = %v, want a single cycle", err)
+	}
+	if got, want := verr.Problems[0].Path, []string{"a", "c", "b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cycle path = %v, want %v", got, want)
+	}
+	if got := err.Error(); got != "cycle detected: a -> c -> b -> a" {
+		t.Errorf("error = %q", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	steps := []models.Step{
+		{ID: 1, Name: "extract", MapID: 1, Dependencies: []int{1}},
+		{ID: 2, Name: "load", MapID: 1, Dependencies: []int{1, 40, 50}},
+		{ID: 3, Name: "load", MapID: 1},
+	}
+	lookup := func(id int) (*models.Step, error) {
+		if id == 40 {
+			return &models.Step{ID: 40, MapID: 2}, nil
+		}
+		return nil, errors.New("not found")
+	}
+
+	err := Validate(steps, lookup)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate error = %v, want ValidationError", err)
+	}
+	var kinds []string
+	for _, p := range verr.Problems {
+		kinds = append(kinds, p.Kind)
+	}
+	want := []string{SelfLoop, CrossMapDependency, UnknownDependency, DuplicateName}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("problems = %v, want %v", kinds, want)
+	}
+	for _, msg := range []string{`step "extract" depends on itself`, "step 40 of map 2", "unknown step 50", `duplicate step name "load"`} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("error %q does not mention %q", err, msg)
+		}
+	}
+
+	if err := Validate(steps[1:2], nil); err == nil {
+		t.Error("Validate without lookup accepted unknown dependencies")
+	}
+}
+
+// diamond is extract -> (clean, enrich) -> load, plus an unrelated audit step.
+var diamond = []models.Step{
+	{ID: 1, Name: "extract"},
+	{ID: 2, Name: "clean", Dependencies: []int{1}},
+	{ID: 3, Name: "enrich", Dependencies: []int{1}},
+	{ID: 4, Name: "load", Dependencies: []int{2, 3}},
+	{ID: 5, Name: "audit"},
+}
+
+func TestLevelsRootsLeaves(t *testing.T) {
+	g := New(diamond)
+
+	levels, err := g.Levels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]int
+	for _, level := range levels {
+		got = append(got, stepIDs(level))
+	}
+	if want := [][]int{{1, 5}, {2, 3}, {4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Levels = %v, want %v", got, want)
+	}
+	if got, want := stepIDs(g.Roots()), []int{1, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Roots = %v, want %v", got, want)
+	}
+	if got, want := stepIDs(g.Leaves()), []int{4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Leaves = %v, want %v", got, want)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	g := New(diamond)
+
+	if got, want := stepIDs(g.Upstream(4)), []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Upstream(4) = %v, want %v", got, want)
+	}
+	if got, want := stepIDs(g.Downstream(1)), []int{2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Downstream(1) = %v, want %v", got, want)
+	}
+	if got := g.Downstream(5); len(got) != 0 {
+		t.Errorf("Downstream(5) = %v, want none", stepIDs(got))
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	durations := map[int]time.Duration{
+		1: 2 * time.Minute,
+		2: time.Minute,
+		3: 10 * time.Minute,
+		4: 3 * time.Minute,
+		5: 20 * time.Minute,
+	}
+
+	e, err := New(diamond).Estimate(durations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stepIDs(e.CriticalPath), []int{5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CriticalPath = %v, want %v", got, want)
+	}
+
+	durations[5] = time.Minute
+	e, err = New(diamond).Estimate(durations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stepIDs(e.CriticalPath), []int{1, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CriticalPath = %v, want %v", got, want)
+	}
+	if e.CriticalDuration != 15*time.Minute || e.SerialDuration != 17*time.Minute {
+		t.Errorf("CriticalDuration = %v, SerialDuration = %v", e.CriticalDuration, e.SerialDuration)
+	}
+	if e.Levels != 3 || e.MaxParallelism != 2 {
+		t.Errorf("Levels = %d, MaxParallelism = %d", e.Levels, e.MaxParallelism)
+	}
+	if e.Bottleneck == nil || e.Bottleneck.Name != "enrich" || e.BottleneckDuration != 10*time.Minute {
+		t.Errorf("Bottleneck = %v (%v), want enrich", e.Bottleneck, e.BottleneckDuration)
+	}
+}
+
+func TestRender(t *testing.T) {
+	g := New(diamond[:4])
+	states := map[int]string{1: "completed", 2: "failed"}
+
+	dot := g.Dot("sales", states)
+	for _, want := range []string{`digraph "sales" {`, `s1 [label="extract", fillcolor="#a6e3a1", tooltip="completed"];`, `s3 [label="enrich"];`, "s2 -> s4;"} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("Dot output missing %q:\n%s", want, dot)
+		}
+	}
+
+	mermaid := g.Mermaid(states)
+	for _, want := range []string{"flowchart LR", `s4["load"]`, "s1 --> s3", "style s2 fill:#f4a3a3"} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("Mermaid output missing %q:\n%s", want, mermaid)
+		}
+	}
+
+	out, err := g.JSON("sales", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `"levels": [`) || !strings.Contains(string(out), `"edges": [`) {
+		t.Errorf("JSON output missing levels or edges:\n%s", out)
+	}
+}
@@ -0,0 +1,128 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"pilot/pkg/models"
+)
+
+// stateColors are the fill colours used for step states in diagrams.
+var stateColors = map[string]string{
+	"pending":           "#e0e0e0",
+	"queued":            "#d0c4f7",
+	"running":           "#9fd3f7",
+	"up_for_reschedule": "#b8e0f0",
+	"completed":         "#a6e3a1",
+	"failed":            "#f4a3a3",
+	"skipped":           "#f7d794",
+	"upstream_failed":   "#f7b267",
+	"cancelled":         "#bdbdbd",
+}
+
+// Dot renders the graph in Graphviz DOT format. When states is not nil the
+// nodes are filled according to the state of each step ID.
+func (g *Graph) Dot(name string, states map[int]string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", name)
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fillcolor=\"#ffffff\"];\n")
+	for _, step := range g.steps {
+		attrs := fmt.Sprintf("label=%q", step.Name)
+		if state, ok := states[step.ID]; ok {
+			attrs += fmt.Sprintf(", fillcolor=%q, tooltip=%q", stateColor(state), state)
+		}
+		fmt.Fprintf(&b, "  s%d [%s];\n", step.ID, attrs)
+	}
+	for _, step := range g.steps {
+		for _, depID := range g.upstreamIDs(step) {
+			fmt.Fprintf(&b, "  s%d -> s%d;\n", depID, step.ID)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart.
+func (g *Graph) Mermaid(states map[int]string) string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, step := range g.steps {
+		fmt.Fprintf(&b, "  s%d[\"%s\"]\n", step.ID, strings.ReplaceAll(step.Name, `"`, "#quot;"))
+	}
+	for _, step := range g.steps {
+		for _, depID := range g.upstreamIDs(step) {
+			fmt.Fprintf(&b, "  s%d --> s%d\n", depID, step.ID)
+		}
+	}
+	for _, step := range g.steps {
+		if state, ok := states[step.ID]; ok {
+			fmt.Fprintf(&b, "  style s%d fill:%s\n", step.ID, stateColor(state))
+		}
+	}
+	return b.String()
+}
+
+// jsonStep is the JSON representation of a node.
+type jsonStep struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Command      string `json:"command,omitempty"`
+	State        string `json:"state,omitempty"`
+	Dependencies []int  `json:"dependencies"`
+}
+
+type jsonGraph struct {
+	Map    string     `json:"map"`
+	Steps  []jsonStep `json:"steps"`
+	Edges  [][2]int   `json:"edges"`
+	Levels [][]int    `json:"levels,omitempty"`
+}
+
+// JSON renders the nodes, edges and execution levels of the graph.
+func (g *Graph) JSON(name string, states map[int]string) ([]byte, error) {
+	out := jsonGraph{Map: name, Steps: []jsonStep{}, Edges: [][2]int{}}
+	for _, step := range g.steps {
+		deps := g.upstreamIDs(step)
+		if deps == nil {
+			deps = []int{}
+		}
+		out.Steps = append(out.Steps, jsonStep{
+			ID:           step.ID,
+			Name:         step.Name,
+			Command:      step.Command,
+			State:        states[step.ID],
+			Dependencies: deps,
+		})
+		for _, depID := range deps {
+			out.Edges = append(out.Edges, [2]int{depID, step.ID})
+		}
+	}
+	if levels, err := g.Levels(); err == nil {
+		for _, level := range levels {
+			ids := make([]int, len(level))
+			for i, step := range level {
+				ids[i] = step.ID
+			}
+			out.Levels = append(out.Levels, ids)
+		}
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+func stateColor(state string) string {
+	if color, ok := stateColors[state]; ok {
+		return color
+	}
+	return "#ffffff"
+}
+
+// States returns the current state of each step, for colouring diagrams.
+func States(steps []models.Step) map[int]string {
+	states := make(map[int]string, len(steps))
+	for _, step := range steps {
+		states[step.ID] = step.State
+	}
+	return states
+}
@@ -0,0 +1,550 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+
+	"pilot/pkg/graph"
+	"pilot/pkg/models"
+	"pilot/pkg/notify"
+)
+
+// MapDefinition is the file representation of a map.
+type MapDefinition struct {
+	Name      string               `json:"name" yaml:"name"`
+	Schedule  string               `json:"schedule,omitempty" yaml:"schedule"` // Optional for maps with a trigger
+	StartDate string               `json:"start_date" yaml:"start_date"`
+	Paused    bool                 `json:"paused" yaml:"paused"`
+	SLA       string               `json:"sla,omitempty" yaml:"sla"`
+	SLAFrom   string               `json:"sla_from,omitempty" yaml:"sla_from"`
+	Notify    []NotifierDefinition `json:"notify,omitempty" yaml:"notify"`
+	Trigger   *TriggerDefinition   `json:"trigger,omitempty" yaml:"trigger"`
+	Steps     []StepDefinition     `json:"steps" yaml:"steps"`
+	Hooks     `yaml:",inline"`     // Run after the outcomes of every step
+}
+
+// NotifierDefinition is the file representation of a notifier: where to send
+// which events of the map.
+type NotifierDefinition struct {
+	Type   string   `json:"type" yaml:"type"`
+	URL    string   `json:"url,omitempty" yaml:"url"`
+	To     []string `json:"to,omitempty" yaml:"to"`
+	Events []string `json:"events,omitempty" yaml:"events"`
+}
+
+// TriggerDefinition is the file representation of a trigger: the events
+// that start runs of a map besides its schedule, new files or updates of the
+// datasets the map consumes.
+type TriggerDefinition struct {
+	Files    string   `json:"files,omitempty" yaml:"files"`
+	Archive  string   `json:"archive,omitempty" yaml:"archive"`
+	Settle   string   `json:"settle,omitempty" yaml:"settle"`
+	Datasets []string `json:"datasets,omitempty" yaml:"datasets"`
+}
+
+// StepDefinition is the file representation of a step. Dependencies refer to
+// other steps of the same map by name. A step either runs a command or waits
+// for the condition of its sensor.
+type StepDefinition struct {
+	Name        string            `json:"name" yaml:"name"`
+	Command     string            `json:"command,omitempty" yaml:"command"`
+	Sensor      *SensorDefinition `json:"sensor,omitempty" yaml:"sensor"`
+	DependsOn   []string          `json:"depends_on" yaml:"depends_on"`
+	Connections []string          `json:"connections" yaml:"connections"`
+	Retries     int               `json:"retries" yaml:"retries"`
+	RetryDelay  string            `json:"retry_delay" yaml:"retry_delay"`
+	SLA         string            `json:"sla,omitempty" yaml:"sla"`
+	Produces    []string          `json:"produces,omitempty" yaml:"produces"`
+	WaitFor     []WaitDefinition  `json:"wait_for,omitempty" yaml:"wait_for"`
+	Hooks       `yaml:",inline"`
+}
+
+// WaitDefinition is the file representation of an external dependency: a
+// step, or a whole run when step is empty, of another map for the logical
+// date of the run offset by a duration.
+type WaitDefinition struct {
+	Map     string `json:"map" yaml:"map"`
+	Step    string `json:"step,omitempty" yaml:"step"`
+	Offset  string `json:"offset,omitempty" yaml:"offset"`
+	Timeout string `json:"timeout,omitempty" yaml:"timeout"`
+}
+
+// SensorDefinition is the file representation of a sensor: the condition a
+// step waits for and how it is checked.
+type SensorDefinition struct {
+	Type         string `json:"type" yaml:"type"`
+	Path         string `json:"path,omitempty" yaml:"path"`
+	Connection   string `json:"connection,omitempty" yaml:"connection"`
+	Query        string `json:"query,omitempty" yaml:"query"`
+	URL          string `json:"url,omitempty" yaml:"url"`
+	Status       int    `json:"status,omitempty" yaml:"status"`
+	PokeInterval string `json:"poke_interval,omitempty" yaml:"poke_interval"`
+	Timeout      string `json:"timeout,omitempty" yaml:"timeout"`
+	SoftFail     bool   `json:"soft_fail,omitempty" yaml:"soft_fail"`
+	Mode         string `json:"mode,omitempty" yaml:"mode"`
+}
+
+// Hooks are the callbacks run after the outcomes of a step, or of every step
+// of a map.
+type Hooks struct {
+	OnSuccess []HookDefinition `json:"on_success,omitempty" yaml:"on_success"`
+	OnFailure []HookDefinition `json:"on_failure,omitempty" yaml:"on_failure"`
+	OnRetry   []HookDefinition `json:"on_retry,omitempty" yaml:"on_retry"`
+}
+
+// HookDefinition is the file representation of a hook: a script, or the name
+// of a Go function registered with the workers.
+type HookDefinition struct {
+	Command string `json:"command,omitempty" yaml:"command"`
+	Func    string `json:"func,omitempty" yaml:"func"`
+	Timeout string `json:"timeout,omitempty" yaml:"timeout"`
+}
+
+// dateLayouts are the accepted formats for start_date.
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// ParseFile reads a map definition from a .yaml, .yml or .json file.
+func ParseFile(path string) (*MapDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var def MapDefinition
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&def)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&def)
+	default:
+		return nil, fmt.Errorf("unsupported map file extension %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filepath.Base(path), err)
+	}
+	return &def, nil
+}
+
+// Validate checks the definition and returns every problem found.
+func (d *MapDefinition) Validate() error {
+	var errs []error
+	if d.Name == "" {
+		errs = append(errs, errors.New("map name is required"))
+	}
+	switch {
+	case d.Schedule == "" && d.Trigger != nil:
+	case d.Schedule == "":
+		errs = append(errs, errors.New("map needs a schedule or a trigger"))
+	default:
+		if _, err := cron.ParseStandard(d.Schedule); err != nil {
+			errs = append(errs, fmt.Errorf("invalid schedule %q: %w", d.Schedule, err))
+		}
+	}
+	if d.StartDate != "" {
+		if _, err := ParseDate(d.StartDate); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(d.Steps) == 0 {
+		errs = append(errs, errors.New("map has no steps"))
+	}
+	if err := validateSLA(d.SLA); err != nil {
+		errs = append(errs, fmt.Errorf("invalid sla: %w", err))
+	}
+	switch d.SLAFrom {
+	case "", models.SLAFromLogicalDate, models.SLAFromSchedule:
+	default:
+		errs = append(errs, fmt.Errorf("invalid sla_from %q, expected logical_date or schedule", d.SLAFrom))
+	}
+
+	for i, n := range d.Notify {
+		switch n.Type {
+		case models.NotifierWebhook, models.NotifierSlack:
+			if n.URL == "" {
+				errs = append(errs, fmt.Errorf("notifier %d (%s) has no url", i+1, n.Type))
+			} else if err := notify.CheckURL(n.URL); err != nil {
+				errs = append(errs, fmt.Errorf("notifier %d (%s): %w", i+1, n.Type, err))
+			}
+		case models.NotifierEmail:
+			if len(n.To) == 0 {
+				errs = append(errs, fmt.Errorf("notifier %d (email) has no recipients", i+1))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("notifier %d has unknown type %q, expected webhook, slack or email", i+1, n.Type))
+		}
+		for _, event := range n.Events {
+			if !slices.Contains(models.Events, event) {
+				errs = append(errs, fmt.Errorf("notifier %d has unknown event %q", i+1, event))
+			}
+		}
+	}
+
+	if d.Trigger != nil {
+		errs = append(errs, d.Trigger.validate()...)
+	}
+	errs = append(errs, d.Hooks.validate("map")...)
+
+	for i, step := range d.Steps {
+		if step.Name == "" {
+			errs = append(errs, fmt.Errorf("step %d has no name", i+1))
+			continue
+		}
+		switch {
+		case step.Sensor != nil:
+			if step.Command != "" {
+				errs = append(errs, fmt.Errorf("step %q has both a command and a sensor", step.Name))
+			}
+			errs = append(errs, step.Sensor.validate(step.Name)...)
+		case step.Command == "":
+			errs = append(errs, fmt.Errorf("step %q has no command", step.Name))
+		}
+		if step.Retries < 0 {
+			errs = append(errs, fmt.Errorf("step %q has negative retries", step.Name))
+		}
+		if step.RetryDelay != "" {
+			if _, err := time.ParseDuration(step.RetryDelay); err != nil {
+				errs = append(errs, fmt.Errorf("step %q has invalid retry_delay: %w", step.Name, err))
+			}
+		}
+		if err := validateSLA(step.SLA); err != nil {
+			errs = append(errs, fmt.Errorf("step %q has invalid sla: %w", step.Name, err))
+		}
+		errs = append(errs, step.Hooks.validate(fmt.Sprintf("step %q", step.Name))...)
+		for i, wait := range step.WaitFor {
+			switch wait.Map {
+			case "":
+				errs = append(errs, fmt.Errorf("step %q wait_for %d has no map", step.Name, i+1))
+			case d.Name:
+				errs = append(errs, fmt.Errorf("step %q waits for its own map, use depends_on", step.Name))
+			}
+			if _, err := parseDuration(wait.Offset); err != nil {
+				errs = append(errs, fmt.Errorf("step %q wait_for %d has invalid offset %q", step.Name, i+1, wait.Offset))
+			}
+			if timeout, err := parseDuration(wait.Timeout); err != nil || timeout < 0 {
+				errs = append(errs, fmt.Errorf("step %q wait_for %d has invalid timeout %q", step.Name, i+1, wait.Timeout))
+			}
+		}
+		for _, dataset := range step.Produces {
+			switch {
+			case dataset == "":
+				errs = append(errs, fmt.Errorf("step %q produces a dataset with no name", step.Name))
+			case d.Trigger != nil && slices.Contains(d.Trigger.Datasets, dataset):
+				errs = append(errs, fmt.Errorf("step %q produces dataset %q consumed by its own map", step.Name, dataset))
+			}
+		}
+	}
+
+	// Number the steps by position so the graph can be checked before the
+	// steps have database IDs
+	ids := map[string]int{}
+	for i, step := range d.Steps {
+		if _, dup := ids[step.Name]; !dup {
+			ids[step.Name] = i + 1
+		}
+	}
+	steps := make([]models.Step, len(d.Steps))
+	for i, def := range d.Steps {
+		steps[i] = models.Step{ID: i + 1, Name: def.Name}
+		for _, dep := range def.DependsOn {
+			id, ok := ids[dep]
+			if !ok {
+				errs = append(errs, fmt.Errorf("step %q depends on unknown step %q", def.Name, dep))
+				continue
+			}
+			steps[i].Dependencies = append(steps[i].Dependencies, id)
+		}
+	}
+	if err := graph.Validate(steps, nil); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ToMap converts a validated definition into a map and the dependencies of
+// each step by name.
+func (d *MapDefinition) ToMap() (models.Map, map[string][]string) {
+	var startDate time.Time
+	if d.StartDate != "" {
+		startDate, _ = ParseDate(d.StartDate)
+	}
+	m := models.NewMap(d.Name, d.Schedule, startDate, time.Time{}, nil)
+	m.IsActive = !d.Paused
+	m.SLA, _ = parseDuration(d.SLA)
+	m.SLAFrom = d.SLAFrom
+	for _, n := range d.Notify {
+		m.Notifiers = append(m.Notifiers, models.Notifier{Type: n.Type, URL: n.URL, To: n.To, Events: n.Events})
+	}
+	m.Hooks = d.Hooks.toModels()
+	if d.Trigger != nil {
+		m.Trigger = d.Trigger.toModel()
+	}
+
+	dependsOn := map[string][]string{}
+	for _, def := range d.Steps {
+		step := models.NewStep(def.Name, 0)
+		step.Command = def.Command
+		step.Connections = def.Connections
+		step.Retries = def.Retries
+		if def.RetryDelay != "" {
+			step.RetryDelay, _ = time.ParseDuration(def.RetryDelay)
+		}
+		step.SLA, _ = parseDuration(def.SLA)
+		step.Hooks = def.Hooks.toModels()
+		step.Produces = def.Produces
+		for _, wait := range def.WaitFor {
+			offset, _ := parseDuration(wait.Offset)
+			timeout, _ := parseDuration(wait.Timeout)
+			step.WaitFor = append(step.WaitFor, models.ExternalDependency{Map: wait.Map, Step: wait.Step, Offset: offset, Timeout: timeout})
+		}
+		if def.Sensor != nil {
+			step.Sensor = def.Sensor.toModel()
+		}
+		m.Steps = append(m.Steps, *step)
+		dependsOn[def.Name] = def.DependsOn
+	}
+	return *m, dependsOn
+}
+
+// FromMap converts a stored map and its steps back into a definition.
+func FromMap(m models.Map) *MapDefinition {
+	def := &MapDefinition{Name: m.Name, Schedule: m.ScheduleInterval, Paused: !m.IsActive, SLAFrom: m.SLAFrom, Steps: []StepDefinition{}}
+	if !m.StartDate.IsZero() {
+		def.StartDate = m.StartDate.Format(time.RFC3339)
+	}
+	if m.SLA > 0 {
+		def.SLA = m.SLA.String()
+	}
+	for _, n := range m.Notifiers {
+		def.Notify = append(def.Notify, NotifierDefinition{Type: n.Type, URL: n.URL, To: n.To, Events: n.Events})
+	}
+	def.Hooks = hooksFromModels(m.Hooks)
+	if m.Trigger != nil {
+		def.Trigger = triggerFromModel(*m.Trigger)
+	}
+
+	names := map[int]string{}
+	for _, step := range m.Steps {
+		names[step.ID] = step.Name
+	}
+	for _, step := range m.Steps {
+		sd := StepDefinition{Name: step.Name, Command: step.Command, Connections: step.Connections, Retries: step.Retries,
+			Produces: step.Produces, Hooks: hooksFromModels(step.Hooks)}
+		for _, id := range step.Dependencies {
+			sd.DependsOn = append(sd.DependsOn, names[id])
+		}
+		if step.RetryDelay > 0 {
+			sd.RetryDelay = step.RetryDelay.String()
+		}
+		if step.SLA > 0 {
+			sd.SLA = step.SLA.String()
+		}
+		if step.Sensor != nil {
+			sd.Sensor = sensorFromModel(*step.Sensor)
+		}
+		for _, dep := range step.WaitFor {
+			wait := WaitDefinition{Map: dep.Map, Step: dep.Step}
+			if dep.Offset != 0 {
+				wait.Offset = dep.Offset.String()
+			}
+			if dep.Timeout > 0 {
+				wait.Timeout = dep.Timeout.String()
+			}
+			sd.WaitFor = append(sd.WaitFor, wait)
+		}
+		def.Steps = append(def.Steps, sd)
+	}
+	return def
+}
+
+// validate checks the trigger of a map.
+func (t *TriggerDefinition) validate() []error {
+	var errs []error
+	if t.Files == "" && len(t.Datasets) == 0 {
+		errs = append(errs, errors.New("trigger needs a files pattern or datasets"))
+	} else if _, err := filepath.Match(t.Files, ""); err != nil {
+		errs = append(errs, fmt.Errorf("trigger has invalid files pattern %q: %w", t.Files, err))
+	}
+	if d, err := parseDuration(t.Settle); err != nil || d < 0 {
+		errs = append(errs, fmt.Errorf("trigger has invalid settle %q", t.Settle))
+	}
+	for i, dataset := range t.Datasets {
+		switch {
+		case dataset == "":
+			errs = append(errs, fmt.Errorf("trigger dataset %d has no name", i+1))
+		case slices.Contains(t.Datasets[:i], dataset):
+			errs = append(errs, fmt.Errorf("trigger lists dataset %q twice", dataset))
+		}
+	}
+	return errs
+}
+
+// toModel converts a validated trigger definition.
+func (t *TriggerDefinition) toModel() *models.Trigger {
+	settle, _ := parseDuration(t.Settle)
+	return &models.Trigger{Files: t.Files, Archive: t.Archive, Settle: settle, Datasets: t.Datasets}
+}
+
+// triggerFromModel converts a stored trigger back into a definition.
+func triggerFromModel(t models.Trigger) *TriggerDefinition {
+	def := &TriggerDefinition{Files: t.Files, Archive: t.Archive, Datasets: t.Datasets}
+	if t.Settle > 0 {
+		def.Settle = t.Settle.String()
+	}
+	return def
+}
+
+// validate checks the sensor of the named step.
+func (s *SensorDefinition) validate(step string) []error {
+	var errs []error
+	switch s.Type {
+	case models.SensorFile:
+		if s.Path == "" {
+			errs = append(errs, fmt.Errorf("step %q file sensor has no path", step))
+		}
+	case models.SensorSQL:
+		if s.Connection == "" || s.Query == "" {
+			errs = append(errs, fmt.Errorf("step %q sql sensor needs a connection and a query", step))
+		}
+	case models.SensorHTTP:
+		if s.URL == "" {
+			errs = append(errs, fmt.Errorf("step %q http sensor has no url", step))
+		}
+		if s.Status != 0 && (s.Status < 100 || s.Status > 599) {
+			errs = append(errs, fmt.Errorf("step %q http sensor has invalid status %d", step, s.Status))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("step %q sensor has unknown type %q, expected file, sql or http", step, s.Type))
+	}
+	if d, err := parseDuration(s.PokeInterval); err != nil || d < 0 {
+		errs = append(errs, fmt.Errorf("step %q sensor has invalid poke_interval %q", step, s.PokeInterval))
+	}
+	if d, err := parseDuration(s.Timeout); err != nil || d < 0 {
+		errs = append(errs, fmt.Errorf("step %q sensor has invalid timeout %q", step, s.Timeout))
+	}
+	switch s.Mode {
+	case "", models.SensorModePoke, models.SensorModeReschedule:
+	default:
+		errs = append(errs, fmt.Errorf("step %q sensor has invalid mode %q, expected poke or reschedule", step, s.Mode))
+	}
+	return errs
+}
+
+// toModel converts a validated sensor definition.
+func (s *SensorDefinition) toModel() *models.Sensor {
+	interval, _ := parseDuration(s.PokeInterval)
+	timeout, _ := parseDuration(s.Timeout)
+	return &models.Sensor{Type: s.Type, Path: s.Path, Connection: s.Connection, Query: s.Query, URL: s.URL, Status: s.Status,
+		PokeInterval: interval, Timeout: timeout, SoftFail: s.SoftFail, Mode: s.Mode}
+}
+
+// sensorFromModel converts a stored sensor back into a definition.
+func sensorFromModel(s models.Sensor) *SensorDefinition {
+	def := &SensorDefinition{Type: s.Type, Path: s.Path, Connection: s.Connection, Query: s.Query, URL: s.URL, Status: s.Status,
+		SoftFail: s.SoftFail, Mode: s.Mode}
+	if s.PokeInterval > 0 {
+		def.PokeInterval = s.PokeInterval.String()
+	}
+	if s.Timeout > 0 {
+		def.Timeout = s.Timeout.String()
+	}
+	return def
+}
+
+// byEvent pairs the hook lists with the outcome they run on.
+func (h *Hooks) byEvent() map[string]*[]HookDefinition {
+	return map[string]*[]HookDefinition{
+		models.HookOnSuccess: &h.OnSuccess,
+		models.HookOnFailure: &h.OnFailure,
+		models.HookOnRetry:   &h.OnRetry,
+	}
+}
+
+// validate checks the hooks of owner, a map or a step.
+func (h Hooks) validate(owner string) []error {
+	var errs []error
+	lists := h.byEvent()
+	for _, event := range models.HookEvents {
+		for i, hook := range *lists[event] {
+			if (hook.Command == "") == (hook.Func == "") {
+				errs = append(errs, fmt.Errorf("%s %s hook %d needs either a command or a func", owner, event, i+1))
+			}
+			if d, err := parseDuration(hook.Timeout); err != nil || d < 0 {
+				errs = append(errs, fmt.Errorf("%s %s hook %d has invalid timeout %q", owner, event, i+1, hook.Timeout))
+			}
+		}
+	}
+	return errs
+}
+
+// toModels flattens validated hook definitions.
+func (h Hooks) toModels() []models.Hook {
+	var hooks []models.Hook
+	lists := h.byEvent()
+	for _, event := range models.HookEvents {
+		for _, def := range *lists[event] {
+			timeout, _ := parseDuration(def.Timeout)
+			hooks = append(hooks, models.Hook{On: event, Command: def.Command, Func: def.Func, Timeout: timeout})
+		}
+	}
+	return hooks
+}
+
+// hooksFromModels groups stored hooks by outcome.
+func hooksFromModels(hooks []models.Hook) Hooks {
+	var h Hooks
+	lists := h.byEvent()
+	for _, hook := range hooks {
+		list, ok := lists[hook.On]
+		if !ok {
+			continue
+		}
+		def := HookDefinition{Command: hook.Command, Func: hook.Func}
+		if hook.Timeout > 0 {
+			def.Timeout = hook.Timeout.String()
+		}
+		*list = append(*list, def)
+	}
+	return h
+}
+
+// validateSLA checks an optional SLA duration.
+func validateSLA(value string) error {
+	d, err := parseDuration(value)
+	if err == nil && d < 0 {
+		err = errors.New("negative duration")
+	}
+	return err
+}
+
+// parseDuration parses an optional duration, empty meaning 0.
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// ParseDate parses a date given as YYYY-MM-DD or RFC 3339.
+func ParseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or RFC 3339", value)
+}
@@ -0,0 +1,141 @@
+package loader
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pilot/internal/database"
+)
+
+// DefaultDir is the maps folder used when PILOT_MAPS_FOLDER is not set.
+const DefaultDir = "maps"
+
+// Result reports what happened to a single map file.
+type Result struct {
+	File    string
+	Map     string
+	MapID   int
+	Changes []database.MapChange
+	Err     error
+}
+
+// Dir returns the maps folder configured through PILOT_MAPS_FOLDER.
+func Dir() string {
+	if dir := os.Getenv("PILOT_MAPS_FOLDER"); dir != "" {
+		return dir
+	}
+	return DefaultDir
+}
+
+// IsMapFile reports whether path has an extension the loader understands.
+func IsMapFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// LoadDir parses every map file in dir and stores the valid ones. A broken
+// file is reported in its Result and does not prevent the others from
+// loading.
+func LoadDir(db *database.DB, dir string) ([]Result, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && IsMapFile(entry.Name()) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+
+	var results []Result
+	seen := map[string]string{}
+	for _, file := range files {
+		result := Result{File: file}
+		def, err := ParseFile(file)
+		if err == nil {
+			if other, dup := seen[def.Name]; dup {
+				err = fmt.Errorf("map %q is already defined in %s", def.Name, other)
+			} else {
+				seen[def.Name] = file
+			}
+		}
+		if err == nil {
+			result.Map = def.Name
+			result.MapID, result.Changes, err = Load(db, def)
+		}
+		result.Err = err
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Load validates a definition and upserts it into the database.
+func Load(db *database.DB, def *MapDefinition) (int, []database.MapChange, error) {
+	if err := def.Validate(); err != nil {
+		return 0, nil, err
+	}
+	if err := checkWaits(db, def); err != nil {
+		return 0, nil, err
+	}
+	m, dependsOn := def.ToMap()
+	return db.SyncMap(m, dependsOn)
+}
+
+// checkWaits rejects wait_for entries naming a step that the map they wait
+// for does not have. Maps that are not loaded yet are left alone, as they may
+// be loaded later.
+func checkWaits(db *database.DB, def *MapDefinition) error {
+	var errs []error
+	loaded := map[string]map[string]bool{} // step names by map, nil when the map is not loaded
+	for _, step := range def.Steps {
+		for i, wait := range step.WaitFor {
+			if wait.Step == "" {
+				continue
+			}
+			names, ok := loaded[wait.Map]
+			if !ok {
+				var err error
+				if names, err = stepNames(db, wait.Map); err != nil {
+					return err
+				}
+				loaded[wait.Map] = names
+			}
+			if names != nil && !names[wait.Step] {
+				errs = append(errs, fmt.Errorf("step %q wait_for %d waits for unknown step %q of map %q", step.Name, i+1, wait.Step, wait.Map))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stepNames returns the names of the steps of a map, or nil when there is no
+// map by that name.
+func stepNames(db *database.DB, mapName string) (map[string]bool, error) {
+	m, err := db.GetMapByName(mapName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	steps, err := db.GetStepsByMapID(m.ID)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, step := range steps {
+		names[step.Name] = true
+	}
+	return names, nil
+}
@@ -0,0 +1,326 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+const salesYAML = `name: sales
+schedule: "0 6 * * *"
+start_date: 2024-01-01
+on_failure:
+  - command: sales/alert.py
+steps:
+  - name: extract
+    command: sales/extract.py
+    retries: 2
+    retry_delay: 30s
+    on_retry:
+      - func: page
+        timeout: 10s
+  - name: load
+    command: sales/load.py
+    depends_on: [extract]
+    connections: [warehouse]
+`
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		def  MapDefinition
+		want string
+	}{
+		{"bad schedule", MapDefinition{Name: "m", Schedule: "never", Steps: []StepDefinition{{Name: "a", Command: "a.py"}}}, "invalid schedule"},
+		{"no steps", MapDefinition{Name: "m", Schedule: "@daily"}, "no steps"},
+		{"duplicate", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py"}, {Name: "a", Command: "b.py"}}}, "duplicate step name"},
+		{"unknown dependency", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py", DependsOn: []string{"b"}}}}, "unknown step"},
+		{"cycle", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{
+			{Name: "a", Command: "a.py", DependsOn: []string{"b"}},
+			{Name: "b", Command: "b.py", DependsOn: []string{"a"}},
+		}}, "cycle"},
+		{"notifier without url", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py"}},
+			Notify: []NotifierDefinition{{Type: "slack"}}}, "has no url"},
+		{"notifier url with a secret", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py"}},
+			Notify: []NotifierDefinition{{Type: "webhook", URL: "https://example.com/?k=$PILOT_SECRET_KEY"}}}, "only PILOT_NOTIFY_* variables"},
+		{"unknown notifier", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py"}},
+			Notify: []NotifierDefinition{{Type: "pager", URL: "http://x"}}}, "unknown type"},
+		{"unknown event", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py"}},
+			Notify: []NotifierDefinition{{Type: "email", To: []string{"ops@example.com"}, Events: []string{"step_started"}}}}, "unknown event"},
+		{"bad sla", MapDefinition{Name: "m", Schedule: "@daily", SLA: "soon", Steps: []StepDefinition{{Name: "a", Command: "a.py"}}}, "invalid sla"},
+		{"bad sla_from", MapDefinition{Name: "m", Schedule: "@daily", SLA: "1h", SLAFrom: "start", Steps: []StepDefinition{{Name: "a", Command: "a.py"}}}, "sla_from"},
+		{"bad step sla", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py", SLA: "-5m"}}}, "sla"},
+		{"empty hook", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py"}},
+			Hooks: Hooks{OnFailure: []HookDefinition{{Timeout: "1m"}}}}, "map on_failure hook 1 needs either a command or a func"},
+		{"hook with both", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py",
+			Hooks: Hooks{OnSuccess: []HookDefinition{{Command: "b.py", Func: "refresh"}}}}}}, `step "a" on_success hook 1`},
+		{"bad hook timeout", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py",
+			Hooks: Hooks{OnRetry: []HookDefinition{{Func: "page", Timeout: "a while"}}}}}}, "invalid timeout"},
+		{"sensor and command", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py",
+			Sensor: &SensorDefinition{Type: "file", Path: "/data/in.csv"}}}}, "both a command and a sensor"},
+		{"sensor without query", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a",
+			Sensor: &SensorDefinition{Type: "sql", Connection: "warehouse"}}}}, "needs a connection and a query"},
+		{"unknown sensor", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a",
+			Sensor: &SensorDefinition{Type: "s3", Path: "s3://bucket/key"}}}}, "unknown type"},
+		{"bad sensor mode", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a",
+			Sensor: &SensorDefinition{Type: "http", URL: "http://api/ready", Mode: "sleep", PokeInterval: "soon"}}}}, "invalid mode"},
+		{"no schedule", MapDefinition{Name: "m", Steps: []StepDefinition{{Name: "a", Command: "a.py"}}}, "needs a schedule or a trigger"},
+		{"trigger without files", MapDefinition{Name: "m", Trigger: &TriggerDefinition{Archive: "/data/done"},
+			Steps: []StepDefinition{{Name: "a", Command: "a.py"}}}, "needs a files pattern or datasets"},
+		{"bad trigger settle", MapDefinition{Name: "m", Trigger: &TriggerDefinition{Files: "/data/in/*.csv", Settle: "-1m"},
+			Steps: []StepDefinition{{Name: "a", Command: "a.py"}}}, "invalid settle"},
+		{"wait without map", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py",
+			WaitFor: []WaitDefinition{{Step: "load"}}}}}, "wait_for 1 has no map"},
+		{"wait for own map", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py",
+			WaitFor: []WaitDefinition{{Map: "m", Step: "b"}}}}}, "use depends_on"},
+		{"bad wait offset", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py",
+			WaitFor: []WaitDefinition{{Map: "sales", Offset: "yesterday"}}}}}, "invalid offset"},
+		{"negative wait timeout", MapDefinition{Name: "m", Schedule: "@daily", Steps: []StepDefinition{{Name: "a", Command: "a.py",
+			WaitFor: []WaitDefinition{{Map: "sales", Timeout: "-1h"}}}}}, "invalid timeout"},
+		{"consumes own dataset", MapDefinition{Name: "m", Trigger: &TriggerDefinition{Datasets: []string{"sales"}},
+			Steps: []StepDefinition{{Name: "a", Command: "a.py", Produces: []string{"sales"}}}}, "consumed by its own map"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.def.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSensorDefinition(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "landing.yaml", `name: landing
+schedule: "@hourly"
+steps:
+  - name: landed
+    sensor:
+      type: file
+      path: /data/sales/{{ .Ds }}/*.csv
+      poke_interval: 5m
+      timeout: 6h
+      mode: reschedule
+      soft_fail: true
+  - name: load
+    command: sales/load.py
+    depends_on: [landed]
+`)
+	def, err := ParseFile(filepath.Join(dir, "landing.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := def.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := def.ToMap()
+	want := models.Sensor{Type: "file", Path: "/data/sales/{{ .Ds }}/*.csv", PokeInterval: 5 * time.Minute, Timeout: 6 * time.Hour,
+		Mode: "reschedule", SoftFail: true}
+	if m.Steps[0].Sensor == nil || *m.Steps[0].Sensor != want || m.Steps[1].Sensor != nil {
+		t.Fatalf("sensors = %+v, %+v", m.Steps[0].Sensor, m.Steps[1].Sensor)
+	}
+	if back := FromMap(m).Steps[0].Sensor; back == nil || *back.toModel() != want {
+		t.Errorf("FromMap sensor = %+v", back)
+	}
+}
+
+func TestTriggerDefinition(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "landing.yaml", `name: landing
+trigger:
+  files: /data/incoming/*.csv
+  archive: /data/processed
+  settle: 1m
+steps:
+  - name: load
+    command: sales/load.py
+`)
+	def, err := ParseFile(filepath.Join(dir, "landing.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := def.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := def.ToMap()
+	want := models.Trigger{Files: "/data/incoming/*.csv", Archive: "/data/processed", Settle: time.Minute}
+	if m.ScheduleInterval != "" || m.Trigger == nil || !reflect.DeepEqual(*m.Trigger, want) {
+		t.Fatalf("schedule %q, trigger %+v", m.ScheduleInterval, m.Trigger)
+	}
+	if back := FromMap(m).Trigger; back == nil || !reflect.DeepEqual(*back.toModel(), want) {
+		t.Errorf("FromMap trigger = %+v", back)
+	}
+}
+
+func TestDatasetDefinition(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "reports.yaml", `name: reports
+trigger:
+  datasets: [warehouse.sales, warehouse.returns]
+steps:
+  - name: build
+    command: reports/build.py
+    produces: [reports.daily]
+`)
+	def, err := ParseFile(filepath.Join(dir, "reports.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := def.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := def.ToMap()
+	if m.Trigger == nil || !reflect.DeepEqual(m.Trigger.Datasets, []string{"warehouse.sales", "warehouse.returns"}) {
+		t.Errorf("trigger = %+v", m.Trigger)
+	}
+	if !reflect.DeepEqual(m.Steps[0].Produces, []string{"reports.daily"}) {
+		t.Errorf("produces = %v", m.Steps[0].Produces)
+	}
+	back := FromMap(m)
+	if !reflect.DeepEqual(back.Trigger.Datasets, def.Trigger.Datasets) || !reflect.DeepEqual(back.Steps[0].Produces, def.Steps[0].Produces) {
+		t.Errorf("FromMap = %+v", back)
+	}
+}
+
+func TestWaitDefinition(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "reports.yaml", `name: reports
+schedule: "0 7 * * *"
+steps:
+  - name: build
+    command: reports/build.py
+    wait_for:
+      - map: sales
+        step: load
+        offset: -1h
+        timeout: 6h
+      - map: rates
+`)
+	def, err := ParseFile(filepath.Join(dir, "reports.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := def.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := def.ToMap()
+	want := []models.ExternalDependency{{Map: "sales", Step: "load", Offset: -time.Hour, Timeout: 6 * time.Hour}, {Map: "rates"}}
+	if !reflect.DeepEqual(m.Steps[0].WaitFor, want) {
+		t.Errorf("wait_for = %+v, want %+v", m.Steps[0].WaitFor, want)
+	}
+	if back := FromMap(m).Steps[0].WaitFor; len(back) != 2 || back[0].Offset != "-1h0m0s" || back[0].Timeout != "6h0m0s" || back[1].Offset != "" {
+		t.Errorf("FromMap wait_for = %+v", back)
+	}
+}
+
+func TestLoadChecksWaitSteps(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sales := &MapDefinition{Name: "sales", Schedule: "0 6 * * *", Steps: []StepDefinition{{Name: "load", Command: "load.py"}}}
+	if _, _, err := Load(db, sales); err != nil {
+		t.Fatal(err)
+	}
+
+	reports := func(waits ...WaitDefinition) *MapDefinition {
+		return &MapDefinition{Name: "reports", Schedule: "0 7 * * *", Steps: []StepDefinition{{Name: "build", Command: "build.py", WaitFor: waits}}}
+	}
+	if _, _, err := Load(db, reports(WaitDefinition{Map: "sales", Step: "publish"})); err == nil || !strings.Contains(err.Error(), `unknown step "publish" of map "sales"`) {
+		t.Errorf("Load() waiting for a missing step = %v, want an unknown step error", err)
+	}
+	// Steps of maps that are not loaded yet cannot be checked
+	if _, _, err := Load(db, reports(WaitDefinition{Map: "sales", Step: "load"}, WaitDefinition{Map: "rates", Step: "publish"})); err != nil {
+		t.Errorf("Load() = %v", err)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, dir, "sales.yaml", salesYAML)
+	writeFile(t, dir, "broken.json", `{"name": "broken", "schedule": "@daily", "steps": [{"name": "a"}]}`)
+	writeFile(t, dir, "notes.txt", "ignored")
+
+	results, err := LoadDir(db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("LoadDir returned %d results, want 2", len(results))
+	}
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "no command") {
+		t.Errorf("broken.json: got error %v, want missing command", results[0].Err)
+	}
+	if results[1].Err != nil {
+		t.Fatalf("sales.yaml: %v", results[1].Err)
+	}
+
+	steps, err := db.GetStepsByMapID(results[1].MapID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]int{}
+	for _, step := range steps {
+		ids[step.Name] = step.ID
+	}
+	for _, step := range steps {
+		if step.Name == "load" {
+			if len(step.Dependencies) != 1 || step.Dependencies[0] != ids["extract"] {
+				t.Errorf("load dependencies = %v, want [%d]", step.Dependencies, ids["extract"])
+			}
+			if len(step.Connections) != 1 || step.Connections[0] != "warehouse" {
+				t.Errorf("load connections = %v", step.Connections)
+			}
+		}
+		if step.Name == "extract" && (step.Retries != 2 || step.RetryDelay.Seconds() != 30) {
+			t.Errorf("extract retries = %d delay = %v", step.Retries, step.RetryDelay)
+		}
+		if step.Name == "extract" && (len(step.Hooks) != 1 || step.Hooks[0] != models.Hook{On: "on_retry", Func: "page", Timeout: 10 * time.Second}) {
+			t.Errorf("extract hooks = %+v", step.Hooks)
+		}
+	}
+	m, err := db.GetMapByID(results[1].MapID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Hooks) != 1 || m.Hooks[0].On != "on_failure" || m.Hooks[0].Command != "sales/alert.py" {
+		t.Errorf("map hooks = %+v", m.Hooks)
+	}
+
+	// Reloading an unchanged file is a no-op
+	results, _ = LoadDir(db, dir)
+	if len(results[1].Changes) != 0 {
+		t.Errorf("reload changes = %v, want none", results[1].Changes)
+	}
+
+	// Replace load with report, which now depends on extract
+	changed := strings.Replace(salesYAML, "name: load\n    command: sales/load.py", "name: report\n    command: sales/report.py", 1)
+	writeFile(t, dir, "sales.yaml", changed)
+	results, _ = LoadDir(db, dir)
+	var got []string
+	for _, change := range results[1].Changes {
+		got = append(got, change.String())
+	}
+	if strings.Join(got, "; ") != "added step report; removed step load" {
+		t.Errorf("changes = %q", got)
+	}
+}
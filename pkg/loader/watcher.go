@@ -0,0 +1,177 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"pilot/internal/database"
+)
+
+// DefaultWatchInterval is how often the maps folder is checked for changes.
+const DefaultWatchInterval = 10 * time.Second
+
+// Watcher keeps the database in sync with the maps folder by periodically
+// hashing the map files and reloading the ones that changed.
+type Watcher struct {
+	Interval time.Duration
+	db       *database.DB
+	dir      string
+	hashes   map[string][sha256.Size]byte
+	maps     map[string]string // map name by file
+}
+
+// NewWatcher creates a Watcher for the map files in dir.
+func NewWatcher(db *database.DB, dir string) *Watcher {
+	return &Watcher{
+		Interval: DefaultWatchInterval,
+		db:       db,
+		dir:      dir,
+		hashes:   map[string][sha256.Size]byte{},
+		maps:     map[string]string{},
+	}
+}
+
+// Scan reloads the files whose contents changed since the previous scan, or
+// that failed to load, and pauses the maps whose file was removed. Only files
+// that were acted upon are reported.
+func (w *Watcher) Scan() ([]Result, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	present := map[string]bool{}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && IsMapFile(entry.Name()) {
+			file := filepath.Join(w.dir, entry.Name())
+			files = append(files, file)
+			present[file] = true
+		}
+	}
+	sort.Strings(files)
+
+	var results []Result
+	for file := range w.hashes {
+		if !present[file] {
+			results = append(results, w.remove(file))
+		}
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			results = append(results, Result{File: file, Err: err})
+			continue
+		}
+		hash := sha256.Sum256(data)
+		if old, seen := w.hashes[file]; seen && old == hash {
+			continue
+		}
+		w.hashes[file] = hash
+		result := w.load(file)
+		if result.Err != nil {
+			// Retry on the next scan, as the failure may not be in the file,
+			// e.g. a map by the same name whose file is removed later
+			delete(w.hashes, file)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (w *Watcher) load(file string) Result {
+	result := Result{File: file}
+	def, err := ParseFile(file)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Map = def.Name
+
+	for other, name := range w.maps {
+		if name == def.Name && other != file {
+			result.Err = fmt.Errorf("map %q is already defined in %s", def.Name, other)
+			return result
+		}
+	}
+
+	result.MapID, result.Changes, result.Err = Load(w.db, def)
+	if result.Err != nil {
+		return result
+	}
+	if previous, ok := w.maps[file]; ok && previous != def.Name {
+		w.pause(previous, &result)
+	}
+	w.maps[file] = def.Name
+	for _, change := range result.Changes {
+		if change.Action == "deferred" {
+			// Retry on the next scan once the running steps have finished
+			delete(w.hashes, file)
+			break
+		}
+	}
+	return result
+}
+
+func (w *Watcher) remove(file string) Result {
+	delete(w.hashes, file)
+	result := Result{File: file, Map: w.maps[file]}
+	if name, ok := w.maps[file]; ok {
+		delete(w.maps, file)
+		w.pause(name, &result)
+	}
+	return result
+}
+
+// pause deactivates a map that is no longer defined by any file.
+func (w *Watcher) pause(name string, result *Result) {
+	m, err := w.db.GetMapByName(name)
+	if err != nil {
+		result.Err = err
+		return
+	}
+	if !m.IsActive {
+		return
+	}
+	m.IsActive = false
+	if err := w.db.UpdateMap(*m); err != nil {
+		result.Err = err
+		return
+	}
+	result.MapID = m.ID
+	result.Changes = append(result.Changes, database.MapChange{Action: "changed", Target: name, Detail: "paused, definition file removed"})
+}
+
+// Reload scans the maps folder once and logs the outcome of each file.
+func (w *Watcher) Reload() {
+	results, err := w.Scan()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Failed to read maps folder", "dir", w.dir, "error", err)
+		}
+		return
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			slog.Error("Failed to load map file", "file", result.File, "error", result.Err)
+			continue
+		}
+		for _, change := range result.Changes {
+			slog.Info("Map changed", "map", result.Map, "map_id", result.MapID, "file", filepath.Base(result.File), "change", change.String())
+		}
+	}
+}
+
+// Watch reloads the maps folder every Interval. It never returns.
+func (w *Watcher) Watch() {
+	for {
+		time.Sleep(w.Interval)
+		w.Reload()
+	}
+}
@@ -0,0 +1,129 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pilot/internal/database"
+)
+
+func changeList(results []Result) string {
+	var got []string
+	for _, result := range results {
+		if result.Err != nil {
+			got = append(got, filepath.Base(result.File)+": "+result.Err.Error())
+		}
+		for _, change := range result.Changes {
+			got = append(got, change.String())
+		}
+	}
+	return strings.Join(got, "; ")
+}
+
+func TestWatcherScan(t *testing.T) {
+	dir := t.TempDir()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher(db, dir)
+
+	writeFile(t, dir, "sales.yaml", salesYAML)
+	results, err := w.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := changeList(results); got != "created map sales; added step extract; added step load" {
+		t.Errorf("first scan = %q", got)
+	}
+
+	// Nothing changed on disk
+	if results, _ := w.Scan(); len(results) != 0 {
+		t.Errorf("unchanged scan reported %d results", len(results))
+	}
+
+	// The load step is running when it is removed from the file
+	m, err := db.GetMapByName("sales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps, _ := db.GetStepsByMapID(m.ID)
+	for _, step := range steps {
+		if step.Name == "load" {
+			step.State = "running"
+			if err := db.SetStepState(step); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	changed := strings.Replace(salesYAML, "name: load\n    command: sales/load.py\n    depends_on: [extract]", "name: report\n    command: sales/report.py\n    depends_on: [extract]", 1)
+	writeFile(t, dir, "sales.yaml", changed)
+	results, _ = w.Scan()
+	if got := changeList(results); got != "added step report; kept running step load until it finishes" {
+		t.Errorf("scan with running step = %q", got)
+	}
+
+	// Once the step has finished the removal is applied
+	for _, step := range steps {
+		if step.Name == "load" {
+			step.State = "completed"
+			if err := db.SetStepState(step); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	results, _ = w.Scan()
+	if got := changeList(results); got != "removed step load" {
+		t.Errorf("scan after step finished = %q", got)
+	}
+
+	// Deleting the file pauses the map
+	if err := os.Remove(filepath.Join(dir, "sales.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	results, _ = w.Scan()
+	if got := changeList(results); got != "changed map sales: paused, definition file removed" {
+		t.Errorf("scan after removal = %q", got)
+	}
+	if m, _ := db.GetMapByName("sales"); m.IsActive {
+		t.Error("map is still active after its file was removed")
+	}
+}
+
+func TestWatcherRetriesFailedLoads(t *testing.T) {
+	dir := t.TempDir()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher(db, dir)
+
+	// copy.yaml is loaded first and holds the name of the map
+	writeFile(t, dir, "copy.yaml", salesYAML)
+	writeFile(t, dir, "sales.yaml", salesYAML)
+	for i := 0; i < 2; i++ {
+		results, err := w.Scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := changeList(results); !strings.Contains(got, "sales.yaml: map \"sales\" is already defined in") {
+			t.Errorf("scan %d = %q, want sales.yaml rejected", i+1, got)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(dir, "copy.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	results, _ := w.Scan()
+	if got := changeList(results); strings.Contains(got, "already defined") {
+		t.Errorf("scan after removing copy.yaml = %q, want sales.yaml loaded", got)
+	}
+	if m, err := db.GetMapByName("sales"); err != nil || !m.IsActive {
+		t.Errorf("sales = %+v, %v, want it active from sales.yaml", m, err)
+	}
+	if results, _ := w.Scan(); len(results) != 0 {
+		t.Errorf("scan once loaded reported %q", changeList(results))
+	}
+}
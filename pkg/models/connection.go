@@ -0,0 +1,32 @@
+package models
+
+// Connection holds the credentials for an external system that steps can
+// reference by name instead of hard-coding them.
+type Connection struct {
+	ID       int
+	Name     string
+	URI      string
+	Login    string
+	Password string
+	Extras   map[string]string
+}
+
+// NewConnection creates and returns a new Connection instance.
+func NewConnection(name string, uri string, login string, password string) *Connection {
+	return &Connection{
+		Name:     name,
+		URI:      uri,
+		Login:    login,
+		Password: password,
+		Extras:   map[string]string{},
+	}
+}
+
+// Secrets returns the values of the connection that must never appear in logs.
+func (c *Connection) Secrets() []string {
+	secrets := []string{c.URI, c.Login, c.Password}
+	for _, v := range c.Extras {
+		secrets = append(secrets, v)
+	}
+	return secrets
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// DatasetEvent records an update of a dataset by a completed step.
+type DatasetEvent struct {
+	ID        int       `json:"id"`
+	Dataset   string    `json:"dataset"`
+	MapID     int       `json:"map_id"`
+	RunID     int       `json:"run_id"`
+	StepID    int       `json:"step_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Dataset summarizes a dataset: the steps producing it, the maps consuming
+// it and its latest update.
+type Dataset struct {
+	Name       string        `json:"name"`
+	Producers  []string      `json:"producers"` // map.step names
+	Consumers  []string      `json:"consumers"` // map names
+	LastUpdate *DatasetEvent `json:"last_update,omitempty"`
+}
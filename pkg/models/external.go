@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// ExternalDependency makes a step wait for a step of another map, or for a
+// whole run of it when Step is empty. The run waited for is the one whose
+// logical date is the logical date of the waiting run plus Offset.
+type ExternalDependency struct {
+	Map     string        `json:"map"`
+	Step    string        `json:"step,omitempty"`
+	Offset  time.Duration `json:"offset,omitempty"`  // e.g. -24h to wait for the previous day of a daily map
+	Timeout time.Duration `json:"timeout,omitempty"` // How long the step waits, without limit when zero
+}
+
+// ExternalWait describes an external dependency of a step that is not met
+// yet.
+type ExternalWait struct {
+	StepName string `json:"step_name,omitempty"` // Waiting step
+	ExternalDependency
+	LogicalDate time.Time `json:"logical_date"` // Logical date of the run waited for
+	RunID       int       `json:"run_id,omitempty"`
+	State       string    `json:"state"` // State of the step or run waited for, or why it cannot be found
+}
+
+// States of external dependencies that cannot be found.
+const (
+	ExternalUnknownMap  = "unknown map"
+	ExternalUnknownStep = "unknown step"
+	ExternalNoRun       = "no run"
+)
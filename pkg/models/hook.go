@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Step outcomes a hook can run on.
+const (
+	HookOnSuccess = "on_success" // the step completed
+	HookOnFailure = "on_failure" // the step failed after its last retry
+	HookOnRetry   = "on_retry"   // a step attempt failed and will be retried
+)
+
+// HookEvents lists every outcome a hook can run on.
+var HookEvents = []string{HookOnSuccess, HookOnFailure, HookOnRetry}
+
+// DefaultHookTimeout bounds hooks that do not set their own timeout.
+const DefaultHookTimeout = time.Minute
+
+// Hook is a callback the worker runs after a step reaches an outcome. It
+// either runs a script, like step commands, or calls a Go function registered
+// with the worker under Func. Hook failures are logged and do not change the
+// state of the step.
+type Hook struct {
+	On      string        `json:"on"`                // one of HookEvents
+	Command string        `json:"command,omitempty"` // Script run with the environment of the step
+	Func    string        `json:"func,omitempty"`    // Name of a registered Go function
+	Timeout time.Duration `json:"timeout,omitempty"` // DefaultHookTimeout when zero
+}
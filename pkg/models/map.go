@@ -4,13 +4,21 @@ import "time"
 
 // DAG represents a directed acyclic graph of tasks.
 type Map struct {
-	ID               int
-	Name             string
-	ScheduleInterval string
-	IsActive         bool
-	StartDate        time.Time
-	LastRun          time.Time
-	Steps            []Step // Collection of steps
+	ID               int           `json:"id"`
+	Name             string        `json:"name"`
+	ScheduleInterval string        `json:"schedule_interval"`
+	IsActive         bool          `json:"is_active"`
+	StartDate        time.Time     `json:"start_date"`
+	LastRun          time.Time     `json:"last_run"`
+	PausedBy         string        `json:"paused_by,omitempty"`    // Who paused the map, if it was paused by hand
+	PauseReason      string        `json:"pause_reason,omitempty"` // Why the map was paused
+	PausedAt         *time.Time    `json:"paused_at,omitempty"`
+	SLA              time.Duration `json:"sla,omitempty"`       // Time after SLAFrom by which runs must have succeeded
+	SLAFrom          string        `json:"sla_from,omitempty"`  // Base of the map and step SLAs, SLAFromLogicalDate when empty
+	Notifiers        []Notifier    `json:"notifiers,omitempty"` // Destinations told about failures and other events
+	Hooks            []Hook        `json:"hooks,omitempty"`     // Callbacks run after the outcomes of every step
+	Trigger          *Trigger      `json:"trigger,omitempty"`   // Starts runs on events besides the schedule
+	Steps            []Step        `json:"steps,omitempty"`     // Collection of steps
 }
 
 // NewDAG creates and returns a new DAG instance.
@@ -24,3 +32,18 @@ func NewMap(name string, scheduleInterval string, startDate time.Time, lastRun t
 		Steps:            steps,
 	}
 }
+
+// Bases SLAs are measured from.
+const (
+	SLAFromLogicalDate = "logical_date" // the logical date of the run
+	SLAFromSchedule    = "schedule"     // the time the run was scheduled or triggered
+)
+
+// SLADeadline returns the time by which a run of the map, or one of its
+// steps, has to succeed to meet an SLA.
+func (m Map) SLADeadline(run MapRun, sla time.Duration) time.Time {
+	if m.SLAFrom == SLAFromSchedule {
+		return run.StartDate.Add(sla)
+	}
+	return run.LogicalDate.Add(sla)
+}
@@ -0,0 +1,41 @@
+package models
+
+import "slices"
+
+// Events a notifier can be told about.
+const (
+	EventStepFailed = "step_failed" // a step failed after its last retry
+	EventStepRetry  = "step_retry"  // a step attempt failed and will be retried
+	EventRunFailed  = "run_failed"
+	EventRunSuccess = "run_success"
+	EventSLAMissed  = "sla_missed"
+)
+
+// Events lists every notification event.
+var Events = []string{EventStepFailed, EventStepRetry, EventRunFailed, EventRunSuccess, EventSLAMissed}
+
+// DefaultEvents are the events sent to a notifier that does not list any.
+var DefaultEvents = []string{EventStepFailed, EventRunFailed, EventSLAMissed}
+
+// Kinds of notifiers.
+const (
+	NotifierWebhook = "webhook" // POSTs the event as JSON
+	NotifierSlack   = "slack"   // POSTs a message to a Slack compatible incoming webhook
+	NotifierEmail   = "email"   // sends an email through the configured SMTP server
+)
+
+// Notifier tells a destination about the events of a map.
+type Notifier struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url,omitempty"`    // Webhook and Slack URL, only $PILOT_NOTIFY_* variables are expanded when sending
+	To     []string `json:"to,omitempty"`     // Email recipients
+	Events []string `json:"events,omitempty"` // Events to send, DefaultEvents when empty
+}
+
+// Wants reports whether the notifier is interested in event.
+func (n Notifier) Wants(event string) bool {
+	if len(n.Events) == 0 {
+		return slices.Contains(DefaultEvents, event)
+	}
+	return slices.Contains(n.Events, event)
+}
@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// MapRun is a single execution of a map for a logical date.
+type MapRun struct {
+	ID          int            `json:"id"`
+	MapID       int            `json:"map_id"`
+	RunType     string         `json:"run_type"` // scheduled or manual
+	LogicalDate time.Time      `json:"logical_date"`
+	State       string         `json:"state"`          // running, success, failed or cancelled
+	Conf        map[string]any `json:"conf,omitempty"` // Configuration passed when triggering the run
+	StartDate   time.Time      `json:"start_date"`
+	EndDate     time.Time      `json:"end_date"`
+	TraceParent string         `json:"trace_parent,omitempty"` // W3C traceparent of the root span of the run
+}
+
+// StepRun is the instance of a step within a map run.
+type StepRun struct {
+	ID        int       `json:"id"`
+	RunID     int       `json:"run_id"`
+	StepID    int       `json:"step_id"`
+	StepName  string    `json:"step_name"`
+	State     string    `json:"state"` // pending, queued, running, up_for_reschedule, completed, failed, skipped, upstream_failed or cancelled
+	Attempt   int       `json:"attempt"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+}
+
+// Finished reports whether the step instance reached a terminal state.
+func (r StepRun) Finished() bool {
+	switch r.State {
+	case "completed", "failed", "skipped", "upstream_failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// StepRunMark records an operator forcing the state of a step instance.
+type StepRunMark struct {
+	ID            int       `json:"id"`
+	RunID         int       `json:"run_id"`
+	StepID        int       `json:"step_id"`
+	StepName      string    `json:"step_name"`
+	PreviousState string    `json:"previous_state"`
+	State         string    `json:"state"`
+	MarkedBy      string    `json:"marked_by"`
+	Note          string    `json:"note"`
+	MarkedAt      time.Time `json:"marked_at"`
+}
+
+// StepLog is the captured output of one attempt of a step instance.
+type StepLog struct {
+	RunID     int       `json:"run_id"`
+	StepID    int       `json:"step_id"`
+	Attempt   int       `json:"attempt"`
+	Output    string    `json:"output"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SLAMiss records a map run or a step instance that had not succeeded by its
+// SLA deadline. StepID is 0 for the SLA of the map itself.
+type SLAMiss struct {
+	ID         int       `json:"id"`
+	MapID      int       `json:"map_id"`
+	RunID      int       `json:"run_id"`
+	StepID     int       `json:"step_id,omitempty"`
+	StepName   string    `json:"step_name,omitempty"`
+	Deadline   time.Time `json:"deadline"`
+	State      string    `json:"state"` // State of the run or step when the miss was detected
+	DetectedAt time.Time `json:"detected_at"`
+}
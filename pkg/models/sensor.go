@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// Kinds of sensors.
+const (
+	SensorFile = "file" // a file or glob pattern matches at least one file
+	SensorSQL  = "sql"  // a query on a connection returns rows
+	SensorHTTP = "http" // a URL answers with the expected status
+)
+
+// Modes of sensors.
+const (
+	SensorModePoke       = "poke"       // keep the worker between pokes
+	SensorModeReschedule = "reschedule" // release the worker and be queued again for the next poke
+)
+
+// Defaults of sensors that do not set their own.
+const (
+	DefaultPokeInterval  = time.Minute
+	DefaultSensorTimeout = 24 * time.Hour
+	DefaultSensorStatus  = 200
+)
+
+// Sensor makes a step wait for an external condition instead of running a
+// command. The condition is checked, or poked, every PokeInterval until it
+// holds or Timeout passes since the first poke.
+type Sensor struct {
+	Type         string        `json:"type"`                 // one of SensorFile, SensorSQL or SensorHTTP
+	Path         string        `json:"path,omitempty"`       // File path or glob pattern
+	Connection   string        `json:"connection,omitempty"` // Connection the SQL query runs on
+	Query        string        `json:"query,omitempty"`      // SQL query
+	URL          string        `json:"url,omitempty"`        // URL requested with GET
+	Status       int           `json:"status,omitempty"`     // Expected HTTP status, DefaultSensorStatus when zero
+	PokeInterval time.Duration `json:"poke_interval,omitempty"`
+	Timeout      time.Duration `json:"timeout,omitempty"`
+	SoftFail     bool          `json:"soft_fail,omitempty"` // Skip the step instead of failing it on timeout
+	Mode         string        `json:"mode,omitempty"`      // SensorModePoke when empty
+}
+
+// Interval returns the time between pokes.
+func (s Sensor) Interval() time.Duration {
+	if s.PokeInterval <= 0 {
+		return DefaultPokeInterval
+	}
+	return s.PokeInterval
+}
+
+// Deadline returns when a sensor first poked at started times out.
+func (s Sensor) Deadline(started time.Time) time.Time {
+	if s.Timeout <= 0 {
+		return started.Add(DefaultSensorTimeout)
+	}
+	return started.Add(s.Timeout)
+}
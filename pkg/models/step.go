@@ -4,14 +4,24 @@ import "time"
 
 // Task represents an individual task in a DAG.
 type Step struct {
-	ID           int
-	Name         string
-	MapID        int
-	State        string
-	Command      string
-	StartDate    time.Time
-	EndDate      time.Time
-	Dependencies []int // IDs of dependent tasks
+	ID           int                  `json:"id"`
+	Name         string               `json:"name"`
+	MapID        int                  `json:"map_id"`
+	State        string               `json:"state"`
+	Command      string               `json:"command"`
+	StartDate    time.Time            `json:"start_date"`
+	EndDate      time.Time            `json:"end_date"`
+	Dependencies []int                `json:"dependencies"`       // IDs of dependent tasks
+	Connections  []string             `json:"connections"`        // Names of connections injected into the step
+	Retries      int                  `json:"retries"`            // Number of times a failed step is retried
+	RetryDelay   time.Duration        `json:"retry_delay"`        // Time to wait between attempts
+	SLA          time.Duration        `json:"sla,omitempty"`      // Time after the SLA base of the map by which the step must have completed
+	Hooks        []Hook               `json:"hooks,omitempty"`    // Callbacks run after the outcomes of the step
+	Sensor       *Sensor              `json:"sensor,omitempty"`   // Condition waited for instead of running Command
+	Produces     []string             `json:"produces,omitempty"` // Datasets updated each time the step completes
+	WaitFor      []ExternalDependency `json:"wait_for,omitempty"` // Steps or runs of other maps the step depends on
+	RunID        int                  `json:"run_id,omitempty"`   // Map run the step is executed for, if any
+	Attempt      int                  `json:"attempt,omitempty"`  // Current attempt within the map run
 }
 
 // NewTask creates and returns a new Task instance.
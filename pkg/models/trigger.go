@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Run types of runs started by triggers.
+const (
+	RunTypeFile    = "file"    // started by a new file
+	RunTypeDataset = "dataset" // started by updates of the datasets a map consumes
+)
+
+// DefaultFileSettle is how long a file must stay unchanged before it starts
+// a run, so that files still being written are not picked up.
+const DefaultFileSettle = 30 * time.Second
+
+// Trigger starts runs of a map on events rather than on its schedule: new
+// files, or updates of the datasets the map consumes since the previous run
+// they started.
+type Trigger struct {
+	Files    string        `json:"files,omitempty"`    // Glob pattern of the files that each start a run
+	Archive  string        `json:"archive,omitempty"`  // Directory files are moved to once their run succeeded
+	Settle   time.Duration `json:"settle,omitempty"`   // DefaultFileSettle when zero
+	Datasets []string      `json:"datasets,omitempty"` // Datasets consumed, a run starts once all of them were updated
+}
+
+// TriggerFile records a file that started a run of a map. A file is only
+// picked up again if it is modified or replaced.
+type TriggerFile struct {
+	ID         int       `json:"id"`
+	MapID      int       `json:"map_id"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	RunID      int       `json:"run_id,omitempty"`
+	DetectedAt time.Time `json:"detected_at"`
+	ArchivedTo string    `json:"archived_to,omitempty"` // Where the file was moved after its run succeeded
+}
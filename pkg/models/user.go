@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// Roles of API users, from least to most privileged. Viewers can read,
+// operators can also trigger, pause, clear and mark runs, and admins can also
+// change and delete maps.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAllows reports whether role grants at least the privileges of need.
+func RoleAllows(role string, need string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[need]
+}
+
+// User is an account of the HTTP API.
+type User struct {
+	ID          int            `json:"id"`
+	Name        string         `json:"name"`
+	Role        string         `json:"role"`
+	Permissions map[int]string `json:"permissions,omitempty"` // Roles granted on single maps, by map ID
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+// MapRole returns the role of the user on a map: its own role, or the role
+// granted on the map when that one is higher.
+func (u *User) MapRole(mapID int) string {
+	if granted, ok := u.Permissions[mapID]; ok && roleRanks[granted] > roleRanks[u.Role] {
+		return granted
+	}
+	return u.Role
+}
+
+// APIToken is a token a user authenticates with. Only a hash of the token
+// itself is stored.
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	UserName   string     `json:"user_name"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
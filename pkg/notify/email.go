@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMTPServer is the mail server emails are sent through.
+type SMTPServer struct {
+	Addr     string // host:port
+	From     string
+	Username string // Authenticates with PLAIN auth when set
+	Password string
+}
+
+// SMTPFromEnv reads the mail server from PILOT_SMTP_ADDR, PILOT_SMTP_FROM,
+// PILOT_SMTP_USERNAME and PILOT_SMTP_PASSWORD.
+func SMTPFromEnv() SMTPServer {
+	server := SMTPServer{
+		Addr:     os.Getenv("PILOT_SMTP_ADDR"),
+		From:     os.Getenv("PILOT_SMTP_FROM"),
+		Username: os.Getenv("PILOT_SMTP_USERNAME"),
+		Password: os.Getenv("PILOT_SMTP_PASSWORD"),
+	}
+	if server.Addr == "" {
+		server.Addr = "localhost:25"
+	}
+	if server.From == "" {
+		server.From = "pilot@localhost"
+	}
+	return server
+}
+
+// Email sends events as plain text emails.
+type Email struct {
+	Server SMTPServer
+	To     []string
+}
+
+func (m *Email) Notify(ctx context.Context, e Event) error {
+	host, _, err := net.SplitHostPort(m.Server.Addr)
+	if err != nil {
+		return err
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", m.Server.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.Server.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.Server.Username, m.Server.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.Server.From); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(m.message(e))); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// headerText makes text safe as the value of a header: line breaks, such as
+// those of multi-line step errors, are folded into spaces so they cannot add
+// headers, and non-ASCII text is encoded.
+func headerText(text string) string {
+	return mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(text), " "))
+}
+
+// message renders the headers and body of the email for an event.
+func (m *Email) message(e Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.Server.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerText("[pilot] "+e.Summary()))
+	fmt.Fprintf(&b, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&b, "%s\r\n\r\n", e.Summary())
+	fmt.Fprintf(&b, "Map:          %s (%d)\r\n", e.Map, e.MapID)
+	if e.RunID != 0 {
+		fmt.Fprintf(&b, "Run:          %d\r\n", e.RunID)
+		fmt.Fprintf(&b, "Logical date: %s\r\n", e.LogicalDate.Format(time.RFC3339))
+	}
+	if e.Step != "" {
+		fmt.Fprintf(&b, "Step:         %s\r\n", e.Step)
+	}
+	if e.Attempt != 0 {
+		fmt.Fprintf(&b, "Attempt:      %d\r\n", e.Attempt)
+	}
+	if e.Deadline != nil {
+		fmt.Fprintf(&b, "Deadline:     %s\r\n", e.Deadline.Format(time.RFC3339))
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, "Error:        %s\r\n", e.Error)
+	}
+	return b.String()
+}
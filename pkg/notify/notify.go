@@ -0,0 +1,145 @@
+// Package notify tells the notifiers configured on a map about its step
+// failures and retries, finished runs and missed SLAs.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+// Timeout bounds the delivery of an event to a single notifier.
+const Timeout = 10 * time.Second
+
+// Event is what happened to a map, a run or a step.
+type Event struct {
+	Type        string     `json:"event"` // one of models.Events
+	MapID       int        `json:"map_id"`
+	Map         string     `json:"map"`
+	RunID       int        `json:"run_id,omitempty"`
+	LogicalDate time.Time  `json:"logical_date"`
+	StepID      int        `json:"step_id,omitempty"`
+	Step        string     `json:"step,omitempty"`
+	Attempt     int        `json:"attempt,omitempty"`
+	State       string     `json:"state,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Deadline    *time.Time `json:"deadline,omitempty"` // SLA deadline of sla_missed events
+	Time        time.Time  `json:"time"`
+}
+
+// Summary describes the event in one line, for chat messages and email
+// subjects.
+func (e Event) Summary() string {
+	var s string
+	switch e.Type {
+	case models.EventStepFailed:
+		s = fmt.Sprintf("Step %s of map %s failed after %d attempts", e.Step, e.Map, e.Attempt)
+	case models.EventStepRetry:
+		s = fmt.Sprintf("Step %s of map %s failed on attempt %d, retrying", e.Step, e.Map, e.Attempt)
+	case models.EventRunFailed:
+		s = fmt.Sprintf("Run %d of map %s failed", e.RunID, e.Map)
+	case models.EventRunSuccess:
+		s = fmt.Sprintf("Run %d of map %s succeeded", e.RunID, e.Map)
+	case models.EventSLAMissed:
+		s = fmt.Sprintf("Run %d of map %s missed its SLA", e.RunID, e.Map)
+		if e.Step != "" {
+			s = fmt.Sprintf("Step %s of map %s missed its SLA", e.Step, e.Map)
+		}
+		if e.Deadline != nil {
+			s += " of " + e.Deadline.UTC().Format("2006-01-02 15:04 MST")
+		}
+	default:
+		s = fmt.Sprintf("%s on map %s", e.Type, e.Map)
+	}
+	if e.Error != "" {
+		s += ": " + e.Error
+	}
+	return s
+}
+
+// Notifier delivers events to one destination.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// New returns the notifier for a configuration stored on a map.
+func New(cfg models.Notifier) (Notifier, error) {
+	switch cfg.Type {
+	case models.NotifierWebhook:
+		return &Webhook{URL: cfg.URL}, nil
+	case models.NotifierSlack:
+		return &Slack{URL: cfg.URL}, nil
+	case models.NotifierEmail:
+		return &Email{Server: SMTPFromEnv(), To: cfg.To}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", cfg.Type)
+	}
+}
+
+var pending sync.WaitGroup
+
+// Send delivers an event to the notifiers of its map that want it, in the
+// background. Delivery errors are logged.
+func Send(db *database.DB, e Event) {
+	if db == nil {
+		return
+	}
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		if err := Deliver(context.Background(), db, e); err != nil {
+			slog.Error("Error sending notification", "event", e.Type, "map_id", e.MapID, "run_id", e.RunID, "error", err)
+		}
+	}()
+}
+
+// Wait blocks until the events passed to Send are delivered.
+func Wait() {
+	pending.Wait()
+}
+
+// Deliver sends an event to the notifiers of its map that want it, and
+// returns the first error met. The map name and the logical date of the run
+// are filled in when missing.
+func Deliver(ctx context.Context, db *database.DB, e Event) error {
+	m, err := db.GetMapByID(e.MapID)
+	if err != nil {
+		return err
+	}
+	if e.Map == "" {
+		e.Map = m.Name
+	}
+	if e.RunID != 0 && e.LogicalDate.IsZero() {
+		if run, err := db.GetMapRun(e.RunID); err == nil {
+			e.LogicalDate = run.LogicalDate
+		}
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	var first error
+	for _, cfg := range m.Notifiers {
+		if !cfg.Wants(e.Type) {
+			continue
+		}
+		n, err := New(cfg)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(ctx, Timeout)
+			err = n.Notify(ctx, e)
+			cancel()
+		}
+		if err != nil {
+			slog.Warn("Notifier failed", "type", cfg.Type, "event", e.Type, "map_id", e.MapID, "error", err)
+			if first == nil {
+				first = fmt.Errorf("%s notifier: %w", cfg.Type, err)
+			}
+		}
+	}
+	return first
+}
@@ -0,0 +1,235 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+var failure = Event{
+	Type:    models.EventStepFailed,
+	MapID:   1,
+	Map:     "sales",
+	RunID:   7,
+	StepID:  2,
+	Step:    "load",
+	Attempt: 3,
+	Error:   "exit status 1",
+	Time:    time.Date(2024, 3, 1, 6, 5, 0, 0, time.UTC),
+}
+
+// recorder is a webhook endpoint keeping the bodies it receives.
+func recorder(t *testing.T, status int) (*httptest.Server, chan []byte) {
+	t.Helper()
+	bodies := make(chan []byte, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, bodies
+}
+
+func TestWebhook(t *testing.T) {
+	srv, bodies := recorder(t, http.StatusNoContent)
+	if err := (&Webhook{URL: srv.URL}).Notify(context.Background(), failure); err != nil {
+		t.Fatal(err)
+	}
+	var got Event
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "step_failed" || got.Step != "load" || got.Attempt != 3 || got.Error != "exit status 1" {
+		t.Errorf("webhook received %+v", got)
+	}
+
+	failing, _ := recorder(t, http.StatusBadGateway)
+	if err := (&Webhook{URL: failing.URL}).Notify(context.Background(), failure); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Notify() = %v, want a 502 error", err)
+	}
+}
+
+func TestWebhookURLVariables(t *testing.T) {
+	srv, bodies := recorder(t, http.StatusNoContent)
+	t.Setenv("PILOT_SECRET_KEY", "s3cret")
+	t.Setenv("PILOT_NOTIFY_TOKEN", "t0ken")
+
+	err := (&Webhook{URL: srv.URL + "/hook?key=$PILOT_SECRET_KEY"}).Notify(context.Background(), failure)
+	if err == nil || !strings.Contains(err.Error(), "$PILOT_SECRET_KEY") || strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("Notify() = %v, want $PILOT_SECRET_KEY refused", err)
+	}
+	select {
+	case <-bodies:
+		t.Error("webhook called with a URL referring to PILOT_SECRET_KEY")
+	default:
+	}
+
+	url, err := expandURL(srv.URL+"/hook?token=${PILOT_NOTIFY_TOKEN}", os.Getenv)
+	if err != nil || url != srv.URL+"/hook?token=t0ken" {
+		t.Errorf("expandURL() = %q, %v", url, err)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	srv, bodies := recorder(t, http.StatusOK)
+	t.Setenv("PILOT_NOTIFY_SLACK_HOOK", srv.URL+"/services/T0/B0/secret")
+	if err := (&Slack{URL: "$PILOT_NOTIFY_SLACK_HOOK"}).Notify(context.Background(), failure); err != nil {
+		t.Fatal(err)
+	}
+	var msg slackMessage
+	if err := json.Unmarshal(<-bodies, &msg); err != nil {
+		t.Fatal(err)
+	}
+	want := ":rotating_light: Step load of map sales failed after 3 attempts: exit status 1"
+	if !strings.HasPrefix(msg.Text, want) {
+		t.Errorf("slack text = %q, want prefix %q", msg.Text, want)
+	}
+}
+
+// smtpStub accepts a single mail and returns the envelope and data received.
+func smtpStub(t *testing.T) (string, chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+		var transcript strings.Builder
+		reply("220 stub ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 stub")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				transcript.WriteString(line)
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					transcript.WriteString(line)
+				}
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				received <- transcript.String()
+				return
+			default:
+				reply("502 unsupported")
+			}
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestEmail(t *testing.T) {
+	addr, received := smtpStub(t)
+	email := &Email{Server: SMTPServer{Addr: addr, From: "pilot@example.com"}, To: []string{"ops@example.com", "data@example.com"}}
+	if err := email.Notify(context.Background(), failure); err != nil {
+		t.Fatal(err)
+	}
+	mail := <-received
+	for _, want := range []string{
+		"MAIL FROM:<pilot@example.com>",
+		"RCPT TO:<ops@example.com>",
+		"RCPT TO:<data@example.com>",
+		"Subject: [pilot] Step load of map sales failed after 3 attempts: exit status 1",
+		"Run:          7",
+	} {
+		if !strings.Contains(mail, want) {
+			t.Errorf("mail does not contain %q:\n%s", want, mail)
+		}
+	}
+}
+
+func TestEmailSubjectMultilineError(t *testing.T) {
+	e := failure
+	e.Error = "exit status 1\r\nBcc: attacker@example.com\nX-Injected: yes"
+	msg := (&Email{Server: SMTPServer{From: "pilot@example.com"}, To: []string{"ops@example.com"}}).message(e)
+
+	headers, _, _ := strings.Cut(msg, "\r\n\r\n")
+	want := "Subject: [pilot] Step load of map sales failed after 3 attempts: exit status 1 Bcc: attacker@example.com X-Injected: yes"
+	if !strings.Contains(headers, want+"\r\n") {
+		t.Errorf("headers do not contain %q:\n%s", want, headers)
+	}
+	for _, line := range strings.Split(headers, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") || strings.HasPrefix(line, "X-Injected:") || strings.ContainsAny(line, "\r\n") {
+			t.Errorf("injected header line %q", line)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures, failureBodies := recorder(t, http.StatusOK)
+	everything, everythingBodies := recorder(t, http.StatusOK)
+	m := models.NewMap("sales", "0 6 * * *", time.Time{}, time.Time{}, []models.Step{{Name: "load", Command: "load.py"}})
+	m.Notifiers = []models.Notifier{
+		{Type: models.NotifierWebhook, URL: failures.URL},
+		{Type: models.NotifierWebhook, URL: everything.URL, Events: models.Events},
+	}
+	id, _, err := db.SyncMap(*m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Send(db, Event{Type: models.EventRunSuccess, MapID: id})
+	Send(db, Event{Type: models.EventStepFailed, MapID: id, Step: "load", Error: "boom"})
+	Wait()
+
+	if n := len(failureBodies); n != 1 {
+		t.Errorf("default notifier got %d events, want the failure only", n)
+	}
+	if n := len(everythingBodies); n != 2 {
+		t.Errorf("notifier of every event got %d events, want 2", n)
+	}
+	var got Event
+	if err := json.Unmarshal(<-failureBodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Map != "sales" || got.Time.IsZero() {
+		t.Errorf("event was not completed: %+v", got)
+	}
+
+	failures.Close()
+	if err := Deliver(context.Background(), db, Event{Type: models.EventRunFailed, MapID: id}); err == nil {
+		t.Error("expected the error of the unreachable notifier")
+	}
+}
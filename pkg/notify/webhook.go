@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"pilot/pkg/models"
+)
+
+// Webhook POSTs events as JSON to a URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client // http.DefaultClient when nil
+}
+
+func (h *Webhook) Notify(ctx context.Context, e Event) error {
+	return post(ctx, h.Client, h.URL, e)
+}
+
+// Slack posts events as messages to a Slack compatible incoming webhook.
+type Slack struct {
+	URL    string
+	Client *http.Client // http.DefaultClient when nil
+}
+
+// slackMessage is the body of an incoming webhook call.
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+func (s *Slack) Notify(ctx context.Context, e Event) error {
+	text := ":white_check_mark: " + e.Summary()
+	if e.Type != models.EventRunSuccess {
+		text = ":rotating_light: " + e.Summary()
+	}
+	if e.RunID != 0 {
+		text += fmt.Sprintf(" (logical date %s)", e.LogicalDate.Format("2006-01-02 15:04"))
+	}
+	return post(ctx, s.Client, s.URL, slackMessage{Text: text})
+}
+
+// URLEnvPrefix starts the names of the environment variables expanded in
+// notifier URLs. Other variables are refused, so that a map file cannot send
+// secrets of the scheduler such as PILOT_SECRET_KEY to a URL of its choosing.
+const URLEnvPrefix = "PILOT_NOTIFY_"
+
+// CheckURL reports the first variable of a notifier URL that is not expanded.
+func CheckURL(url string) error {
+	_, err := expandURL(url, func(string) string { return "" })
+	return err
+}
+
+// expandURL expands the $PILOT_NOTIFY_* variables of url with getenv.
+func expandURL(url string, getenv func(string) string) (string, error) {
+	var err error
+	expanded := os.Expand(url, func(name string) string {
+		if !strings.HasPrefix(name, URLEnvPrefix) {
+			if err == nil {
+				err = fmt.Errorf("url refers to $%s, only %s* variables are expanded", name, URLEnvPrefix)
+			}
+			return ""
+		}
+		return getenv(name)
+	})
+	return expanded, err
+}
+
+// post sends body as JSON to url, whose $PILOT_NOTIFY_* variables are
+// expanded first so secret URLs can stay out of map files.
+func post(ctx context.Context, client *http.Client, url string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url, err = expandURL(url, os.Getenv)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pilot")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
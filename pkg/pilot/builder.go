@@ -0,0 +1,255 @@
+// Package pilot provides a fluent API for defining maps in Go code.
+//
+//	id, err := pilot.NewMap("sales").
+//		Schedule("0 6 * * *").
+//		Step("extract", "sales/extract.py").Retries(2, time.Minute).
+//		Step("load", "sales/load.py").After("extract").
+//		Register(db)
+package pilot
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/loader"
+	"pilot/pkg/models"
+)
+
+// MapBuilder accumulates a map definition. Step options such as After and
+// Retries apply to the most recently added step.
+type MapBuilder struct {
+	def  loader.MapDefinition
+	errs []error
+}
+
+// NewMap starts the definition of a map.
+func NewMap(name string) *MapBuilder {
+	return &MapBuilder{def: loader.MapDefinition{Name: name}}
+}
+
+// Schedule sets the cron expression the map runs on.
+func (b *MapBuilder) Schedule(cron string) *MapBuilder {
+	b.def.Schedule = cron
+	return b
+}
+
+// StartDate sets the date from which the map is scheduled.
+func (b *MapBuilder) StartDate(t time.Time) *MapBuilder {
+	b.def.StartDate = t.Format(time.RFC3339)
+	return b
+}
+
+// Paused registers the map without scheduling it.
+func (b *MapBuilder) Paused() *MapBuilder {
+	b.def.Paused = true
+	return b
+}
+
+// Notify sends the given events of the map, or the failures when none are
+// listed, to a notifier of type webhook, slack or email. target is the URL of
+// webhook and slack notifiers and the recipient of email ones.
+func (b *MapBuilder) Notify(kind string, target string, events ...string) *MapBuilder {
+	n := loader.NotifierDefinition{Type: kind, Events: events}
+	if kind == models.NotifierEmail {
+		n.To = []string{target}
+	} else {
+		n.URL = target
+	}
+	b.def.Notify = append(b.def.Notify, n)
+	return b
+}
+
+// SLA requires runs of the map to succeed within d of their logical date,
+// or of the time they were scheduled when from is "schedule". Step SLAs are
+// measured from the same base.
+func (b *MapBuilder) SLA(d time.Duration, from string) *MapBuilder {
+	b.def.SLA = d.String()
+	b.def.SLAFrom = from
+	return b
+}
+
+// Hook runs a hook after the given outcome, models.HookOnSuccess,
+// HookOnFailure or HookOnRetry, of every step of the map.
+func (b *MapBuilder) Hook(on string, hook loader.HookDefinition) *MapBuilder {
+	b.addHook(&b.def.Hooks, on, hook)
+	return b
+}
+
+// OnFiles starts a run of the map for every new file matching pattern, once
+// it has not changed for settle, or models.DefaultFileSettle when zero. When
+// archive is set files are moved there after their run succeeds. A schedule
+// is optional for maps started by files.
+func (b *MapBuilder) OnFiles(pattern string, archive string, settle time.Duration) *MapBuilder {
+	trigger := b.trigger()
+	trigger.Files, trigger.Archive = pattern, archive
+	if settle > 0 {
+		trigger.Settle = settle.String()
+	}
+	return b
+}
+
+// Consumes starts a run of the map once each of the named datasets was
+// updated since the previous run they started. A schedule is optional for
+// maps consuming datasets.
+func (b *MapBuilder) Consumes(datasets ...string) *MapBuilder {
+	trigger := b.trigger()
+	trigger.Datasets = append(trigger.Datasets, datasets...)
+	return b
+}
+
+// Step adds a step running command.
+func (b *MapBuilder) Step(name string, command string) *MapBuilder {
+	b.def.Steps = append(b.def.Steps, loader.StepDefinition{Name: name, Command: command})
+	return b
+}
+
+// Sensor adds a step waiting for the condition of sensor instead of running
+// a command. Step options apply to it as to other steps.
+func (b *MapBuilder) Sensor(name string, sensor loader.SensorDefinition) *MapBuilder {
+	b.def.Steps = append(b.def.Steps, loader.StepDefinition{Name: name, Sensor: &sensor})
+	return b
+}
+
+// After makes the current step depend on the named steps.
+func (b *MapBuilder) After(names ...string) *MapBuilder {
+	if step := b.current("After"); step != nil {
+		step.DependsOn = append(step.DependsOn, names...)
+	}
+	return b
+}
+
+// Retries sets how often the current step is retried and the delay between
+// attempts.
+func (b *MapBuilder) Retries(n int, delay time.Duration) *MapBuilder {
+	if step := b.current("Retries"); step != nil {
+		step.Retries = n
+		step.RetryDelay = delay.String()
+	}
+	return b
+}
+
+// StepSLA requires the current step to complete within d of the SLA base of
+// the map.
+func (b *MapBuilder) StepSLA(d time.Duration) *MapBuilder {
+	if step := b.current("StepSLA"); step != nil {
+		step.SLA = d.String()
+	}
+	return b
+}
+
+// StepHook runs a hook after the given outcome of the current step.
+func (b *MapBuilder) StepHook(on string, hook loader.HookDefinition) *MapBuilder {
+	if step := b.current("StepHook"); step != nil {
+		b.addHook(&step.Hooks, on, hook)
+	}
+	return b
+}
+
+// Command returns a hook running a script, like step commands.
+func Command(script string) loader.HookDefinition {
+	return loader.HookDefinition{Command: script}
+}
+
+// Func returns a hook calling the Go function registered with
+// worker.RegisterHook under name.
+func Func(name string) loader.HookDefinition {
+	return loader.HookDefinition{Func: name}
+}
+
+// WaitFor makes the current step wait for a step of another map, or for a
+// whole run of it when step is empty, whose logical date is the one of the
+// run plus offset.
+func (b *MapBuilder) WaitFor(mapName string, step string, offset time.Duration) *MapBuilder {
+	if current := b.current("WaitFor"); current != nil {
+		wait := loader.WaitDefinition{Map: mapName, Step: step}
+		if offset != 0 {
+			wait.Offset = offset.String()
+		}
+		current.WaitFor = append(current.WaitFor, wait)
+	}
+	return b
+}
+
+// WaitTimeout limits how long the current step waits for its last WaitFor
+// dependency. The step is marked upstream_failed once the timeout passes.
+func (b *MapBuilder) WaitTimeout(timeout time.Duration) *MapBuilder {
+	step := b.current("WaitTimeout")
+	switch {
+	case step == nil:
+	case len(step.WaitFor) == 0:
+		b.errs = append(b.errs, fmt.Errorf("WaitTimeout called before WaitFor on step %q", step.Name))
+	default:
+		step.WaitFor[len(step.WaitFor)-1].Timeout = timeout.String()
+	}
+	return b
+}
+
+// Produces records an update of the named datasets each time the current
+// step completes in a map run.
+func (b *MapBuilder) Produces(datasets ...string) *MapBuilder {
+	if step := b.current("Produces"); step != nil {
+		step.Produces = append(step.Produces, datasets...)
+	}
+	return b
+}
+
+// Connections injects the named connections into the current step.
+func (b *MapBuilder) Connections(names ...string) *MapBuilder {
+	if step := b.current("Connections"); step != nil {
+		step.Connections = append(step.Connections, names...)
+	}
+	return b
+}
+
+// addHook appends a hook to the list of its outcome.
+func (b *MapBuilder) addHook(hooks *loader.Hooks, on string, hook loader.HookDefinition) {
+	switch on {
+	case models.HookOnSuccess:
+		hooks.OnSuccess = append(hooks.OnSuccess, hook)
+	case models.HookOnFailure:
+		hooks.OnFailure = append(hooks.OnFailure, hook)
+	case models.HookOnRetry:
+		hooks.OnRetry = append(hooks.OnRetry, hook)
+	default:
+		b.errs = append(b.errs, fmt.Errorf("unknown hook outcome %q", on))
+	}
+}
+
+// trigger returns the trigger of the map, adding one if needed.
+func (b *MapBuilder) trigger() *loader.TriggerDefinition {
+	if b.def.Trigger == nil {
+		b.def.Trigger = &loader.TriggerDefinition{}
+	}
+	return b.def.Trigger
+}
+
+func (b *MapBuilder) current(option string) *loader.StepDefinition {
+	if len(b.def.Steps) == 0 {
+		b.errs = append(b.errs, fmt.Errorf("%s called before any Step", option))
+		return nil
+	}
+	return &b.def.Steps[len(b.def.Steps)-1]
+}
+
+// Definition validates the map and returns its definition.
+func (b *MapBuilder) Definition() (*loader.MapDefinition, error) {
+	if err := errors.Join(append(b.errs, b.def.Validate())...); err != nil {
+		return nil, fmt.Errorf("map %q: %w", b.def.Name, err)
+	}
+	def := b.def
+	return &def, nil
+}
+
+// Register validates the map and stores it with all of its steps in a single
+// transaction, returning the map ID. Registering a map that already exists
+// updates it in place.
+func (b *MapBuilder) Register(db *database.DB) (int, error) {
+	def, err := b.Definition()
+	if err != nil {
+		return 0, err
+	}
+	id, _, err := loader.Load(db, def)
+	return id, err
+}
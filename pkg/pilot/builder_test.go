@@ -0,0 +1,98 @@
+package pilot
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pilot/internal/database"
+)
+
+func TestRegister(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := NewMap("sales").
+		Schedule("0 6 * * *").
+		Notify("email", "ops@example.com", "run_failed", "run_success").
+		Hook("on_failure", Command("sales/alert.py")).
+		Step("extract", "sales/extract.py").Retries(2, time.Minute).StepHook("on_retry", Func("page")).
+		Step("transform", "sales/transform.py").After("extract").WaitFor("rates", "publish", -24*time.Hour).WaitTimeout(6*time.Hour).
+		Step("load", "sales/load.py").After("extract", "transform").Connections("warehouse").Produces("warehouse.sales").
+		Register(db)
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	steps, err := db.GetStepsByMapID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]int{}
+	for _, step := range steps {
+		ids[step.Name] = step.ID
+	}
+	for _, step := range steps {
+		switch step.Name {
+		case "extract":
+			if step.Retries != 2 || step.RetryDelay != time.Minute {
+				t.Errorf("extract retries = %d, delay = %v", step.Retries, step.RetryDelay)
+			}
+			if len(step.Hooks) != 1 || step.Hooks[0].On != "on_retry" || step.Hooks[0].Func != "page" {
+				t.Errorf("extract hooks = %+v", step.Hooks)
+			}
+		case "transform":
+			if len(step.WaitFor) != 1 || step.WaitFor[0].Map != "rates" || step.WaitFor[0].Offset != -24*time.Hour || step.WaitFor[0].Timeout != 6*time.Hour {
+				t.Errorf("transform waits for %+v", step.WaitFor)
+			}
+		case "load":
+			want := []int{ids["extract"], ids["transform"]}
+			if len(step.Dependencies) != 2 || step.Dependencies[0] != want[0] || step.Dependencies[1] != want[1] {
+				t.Errorf("load dependencies = %v, want %v", step.Dependencies, want)
+			}
+			if len(step.Produces) != 1 || step.Produces[0] != "warehouse.sales" {
+				t.Errorf("load produces = %v", step.Produces)
+			}
+		}
+	}
+
+	m, err := db.GetMapByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Notifiers) != 1 || m.Notifiers[0].To[0] != "ops@example.com" || !m.Notifiers[0].Wants("run_success") {
+		t.Errorf("notifiers = %+v", m.Notifiers)
+	}
+	if len(m.Hooks) != 1 || m.Hooks[0].On != "on_failure" || m.Hooks[0].Command != "sales/alert.py" {
+		t.Errorf("hooks = %+v", m.Hooks)
+	}
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewMap("broken").
+		Schedule("@daily").
+		After("nothing").
+		Hook("on_start", Command("start.py")).
+		Step("a", "a.py").After("b").WaitTimeout(time.Hour).
+		Step("b", "b.py").After("a").
+		Register(db)
+	if err == nil {
+		t.Fatal("Register succeeded for an invalid map")
+	}
+	for _, want := range []string{"After called before any Step", `unknown hook outcome "on_start"`, "WaitTimeout called before WaitFor", "cycle"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if _, err := db.GetMapByName("broken"); err == nil {
+		t.Error("invalid map was stored")
+	}
+}
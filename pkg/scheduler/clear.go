@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"pilot/internal/database"
+	"pilot/pkg/graph"
+	"pilot/pkg/models"
+)
+
+var (
+	// ErrStepNotFound is returned when a step to clear is not part of the run.
+	ErrStepNotFound = errors.New("step not found")
+	// ErrStepActive is returned when a step to clear is queued or running.
+	ErrStepActive = errors.New("step is queued or running")
+)
+
+// ClearOptions selects the steps of a run to clear.
+type ClearOptions struct {
+	Steps      []string // Names of the steps to clear
+	Upstream   bool     // Also clear the steps they depend on
+	Downstream bool     // Also clear the steps that depend on them
+}
+
+// ClearSteps resets steps of a map run to pending so the scheduler executes
+// them again, keeping their previous attempts in history. Nothing is cleared
+// when one of the selected steps is still queued or running. It returns the
+// cleared instances in dependency order.
+func ClearSteps(db *database.DB, runID int, opts ClearOptions) ([]models.StepRun, error) {
+	run, err := db.GetMapRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := db.GetStepsByMapID(run.MapID)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := db.GetStepRuns(run.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]models.Step{}
+	for _, step := range steps {
+		byName[step.Name] = step
+	}
+	selected := map[int]bool{}
+	g := graph.New(steps)
+	for _, name := range opts.Steps {
+		step, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrStepNotFound, name)
+		}
+		selected[step.ID] = true
+		if opts.Upstream {
+			for _, up := range g.Upstream(step.ID) {
+				selected[up.ID] = true
+			}
+		}
+		if opts.Downstream {
+			for _, down := range g.Downstream(step.ID) {
+				selected[down.ID] = true
+			}
+		}
+	}
+
+	byStep := map[int]models.StepRun{}
+	for _, instance := range instances {
+		byStep[instance.StepID] = instance
+	}
+	sorted, err := g.Sort()
+	if err != nil {
+		return nil, err
+	}
+	var cleared []models.StepRun
+	var ids []int
+	for _, step := range sorted {
+		if !selected[step.ID] {
+			continue
+		}
+		instance, ok := byStep[step.ID]
+		if !ok {
+			// The step was added after the run was created
+			if slices.Contains(opts.Steps, step.Name) {
+				return nil, fmt.Errorf("%w in run %d: %s", ErrStepNotFound, run.ID, step.Name)
+			}
+			continue
+		}
+		if instance.State == "queued" || instance.State == "running" {
+			return nil, fmt.Errorf("%w: %s is %s", ErrStepActive, step.Name, instance.State)
+		}
+		instance.State = "pending"
+		cleared = append(cleared, instance)
+		ids = append(ids, step.ID)
+	}
+
+	if err := db.ClearStepRuns(run.ID, ids); err != nil {
+		return nil, err
+	}
+	return cleared, nil
+}
@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClearStepsDownstream(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+
+	// extract completes, transform fails and load never runs
+	s.Tick()
+	finish(t, db, <-s.TaskQueue, "completed")
+	s.Tick()
+	finish(t, db, <-s.TaskQueue, "failed")
+	s.Tick()
+	runs, _ := db.ListMapRuns(ids["map"], 0)
+	runID := runs[0].ID
+
+	cleared, err := ClearSteps(db, runID, ClearOptions{Steps: []string{"transform"}, Downstream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cleared) != 2 || cleared[0].StepName != "transform" || cleared[1].StepName != "load" {
+		t.Fatalf("cleared = %+v, want transform and load", cleared)
+	}
+
+	instances, _ := db.GetStepRuns(runID)
+	want := map[string]string{"extract": "completed", "transform": "pending", "load": "pending"}
+	for _, instance := range instances {
+		if instance.State != want[instance.StepName] {
+			t.Errorf("%s state = %s, want %s", instance.StepName, instance.State, want[instance.StepName])
+		}
+	}
+	history, err := db.GetStepRunAttempts(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].StepName != "transform" || history[0].State != "failed" {
+		t.Errorf("history = %+v, want the failed transform and upstream_failed load", history)
+	}
+	if run, _ := db.GetMapRun(runID); run.State != "running" {
+		t.Errorf("run state = %s, want running", run.State)
+	}
+
+	// The scheduler picks the cleared steps up again
+	s.Tick()
+	step := <-s.TaskQueue
+	if step.ID != ids["transform"] || step.RunID != runID {
+		t.Errorf("queued step %d of run %d, want transform of run %d", step.ID, step.RunID, runID)
+	}
+}
+
+func TestClearStepsUpstream(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+	s.Tick()
+	finish(t, db, <-s.TaskQueue, "completed")
+	s.Tick()
+	runs, _ := db.ListMapRuns(ids["map"], 0)
+
+	// transform is queued, so clearing load with its upstream is refused
+	_, err := ClearSteps(db, runs[0].ID, ClearOptions{Steps: []string{"load"}, Upstream: true})
+	if !errors.Is(err, ErrStepActive) {
+		t.Fatalf("ClearSteps error = %v, want ErrStepActive", err)
+	}
+	if instances, _ := db.GetStepRuns(runs[0].ID); instances[0].State != "completed" {
+		t.Errorf("extract was cleared although the clear was refused")
+	}
+
+	if _, err := ClearSteps(db, runs[0].ID, ClearOptions{Steps: []string{"missing"}}); !errors.Is(err, ErrStepNotFound) {
+		t.Errorf("ClearSteps error = %v, want ErrStepNotFound", err)
+	}
+}
@@ -0,0 +1,30 @@
+package scheduler
+
+import (
+	"log/slog"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+// checkDatasetTrigger starts a run of m once every dataset it consumes was
+// updated since the previous run started by its datasets. The names of the
+// datasets are given to the run as the "datasets" key of its conf, and the
+// updates that started it are recorded with it.
+func (s *Scheduler) checkDatasetTrigger(m models.Map) {
+	datasets := m.Trigger.Datasets
+	events, err := s.db.PendingDatasetEvents(m.ID, datasets)
+	if err != nil {
+		slog.Error("Error getting dataset updates", "map_id", m.ID, "error", err)
+		return
+	}
+	if len(events) < len(datasets) {
+		slog.Debug("Datasets not updated", "map_id", m.ID, "updated", len(events), "datasets", len(datasets))
+		return
+	}
+
+	conf := map[string]any{"datasets": datasets}
+	if _, err := createRun(s.db, m, models.RunTypeDataset, s.nowFunc().Truncate(time.Second), conf, events); err != nil {
+		slog.Warn("Skipping dataset update", "map_id", m.ID, "error", err)
+	}
+}
@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+func TestTickStartsRunOnDatasetUpdates(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+	reports := models.NewMap("reports", "", time.Time{}, time.Time{}, []models.Step{{Name: "build", Command: "build.py"}})
+	reports.Trigger = &models.Trigger{Datasets: []string{"warehouse.sales", "warehouse.returns"}}
+	reportsID, _, err := db.SyncMap(*reports, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := func(dataset string) models.DatasetEvent {
+		t.Helper()
+		e := models.DatasetEvent{Dataset: dataset, MapID: ids["map"], RunID: 1, StepID: ids["load"]}
+		if err := db.RecordDatasetEvent(&e); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	runs := func() []models.MapRun {
+		t.Helper()
+		runs, err := db.ListMapRuns(reportsID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return runs
+	}
+
+	s.Tick()
+	update("warehouse.sales")
+	s.Tick()
+	if n := len(runs()); n != 0 {
+		t.Fatalf("%d runs before warehouse.returns was updated", n)
+	}
+
+	update("warehouse.returns")
+	s.Tick()
+	s.Tick()
+	first := runs()
+	if len(first) != 1 || first[0].RunType != models.RunTypeDataset {
+		t.Fatalf("runs once both datasets were updated = %+v", first)
+	}
+	if events, err := db.GetRunDatasetEvents(first[0].ID); err != nil || len(events) != 2 {
+		t.Errorf("dataset updates of the run = %+v, %v", events, err)
+	}
+
+	// Both datasets must be updated again for the next run
+	update("warehouse.sales")
+	latest := update("warehouse.sales")
+	s.Tick()
+	if n := len(runs()); n != 1 {
+		t.Fatalf("%d runs after warehouse.sales alone was updated again", n)
+	}
+	update("warehouse.returns")
+	s.Tick()
+	second := runs()
+	if len(second) != 2 {
+		t.Fatalf("%d runs after both datasets were updated again", len(second))
+	}
+	events, err := db.GetRunDatasetEvents(second[0].ID)
+	if err != nil || len(events) != 2 || events[1].ID != latest.ID {
+		t.Errorf("dataset updates of the second run = %+v, %v, want the latest warehouse.sales update %d", events, err, latest.ID)
+	}
+}
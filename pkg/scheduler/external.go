@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+// ExternalWaits returns the external dependencies of a step that are not met
+// in a run: the steps of other maps that did not complete, or were not
+// skipped, and the runs of other maps that did not succeed, for the logical
+// date of the run offset by the dependency. A failed dependency keeps the
+// step waiting, as it may be cleared and run again.
+func ExternalWaits(db *database.DB, run models.MapRun, step models.Step) ([]models.ExternalWait, error) {
+	var waits []models.ExternalWait
+	for _, dep := range step.WaitFor {
+		wait := models.ExternalWait{ExternalDependency: dep, LogicalDate: run.LogicalDate.Add(dep.Offset)}
+		met, err := externalMet(db, &wait)
+		if err != nil {
+			return nil, err
+		}
+		if !met {
+			waits = append(waits, wait)
+		}
+	}
+	return waits, nil
+}
+
+// RunWaits returns what the pending steps of a run wait for in other maps.
+func RunWaits(db *database.DB, run models.MapRun) ([]models.ExternalWait, error) {
+	steps, err := db.GetStepsByMapID(run.MapID)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := db.GetStepRuns(run.ID)
+	if err != nil {
+		return nil, err
+	}
+	pending := map[int]bool{}
+	for _, instance := range instances {
+		pending[instance.StepID] = instance.State == "pending"
+	}
+
+	var waits []models.ExternalWait
+	for _, step := range steps {
+		if !pending[step.ID] || len(step.WaitFor) == 0 {
+			continue
+		}
+		stepWaits, err := ExternalWaits(db, run, step)
+		if err != nil {
+			return nil, err
+		}
+		for _, wait := range stepWaits {
+			wait.StepName = step.Name
+			waits = append(waits, wait)
+		}
+	}
+	return waits, nil
+}
+
+// expiredWait returns the first of the unmet waits of a step whose timeout
+// passed. The timeout counts from when the depends_on steps of the step were
+// done, or from the start of the run for steps without any.
+func expiredWait(run models.MapRun, step models.Step, instances map[int]models.StepRun, waits []models.ExternalWait,
+	now time.Time) *models.ExternalWait {
+	since := run.StartDate
+	for _, depID := range step.Dependencies {
+		if dep, ok := instances[depID]; ok && dep.EndDate.After(since) {
+			since = dep.EndDate
+		}
+	}
+	for i, wait := range waits {
+		if wait.Timeout > 0 && now.Sub(since) >= wait.Timeout {
+			return &waits[i]
+		}
+	}
+	return nil
+}
+
+// externalMet reports whether an external dependency is met, filling in the
+// run and state of what it waits for.
+func externalMet(db *database.DB, wait *models.ExternalWait) (bool, error) {
+	m, err := db.GetMapByName(wait.Map)
+	if errors.Is(err, sql.ErrNoRows) {
+		wait.State = models.ExternalUnknownMap
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	run, err := db.GetMapRunByLogicalDate(m.ID, wait.LogicalDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		wait.State = models.ExternalNoRun
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	wait.RunID = run.ID
+
+	if wait.Step == "" {
+		wait.State = run.State
+		return run.State == "success", nil
+	}
+	instances, err := db.GetStepRuns(run.ID)
+	if err != nil {
+		return false, err
+	}
+	wait.State = models.ExternalUnknownStep
+	for _, instance := range instances {
+		if instance.StepName == wait.Step {
+			wait.State = instance.State
+			break
+		}
+	}
+	return wait.State == "completed" || wait.State == "skipped", nil
+}
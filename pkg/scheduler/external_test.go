@@ -0,0 +1,124 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+func TestTickWaitsForOtherMaps(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+	reports := models.NewMap("reports", "0 7 * * *", time.Time{}, time.Time{}, []models.Step{
+		{Name: "build", Command: "build.py", WaitFor: []models.ExternalDependency{{Map: "sales", Step: "load"}}},
+	})
+	reportsID, _, err := db.SyncMap(*reports, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Tick()
+	runs, err := db.ListMapRuns(reportsID, 0)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("reports runs = %v, %v", runs, err)
+	}
+	steps, err := db.GetStepsByMapID(reportsID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waits, err := ExternalWaits(db, runs[0], steps[0])
+	if err != nil || len(waits) != 1 || waits[0].State != "pending" || !waits[0].LogicalDate.Equal(runs[0].LogicalDate) {
+		t.Fatalf("waits = %+v, %v, want sales load pending", waits, err)
+	}
+	if waits, err := RunWaits(db, runs[0]); err != nil || len(waits) != 1 || waits[0].StepName != "build" {
+		t.Errorf("RunWaits() = %+v, %v", waits, err)
+	}
+
+	for _, name := range []string{"extract", "transform", "load"} {
+		step := <-s.TaskQueue
+		if step.ID != ids[name] {
+			t.Fatalf("queued step %d, want sales %s", step.ID, name)
+		}
+		finish(t, db, step, "completed")
+		s.Tick()
+	}
+	select {
+	case step := <-s.TaskQueue:
+		if step.MapID != reportsID || step.RunID != runs[0].ID {
+			t.Errorf("queued step %d of run %d, want reports build", step.ID, step.RunID)
+		}
+	default:
+		t.Fatal("reports build was not queued once sales load completed")
+	}
+
+	tests := []struct {
+		dep  models.ExternalDependency
+		want string
+	}{
+		{models.ExternalDependency{Map: "sales"}, ""},
+		{models.ExternalDependency{Map: "sales", Step: "load", Offset: -24 * time.Hour}, models.ExternalNoRun},
+		{models.ExternalDependency{Map: "sales", Step: "publish"}, models.ExternalUnknownStep},
+		{models.ExternalDependency{Map: "returns"}, models.ExternalUnknownMap},
+	}
+	for _, tt := range tests {
+		waits, err := ExternalWaits(db, runs[0], models.Step{WaitFor: []models.ExternalDependency{tt.dep}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if len(waits) > 0 {
+			got = waits[0].State
+		}
+		if got != tt.want {
+			t.Errorf("ExternalWaits(%+v) state = %q, want %q", tt.dep, got, tt.want)
+		}
+	}
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	s, db, _ := newTestScheduler(t)
+	reports := models.NewMap("reports", "0 7 * * *", time.Time{}, time.Time{}, []models.Step{
+		{Name: "build", Command: "build.py", WaitFor: []models.ExternalDependency{{Map: "sales", Step: "load", Timeout: time.Hour}}},
+		{Name: "publish", Command: "publish.py"},
+	})
+	reportsID, _, err := db.SyncMap(*reports, map[string][]string{"publish": {"build"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports.ID = reportsID
+	run, err := CreateRun(db, *reports, "manual", time.Date(2024, time.March, 1, 7, 0, 0, 0, time.UTC), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := func() map[string]string {
+		t.Helper()
+		instances, err := db.GetStepRuns(run.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states := map[string]string{}
+		for _, instance := range instances {
+			states[instance.StepName] = instance.State
+		}
+		return states
+	}
+
+	s.SetNowFunc(func() time.Time { return run.StartDate.Add(59 * time.Minute) })
+	if err := s.advanceRun(*run); err != nil {
+		t.Fatal(err)
+	}
+	if got := states()["build"]; got != "pending" {
+		t.Fatalf("build is %s before its timeout, want pending", got)
+	}
+
+	s.SetNowFunc(func() time.Time { return run.StartDate.Add(time.Hour) })
+	if err := s.advanceRun(*run); err != nil {
+		t.Fatal(err)
+	}
+	if got := states(); got["build"] != "upstream_failed" || got["publish"] != "upstream_failed" {
+		t.Errorf("states after the timeout = %v, want build and publish upstream_failed", got)
+	}
+	if finished, err := db.GetMapRun(run.ID); err != nil || finished.State != "failed" {
+		t.Errorf("run = %+v, %v, want failed", finished, err)
+	}
+}
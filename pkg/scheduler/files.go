@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+// checkFileTrigger starts a run of m for every file matching its trigger
+// that was not seen before. Files are only picked up once they have not been
+// modified for the settle time of the trigger, so that files still being
+// written are left alone. The path of the file is given to the run as the
+// "file" key of its conf, and its modification time as logical date.
+func (s *Scheduler) checkFileTrigger(m models.Map) {
+	matches, err := filepath.Glob(triggerPath(m.Trigger.Files))
+	if err != nil {
+		slog.Error("Invalid file trigger pattern", "map_id", m.ID, "files", m.Trigger.Files, "error", err)
+		return
+	}
+
+	now := s.nowFunc()
+	settle := m.Trigger.Settle
+	if settle <= 0 {
+		settle = models.DefaultFileSettle
+	}
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if now.Sub(info.ModTime()) < settle {
+			slog.Debug("File is not settled", "map_id", m.ID, "path", path)
+			continue
+		}
+
+		f := &models.TriggerFile{MapID: m.ID, Path: path, Size: info.Size(), ModTime: info.ModTime()}
+		added, err := s.db.RecordTriggerFile(f)
+		if err != nil {
+			slog.Error("Error recording trigger file", "map_id", m.ID, "path", path, "error", err)
+			continue
+		}
+		if !added {
+			continue
+		}
+
+		logicalDate, err := s.fileLogicalDate(m, info.ModTime())
+		if err != nil {
+			slog.Error("Error choosing the logical date of a trigger file", "map_id", m.ID, "path", path, "error", err)
+			continue
+		}
+		run, err := CreateRun(s.db, m, models.RunTypeFile, logicalDate, map[string]any{"file": path})
+		if err != nil {
+			slog.Warn("Skipping trigger file", "map_id", m.ID, "path", path, "error", err)
+			// Forget the file so that it is picked up again on the next tick
+			if err := s.db.DeleteTriggerFile(f.ID); err != nil {
+				slog.Error("Error deleting trigger file", "map_id", m.ID, "path", path, "error", err)
+			}
+			continue
+		}
+		if err := s.db.SetTriggerFileRun(f.ID, run.ID); err != nil {
+			slog.Error("Error recording the run of a trigger file", "run_id", run.ID, "path", path, "error", err)
+		}
+	}
+}
+
+// fileLogicalDate returns the modification time of a trigger file to the
+// second, moved a second later while another run of m has that logical date,
+// so that the runs of files landing together can be told apart.
+func (s *Scheduler) fileLogicalDate(m models.Map, modTime time.Time) (time.Time, error) {
+	date := modTime.UTC().Truncate(time.Second)
+	for {
+		_, err := s.db.GetMapRunByLogicalDate(m.ID, date)
+		if errors.Is(err, sql.ErrNoRows) {
+			return date, nil
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		date = date.Add(time.Second)
+	}
+}
+
+// archiveTriggerFile moves the file that started a successful run to the
+// archive directory of the trigger of its map, if it has one. A number is
+// added to the name of the file when the archive already holds one by that
+// name.
+func archiveTriggerFile(db *database.DB, m models.Map, run models.MapRun) error {
+	if run.RunType != models.RunTypeFile || m.Trigger == nil || m.Trigger.Archive == "" {
+		return nil
+	}
+	f, err := db.GetRunTriggerFile(run.ID)
+	if err != nil {
+		return fmt.Errorf("getting trigger file: %w", err)
+	}
+
+	dir := triggerPath(m.Trigger.Archive)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Base(f.Path)
+	ext := filepath.Ext(name)
+	to := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(to); os.IsNotExist(err) {
+			break
+		}
+		to = filepath.Join(dir, fmt.Sprintf("%s.%d%s", strings.TrimSuffix(name, ext), i, ext))
+	}
+	if err := os.Rename(f.Path, to); err != nil {
+		return err
+	}
+	slog.Info("Archived trigger file", "map_id", m.ID, "run_id", run.ID, "path", f.Path, "to", to)
+	return db.SetTriggerFileArchived(f.ID, to)
+}
+
+// triggerPath resolves a relative path of a trigger against PROJECT_PATH,
+// like the paths of file sensors.
+func triggerPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(os.Getenv("PROJECT_PATH"), path)
+}
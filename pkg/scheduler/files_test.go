@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+func TestTickStartsRunPerTriggerFile(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+	dir := t.TempDir()
+	m, err := db.GetMapByID(ids["map"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ScheduleInterval = ""
+	m.Trigger = &models.Trigger{Files: filepath.Join(dir, "*.csv"), Archive: filepath.Join(dir, "done"), Settle: time.Minute}
+	if err := db.UpdateMap(*m); err != nil {
+		t.Fatal(err)
+	}
+
+	// sales.csv has settled, while returns.csv is still being written
+	write := func(name string, modTime time.Time) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	sales := write("sales.csv", time.Date(2024, time.March, 1, 6, 0, 0, 0, time.UTC))
+	write("returns.csv", time.Date(2024, time.March, 1, 6, 59, 30, 0, time.UTC))
+	if err := os.MkdirAll(filepath.Join(dir, "done"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write("done/sales.csv", time.Time{})
+
+	s.Tick()
+	s.Tick()
+	runs, err := db.ListMapRuns(ids["map"], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].RunType != models.RunTypeFile || runs[0].Conf["file"] != sales {
+		t.Fatalf("runs after two ticks = %+v, want a single run for %s", runs, sales)
+	}
+
+	for range []string{"extract", "transform", "load"} {
+		finish(t, db, <-s.TaskQueue, "completed")
+		s.Tick()
+	}
+	archived := filepath.Join(dir, "done", "sales.1.csv")
+	if _, err := os.Stat(archived); err != nil {
+		t.Errorf("file not archived: %v", err)
+	}
+	files, err := db.ListTriggerFiles(ids["map"], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].RunID != runs[0].ID || files[0].ArchivedTo != archived {
+		t.Errorf("trigger files = %+v", files)
+	}
+
+	// returns.csv settles
+	s.SetNowFunc(func() time.Time { return time.Date(2024, time.March, 1, 7, 5, 0, 0, time.UTC) })
+	s.Tick()
+	if runs, _ := db.ListMapRuns(ids["map"], 0); len(runs) != 2 {
+		t.Errorf("%d runs, want one more for returns.csv", len(runs))
+	}
+}
+
+func TestTickGivesTriggerFilesTheirOwnLogicalDate(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+	dir := t.TempDir()
+	m, err := db.GetMapByID(ids["map"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ScheduleInterval = ""
+	m.Trigger = &models.Trigger{Files: filepath.Join(dir, "*.csv"), Settle: time.Minute}
+	if err := db.UpdateMap(*m); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both files land within the same second
+	landed := time.Date(2024, time.March, 1, 6, 30, 0, 0, time.UTC)
+	for i, name := range []string{"eu.csv", "us.csv"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := landed.Add(time.Duration(i) * time.Millisecond)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.Tick()
+	runs, err := db.ListMapRuns(ids["map"], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("%d runs, want one per file", len(runs))
+	}
+	dates := map[string]time.Time{}
+	for _, run := range runs {
+		dates[filepath.Base(run.Conf["file"].(string))] = run.LogicalDate.UTC()
+	}
+	if !dates["eu.csv"].Equal(landed) || !dates["us.csv"].Equal(landed.Add(time.Second)) {
+		t.Errorf("logical dates = %v, want %v and a second later", dates, landed)
+	}
+}
@@ -0,0 +1,121 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+
+	"pilot/internal/database"
+	"pilot/pkg/graph"
+	"pilot/pkg/models"
+)
+
+// ErrInvalidMark is returned when a step is marked with an unsupported state.
+var ErrInvalidMark = errors.New("state must be success, failed or skipped")
+
+// markStates maps the states an operator can force to step instance states.
+var markStates = map[string]string{
+	"success": "completed",
+	"failed":  "failed",
+	"skipped": "skipped",
+}
+
+// MarkOptions describes a state forced on a step instance.
+type MarkOptions struct {
+	Step  string // Name of the step
+	State string // success, failed or skipped
+	By    string // Who forced the state
+	Note  string // Why, kept in the audit trail
+}
+
+// MarkStep forces the state of a step instance without running it, for
+// example to unblock its dependents when an upstream feed is known bad. The
+// dependents are re-evaluated at once: those that were upstream_failed are
+// reset to pending when nothing upstream failed anymore, and pending ones
+// become upstream_failed when the step is marked failed. The scheduler then
+// queues the ones that are ready.
+func MarkStep(db *database.DB, runID int, opts MarkOptions) (*models.StepRunMark, error) {
+	state, ok := markStates[opts.State]
+	if !ok {
+		return nil, fmt.Errorf("%w, not %q", ErrInvalidMark, opts.State)
+	}
+	run, err := db.GetMapRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := db.GetStepsByMapID(run.MapID)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := db.GetStepRuns(run.ID)
+	if err != nil {
+		return nil, err
+	}
+	byStep := map[int]models.StepRun{}
+	for _, instance := range instances {
+		byStep[instance.StepID] = instance
+	}
+
+	var target *models.StepRun
+	for _, step := range steps {
+		if instance, ok := byStep[step.ID]; ok && step.Name == opts.Step {
+			target = &instance
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("%w in run %d: %s", ErrStepNotFound, run.ID, opts.Step)
+	}
+	if target.State == "queued" || target.State == "running" {
+		return nil, fmt.Errorf("%w: %s is %s", ErrStepActive, target.StepName, target.State)
+	}
+
+	mark := &models.StepRunMark{
+		RunID:         run.ID,
+		StepID:        target.StepID,
+		StepName:      target.StepName,
+		PreviousState: target.State,
+		State:         state,
+		MarkedBy:      opts.By,
+		Note:          opts.Note,
+	}
+	if err := db.MarkStepRun(mark); err != nil {
+		return nil, err
+	}
+	target.State = state
+	byStep[target.StepID] = *target
+
+	g := graph.New(steps)
+	for _, step := range g.Downstream(target.StepID) {
+		instance, ok := byStep[step.ID]
+		if !ok || (instance.State != "pending" && instance.State != "upstream_failed") {
+			continue
+		}
+		next := "pending"
+		if readiness(step, byStep) == "upstream_failed" {
+			next = "upstream_failed"
+		}
+		if next == instance.State {
+			continue
+		}
+		if _, err := db.TransitionStepRun(run.ID, step.ID, instance.State, next); err != nil {
+			return nil, err
+		}
+		instance.State = next
+		byStep[step.ID] = instance
+	}
+
+	sorted, err := g.Sort()
+	if err != nil {
+		return nil, err
+	}
+	if runState, finished := outcome(sorted, byStep); runState != run.State && run.State != "cancelled" {
+		if finished {
+			err = finishRun(db, *run, runState)
+		} else {
+			err = db.SetMapRunState(run.ID, runState)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mark, nil
+}
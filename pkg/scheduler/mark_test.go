@@ -0,0 +1,71 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMarkStepUnblocksDependents(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+
+	// extract fails, so transform and load are upstream_failed
+	s.Tick()
+	finish(t, db, <-s.TaskQueue, "failed")
+	s.Tick()
+	runs, _ := db.ListMapRuns(ids["map"], 0)
+	runID := runs[0].ID
+
+	mark, err := MarkStep(db, runID, MarkOptions{Step: "extract", State: "skipped", By: "alice", Note: "feed known bad"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mark.PreviousState != "failed" || mark.State != "skipped" {
+		t.Errorf("mark = %+v", mark)
+	}
+
+	instances, _ := db.GetStepRuns(runID)
+	want := map[string]string{"extract": "skipped", "transform": "pending", "load": "pending"}
+	for _, instance := range instances {
+		if instance.State != want[instance.StepName] {
+			t.Errorf("%s state = %s, want %s", instance.StepName, instance.State, want[instance.StepName])
+		}
+	}
+	if run, _ := db.GetMapRun(runID); run.State != "running" {
+		t.Errorf("run state = %s, want running", run.State)
+	}
+
+	s.Tick()
+	if step := <-s.TaskQueue; step.ID != ids["transform"] {
+		t.Errorf("queued step %d, want transform", step.ID)
+	}
+
+	marks, err := db.GetStepRunMarks(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(marks) != 1 || marks[0].MarkedBy != "alice" || marks[0].Note != "feed known bad" {
+		t.Errorf("marks = %+v", marks)
+	}
+}
+
+func TestMarkStepFailed(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+	s.Tick()
+	runs, _ := db.ListMapRuns(ids["map"], 0)
+
+	// extract is queued and cannot be marked
+	if _, err := MarkStep(db, runs[0].ID, MarkOptions{Step: "extract", State: "failed", By: "alice", Note: "x"}); !errors.Is(err, ErrStepActive) {
+		t.Fatalf("MarkStep error = %v, want ErrStepActive", err)
+	}
+	if _, err := MarkStep(db, runs[0].ID, MarkOptions{Step: "load", State: "done", By: "alice", Note: "x"}); !errors.Is(err, ErrInvalidMark) {
+		t.Fatalf("MarkStep error = %v, want ErrInvalidMark", err)
+	}
+
+	if _, err := MarkStep(db, runs[0].ID, MarkOptions{Step: "transform", State: "failed", By: "alice", Note: "source gone"}); err != nil {
+		t.Fatal(err)
+	}
+	instances, _ := db.GetStepRuns(runs[0].ID)
+	if instances[2].State != "upstream_failed" {
+		t.Errorf("load state = %s, want upstream_failed", instances[2].State)
+	}
+}
@@ -0,0 +1,276 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"pilot/internal/database"
+	"pilot/internal/tracing"
+	"pilot/pkg/graph"
+	"pilot/pkg/models"
+	"pilot/pkg/notify"
+)
+
+// CreateRun validates the graph of a map and starts a run of it for the
+// given logical date, with a pending instance of each step. conf is made
+// available to the step commands of the run.
+func CreateRun(db *database.DB, m models.Map, runType string, logicalDate time.Time, conf map[string]any) (*models.MapRun, error) {
+	return createRun(db, m, runType, logicalDate, conf, nil)
+}
+
+// createRun is CreateRun, also recording the dataset updates that started the
+// run in the same transaction when there are any.
+func createRun(db *database.DB, m models.Map, runType string, logicalDate time.Time, conf map[string]any,
+	events []models.DatasetEvent) (*models.MapRun, error) {
+	steps, err := db.GetStepsByMapID(m.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("map has no steps")
+	}
+	if err := graph.Validate(steps, db.GetStepByID); err != nil {
+		return nil, err
+	}
+
+	run := &models.MapRun{MapID: m.ID, RunType: runType, LogicalDate: logicalDate, Conf: conf, TraceParent: tracing.NewTraceParent()}
+	if len(events) > 0 {
+		_, err = db.CreateDatasetRun(run, steps, events)
+	} else {
+		_, err = db.CreateMapRun(run, steps)
+	}
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Created map run", "map_id", m.ID, "run_id", run.ID, "run_type", runType, "logical_date", logicalDate)
+	return run, nil
+}
+
+// EstimateMap computes how long a run of a map takes, and which steps bound
+// it, from the average durations of the past executions of its steps. It
+// returns nil when none of the steps has completed yet.
+func EstimateMap(db *database.DB, mapID int, steps []models.Step) (*graph.Estimate, error) {
+	durations, err := db.StepDurations(mapID)
+	if err != nil || len(durations) == 0 {
+		return nil, err
+	}
+	return graph.New(steps).Estimate(durations)
+}
+
+// logicalDate returns the schedule time a new run of m covers: the latest
+// schedule time that has passed since the previous run. Missed intervals are
+// skipped rather than run one after another.
+func (s *Scheduler) logicalDate(m models.Map) time.Time {
+	now := s.nowFunc()
+	if m.LastRun.IsZero() {
+		return now.Truncate(time.Minute)
+	}
+	schedule, err := cron.ParseStandard(m.ScheduleInterval)
+	if err != nil {
+		return now.Truncate(time.Minute)
+	}
+
+	next := schedule.Next(m.LastRun)
+	for n := schedule.Next(next); !n.After(now); n = schedule.Next(n) {
+		next = n
+	}
+	return next
+}
+
+// advanceRun queues the pending steps of a run whose dependencies completed,
+// including the steps of other maps they wait for, marks the ones whose
+// dependencies failed or whose waits on other maps timed out, queues the
+// rescheduled sensors that are due for their next poke, and finishes the run
+// once every step is done.
+func (s *Scheduler) advanceRun(run models.MapRun) error {
+	steps, err := s.db.GetStepsByMapID(run.MapID)
+	if err != nil {
+		return err
+	}
+	instances, err := s.db.GetStepRuns(run.ID)
+	if err != nil {
+		return err
+	}
+	sorted, err := graph.Sort(steps)
+	if err != nil {
+		return err
+	}
+
+	byStep := map[int]models.StepRun{}
+	for _, instance := range instances {
+		byStep[instance.StepID] = instance
+	}
+
+	for _, step := range sorted {
+		instance, ok := byStep[step.ID]
+		if !ok {
+			// The step was added after the run was created
+			continue
+		}
+
+		if instance.State == "pending" {
+			next := readiness(step, byStep)
+			if next == "queued" && len(step.WaitFor) > 0 {
+				waits, err := ExternalWaits(s.db, run, step)
+				if err != nil {
+					return err
+				}
+				if expired := expiredWait(run, step, byStep, waits, s.nowFunc()); expired != nil {
+					slog.Warn("Gave up waiting on another map", "map_id", run.MapID, "run_id", run.ID, "step_id", step.ID,
+						"map", expired.Map, "step", expired.Step, "timeout", expired.Timeout, "state", expired.State)
+					next = "upstream_failed"
+				} else if len(waits) > 0 {
+					slog.Debug("Waiting on other maps", "map_id", run.MapID, "run_id", run.ID, "step_id", step.ID, "waits", len(waits))
+					next = ""
+				}
+			}
+			if next != "" {
+				moved, err := s.db.TransitionStepRun(run.ID, step.ID, "pending", next)
+				if err != nil {
+					return err
+				}
+				if moved {
+					instance.State = next
+					byStep[step.ID] = instance
+					if next == "queued" {
+						step.RunID = run.ID
+						step.State = "queued"
+						step.Attempt = instance.Attempt
+						s.QueueTask(step)
+					}
+				}
+			}
+		}
+
+		if instance.State == "up_for_reschedule" {
+			if err := s.requeueSensor(run, step, instance); err != nil {
+				return err
+			}
+		}
+	}
+
+	state, finished := outcome(sorted, byStep)
+	if !finished {
+		return nil
+	}
+	return finishRun(s.db, run, state)
+}
+
+// requeueSensor queues a rescheduled sensor again once its next poke is due.
+func (s *Scheduler) requeueSensor(run models.MapRun, step models.Step, instance models.StepRun) error {
+	at, err := s.db.StepRunRescheduleAt(run.ID, step.ID)
+	if err != nil {
+		return err
+	}
+	if at.After(s.nowFunc()) {
+		return nil
+	}
+	moved, err := s.db.TransitionStepRun(run.ID, step.ID, "up_for_reschedule", "queued")
+	if err != nil || !moved {
+		return err
+	}
+	step.RunID = run.ID
+	step.State = "queued"
+	step.Attempt = instance.Attempt
+	s.QueueTask(step)
+	return nil
+}
+
+// finishRun records the final state of a run, exports its trace and tells
+// the notifiers of the map.
+func finishRun(db *database.DB, run models.MapRun, state string) error {
+	if err := db.SetMapRunState(run.ID, state); err != nil {
+		return err
+	}
+	slog.Info("Map run finished", "map_id", run.MapID, "run_id", run.ID, "state", state)
+
+	finished, err := db.GetMapRun(run.ID)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprint(run.MapID)
+	if m, err := db.GetMapByID(run.MapID); err == nil {
+		name = m.Name
+		if state == "success" {
+			if err := archiveTriggerFile(db, *m, *finished); err != nil {
+				slog.Error("Error archiving trigger file", "map_id", run.MapID, "run_id", run.ID, "error", err)
+			}
+		}
+	}
+	tracing.EndRun(*finished, name)
+
+	event := models.EventRunFailed
+	switch state {
+	case "success":
+		event = models.EventRunSuccess
+	case "cancelled":
+		return nil
+	}
+	notify.Send(db, notify.Event{Type: event, MapID: run.MapID, Map: name, RunID: run.ID, LogicalDate: run.LogicalDate, State: state})
+	return nil
+}
+
+// outcome returns the state of a run given the instances of its steps, and
+// whether all of them are done.
+func outcome(steps []models.Step, instances map[int]models.StepRun) (string, bool) {
+	state := "success"
+	for _, step := range steps {
+		instance, ok := instances[step.ID]
+		if !ok {
+			continue
+		}
+		switch instance.State {
+		case "failed", "upstream_failed", "cancelled":
+			state = "failed"
+		case "completed", "skipped":
+		default:
+			return "running", false
+		}
+	}
+	return state, true
+}
+
+// readiness returns the state a pending step moves to given the state of its
+// dependencies: queued when they all succeeded, upstream_failed when one of
+// them failed, or "" while some are still to run.
+func readiness(step models.Step, instances map[int]models.StepRun) string {
+	ready := true
+	for _, depID := range step.Dependencies {
+		dep, ok := instances[depID]
+		if !ok {
+			continue
+		}
+		switch dep.State {
+		case "completed", "skipped":
+		case "failed", "upstream_failed", "cancelled":
+			return "upstream_failed"
+		default:
+			ready = false
+		}
+	}
+	if ready {
+		return "queued"
+	}
+	return ""
+}
+
+// NextRunTime returns when the next scheduled run of m is due, based on its
+// LastRun, or on its StartDate when it has not run yet.
+func NextRunTime(m models.Map) (time.Time, error) {
+	schedule, err := cron.ParseStandard(m.ScheduleInterval)
+	if err != nil {
+		return time.Time{}, err
+	}
+	base := m.LastRun
+	if base.IsZero() {
+		if m.StartDate.IsZero() {
+			return time.Now().Truncate(time.Minute), nil
+		}
+		base = m.StartDate.Add(-time.Nanosecond)
+	}
+	return schedule.Next(base), nil
+}
@@ -0,0 +1,159 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+)
+
+// newTestScheduler returns a scheduler over a fresh database holding the map
+// extract -> transform -> load, and the IDs of its steps by name.
+func newTestScheduler(t *testing.T) (*Scheduler, *database.DB, map[string]int) {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := models.NewMap("sales", "0 6 * * *", time.Time{}, time.Time{}, []models.Step{
+		{Name: "extract", Command: "extract.py"},
+		{Name: "transform", Command: "transform.py"},
+		{Name: "load", Command: "load.py"},
+	})
+	mapID, _, err := db.SyncMap(*m, map[string][]string{"transform": {"extract"}, "load": {"transform"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps, err := db.GetStepsByMapID(mapID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]int{"map": mapID}
+	for _, step := range steps {
+		ids[step.Name] = step.ID
+	}
+
+	s := NewScheduler(db, 10)
+	s.SetNowFunc(func() time.Time { return time.Date(2024, time.March, 1, 7, 0, 0, 0, time.UTC) })
+	return s, db, ids
+}
+
+func finish(t *testing.T, db *database.DB, step models.Step, state string) {
+	t.Helper()
+	step.State = state
+	if err := db.SetStepRunState(step); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTickRunsStepsInOrder(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+
+	s.Tick()
+	runs, err := db.ListMapRuns(ids["map"], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].RunType != "scheduled" || runs[0].State != "running" {
+		t.Fatalf("runs after first tick = %+v", runs)
+	}
+	if want := time.Date(2024, time.March, 1, 7, 0, 0, 0, time.UTC); !runs[0].LogicalDate.Equal(want) {
+		t.Errorf("logical date = %v, want %v", runs[0].LogicalDate, want)
+	}
+
+	for _, name := range []string{"extract", "transform", "load"} {
+		select {
+		case step := <-s.TaskQueue:
+			if step.ID != ids[name] || step.RunID != runs[0].ID {
+				t.Fatalf("queued step %d of run %d, want %s", step.ID, step.RunID, name)
+			}
+			finish(t, db, step, "completed")
+		default:
+			t.Fatalf("%s was not queued", name)
+		}
+		s.Tick()
+	}
+
+	run, err := db.GetMapRun(runs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.State != "success" {
+		t.Errorf("run state = %s, want success", run.State)
+	}
+	if runs, _ := db.ListMapRuns(ids["map"], 0); len(runs) != 1 {
+		t.Errorf("%d runs created, want the next one only at the next schedule time", len(runs))
+	}
+}
+
+func TestTickMarksUpstreamFailed(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+
+	s.Tick()
+	step := <-s.TaskQueue
+	finish(t, db, step, "failed")
+	s.Tick()
+
+	runs, _ := db.ListMapRuns(ids["map"], 0)
+	instances, err := db.GetStepRuns(runs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, instance := range instances {
+		want := "upstream_failed"
+		if instance.StepID == ids["extract"] {
+			want = "failed"
+		}
+		if instance.State != want {
+			t.Errorf("%s state = %s, want %s", instance.StepName, instance.State, want)
+		}
+	}
+	if run, _ := db.GetMapRun(runs[0].ID); run.State != "failed" {
+		t.Errorf("run state = %s, want failed", run.State)
+	}
+	if len(s.TaskQueue) != 0 {
+		t.Errorf("%d steps queued after failure", len(s.TaskQueue))
+	}
+}
+
+func TestTickRequeuesDueSensors(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+
+	s.Tick()
+	step := <-s.TaskQueue
+	if _, err := db.ClaimStepRun(step.RunID, step.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RescheduleStepRun(step.RunID, step.ID, time.Date(2024, time.March, 1, 7, 30, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Tick()
+	if len(s.TaskQueue) != 0 {
+		t.Fatal("sensor was queued before its next poke")
+	}
+	s.SetNowFunc(func() time.Time { return time.Date(2024, time.March, 1, 7, 30, 0, 0, time.UTC) })
+	s.Tick()
+	select {
+	case queued := <-s.TaskQueue:
+		if queued.ID != ids["extract"] || queued.RunID != step.RunID {
+			t.Errorf("queued step %d of run %d, want extract", queued.ID, queued.RunID)
+		}
+	default:
+		t.Fatal("sensor was not queued for its next poke")
+	}
+}
+
+func TestNextRunTime(t *testing.T) {
+	m := models.Map{ScheduleInterval: "0 6 * * *", LastRun: time.Date(2024, time.March, 1, 6, 0, 0, 0, time.UTC)}
+	next, err := NextRunTime(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, time.March, 2, 6, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("NextRunTime = %v, want %v", next, want)
+	}
+}
@@ -1,9 +1,9 @@
 package scheduler
 
 import (
-	"fmt"
-	"log"
+	"log/slog"
 	"pilot/internal/database"
+	"pilot/internal/metrics"
 	"pilot/pkg/models"
 	"time"
 
@@ -11,7 +11,12 @@ import (
 	// other imports
 )
 
+// DefaultInterval is how often the scheduler checks for work when no step
+// completes in the meantime.
+const DefaultInterval = time.Minute
+
 type Scheduler struct {
+	Interval      time.Duration
 	db            *database.DB
 	TaskQueue     chan models.Step
 	nowFunc       func() time.Time
@@ -21,6 +26,7 @@ type Scheduler struct {
 
 func NewScheduler(db *database.DB, taskQueueSize int) *Scheduler {
 	scheduler := &Scheduler{
+		Interval:      DefaultInterval,
 		db:            db,
 		TaskQueue:     make(chan models.Step, taskQueueSize),
 		nowFunc:       time.Now,
@@ -35,39 +41,81 @@ func (s *Scheduler) SetNowFunc(f func() time.Time) {
 }
 
 func (s *Scheduler) Start() {
+	// Steps queued before a restart were lost with the in-memory queue
+	if err := s.db.ResetQueuedStepRuns(); err != nil {
+		slog.Error("Error resetting queued steps", "error", err)
+	}
+
 	for {
-		// 1. Fetch all active DAGs from the database
-		maps, err := s.db.GetActiveMaps()
+		start := time.Now()
+		s.Tick()
+		metrics.SchedulerLoopDuration.Observe(time.Since(start).Seconds())
+		metrics.TaskQueueDepth.Set(float64(len(s.TaskQueue)))
+
+		// Wait for a step to finish, or poll again after the interval to
+		// avoid constant database querying
+		select {
+		case <-s.TaskCompleted:
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// Tick creates the map runs that are due and queues the steps of running map
+// runs whose dependencies are met.
+func (s *Scheduler) Tick() {
+	// 1. Fetch all active Maps from the database
+	maps, err := s.db.GetActiveMaps()
+	if err != nil {
+		slog.Error("Error getting active maps", "error", err)
+	}
+
+	// 2. Create a run for every Map whose schedule is due, for every new file
+	// matching its trigger and once the datasets it consumes were updated
+	for _, m := range maps {
+		metrics.MapsEvaluated.Inc()
+		if m.Trigger != nil && m.Trigger.Files != "" {
+			s.checkFileTrigger(m)
+		}
+		if m.Trigger != nil && len(m.Trigger.Datasets) > 0 {
+			s.checkDatasetTrigger(m)
+		}
+		if m.ScheduleInterval == "" {
+			continue
+		}
+		lastRun, err := s.db.LastScheduledRun(m.ID)
 		if err != nil {
-			log.Println("Error getting active Maps:", err)
+			slog.Error("Error getting last run", "map_id", m.ID, "error", err)
+			continue
+		}
+		m.LastRun = lastRun
+		if m.LastRun.IsZero() && !m.StartDate.IsZero() {
+			// The first run is due at the first schedule time after the start date
+			m.LastRun = m.StartDate.Add(-time.Nanosecond)
 		}
 
-		// 2. Iterate through each Map and determine if any tasks are ready to run
-		for _, m := range maps {
-			if s.IsTimeToRun(m) {
-				fmt.Printf("checking map: %d\n", m.ID)
-				tasks, err := s.db.GetStepsByMapID(m.ID)
-				if err != nil {
-					log.Println("Error getting Steps by Map ID:", err)
-				}
-
-				for _, task := range tasks {
-					depsMet, err := s.db.DependenciesMet(task)
-					if err != nil {
-						log.Println("Error checking if Deps met:", err)
-					}
-
-					if depsMet {
-						s.QueueTask(task)
-					}
-				}
-			} else {
-				fmt.Println("it is not time to run...")
-			}
+		if !s.IsTimeToRun(m) {
+			slog.Debug("Map is not due", "map_id", m.ID, "last_run", m.LastRun)
+			continue
+		}
+		if _, err := CreateRun(s.db, m, "scheduled", s.logicalDate(m), nil); err != nil {
+			slog.Warn("Skipping map", "map_id", m.ID, "error", err)
 		}
+	}
 
-		// Add a sleep interval to avoid constant database querying
-		time.Sleep(1 * time.Minute)
+	// 3. Record the SLAs missed so far, before finished runs stop being
+	// checked
+	s.checkSLAs()
+
+	// 4. Queue the steps that are ready in every running map run
+	runs, err := s.db.GetActiveMapRuns()
+	if err != nil {
+		slog.Error("Error getting active map runs", "error", err)
+	}
+	for _, run := range runs {
+		if err := s.advanceRun(run); err != nil {
+			slog.Error("Error advancing map run", "map_id", run.MapID, "run_id", run.ID, "error", err)
+		}
 	}
 }
 
@@ -77,11 +125,11 @@ func (s *Scheduler) ScheduleMap(m models.Map) {
 			if s.dependenciesMet(step) {
 				s.TaskQueue <- step
 			} else {
-				log.Printf("Dependencies not met for step: %+v", step)
+				slog.Debug("Dependencies not met", "map_id", step.MapID, "step_id", step.ID)
 			}
 		}
 	} else {
-		log.Println("Map is not scheduled to run at this time.")
+		slog.Debug("Map is not due", "map_id", m.ID)
 	}
 }
 
@@ -97,7 +145,7 @@ func (s *Scheduler) dependenciesMet(step models.Step) bool {
 func (s *Scheduler) isDependencyMet(depID int) bool {
 	depStep, err := s.db.GetStepByID(depID)
 	if err != nil {
-		log.Println("Error getting step:", err)
+		slog.Error("Error getting step", "step_id", depID, "error", err)
 		return false
 	}
 	return depStep.State == "completed"
@@ -107,7 +155,8 @@ func (s *Scheduler) IsTimeToRun(m models.Map) bool {
 	now := s.nowFunc()
 	schedule, err := cron.ParseStandard(m.ScheduleInterval)
 	if err != nil {
-		log.Fatalf("Failed to parse cron schedule: %v", err)
+		slog.Error("Failed to parse cron schedule", "map_id", m.ID, "schedule", m.ScheduleInterval, "error", err)
+		return false
 	}
 
 	// Get the next scheduled run time
@@ -125,4 +174,6 @@ func (s *Scheduler) defaultQueueTask(step models.Step) {
 // Call this method to queue a task
 func (s *Scheduler) QueueTask(step models.Step) {
 	s.QueueTaskFunc(step) // Use the function field here.
+	metrics.StepsQueued.Inc()
+	metrics.TaskQueueDepth.Set(float64(len(s.TaskQueue)))
 }
@@ -0,0 +1,150 @@
+package scheduler
+
+import (
+	"log/slog"
+	"time"
+
+	"pilot/pkg/models"
+	"pilot/pkg/notify"
+)
+
+// SLAWindow is how long failed runs are still checked for SLA misses, as
+// they may be cleared and succeed before their deadlines.
+const SLAWindow = 24 * time.Hour
+
+// checkSLAs records the runs and step instances that have not succeeded by
+// their SLA deadline, and tells the notifiers of their map about each new
+// miss. Steps that did not start yet miss their SLA as well.
+func (s *Scheduler) checkSLAs() {
+	now := s.nowFunc()
+	runs, err := s.db.GetSLARuns(now.Add(-SLAWindow))
+	if err != nil {
+		slog.Error("Error getting runs to check SLAs", "error", err)
+		return
+	}
+
+	maps := map[int]*models.Map{}
+	for _, run := range runs {
+		m, ok := maps[run.MapID]
+		if !ok {
+			if m, err = s.slaMap(run.MapID); err != nil {
+				slog.Error("Error getting map to check SLAs", "map_id", run.MapID, "error", err)
+				continue
+			}
+			maps[run.MapID] = m
+		}
+		if m == nil {
+			continue
+		}
+
+		instances, err := s.db.GetStepRuns(run.ID)
+		if err != nil {
+			slog.Error("Error getting step instances to check SLAs", "run_id", run.ID, "error", err)
+			continue
+		}
+		for _, miss := range slaMisses(*m, run, instances, now) {
+			s.recordSLAMiss(m, run, miss)
+		}
+	}
+}
+
+// slaMap returns a map with its steps, or nil when neither the map nor its
+// steps have an SLA.
+func (s *Scheduler) slaMap(id int) (*models.Map, error) {
+	m, err := s.db.GetMapByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if m.Steps, err = s.db.GetStepsByMapID(id); err != nil {
+		return nil, err
+	}
+	if m.SLA > 0 {
+		return m, nil
+	}
+	for _, step := range m.Steps {
+		if step.SLA > 0 {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Scheduler) recordSLAMiss(m *models.Map, run models.MapRun, miss models.SLAMiss) {
+	added, err := s.db.RecordSLAMiss(&miss)
+	if err != nil {
+		slog.Error("Error recording SLA miss", "map_id", m.ID, "run_id", run.ID, "step_id", miss.StepID, "error", err)
+		return
+	}
+	if !added {
+		return
+	}
+	slog.Warn("SLA missed", "map_id", m.ID, "run_id", run.ID, "step_id", miss.StepID, "step", miss.StepName,
+		"deadline", miss.Deadline, "state", miss.State)
+	deadline := miss.Deadline
+	notify.Send(s.db, notify.Event{
+		Type:        models.EventSLAMissed,
+		MapID:       m.ID,
+		Map:         m.Name,
+		RunID:       run.ID,
+		LogicalDate: run.LogicalDate,
+		StepID:      miss.StepID,
+		Step:        miss.StepName,
+		State:       miss.State,
+		Deadline:    &deadline,
+	})
+}
+
+// slaMisses returns the SLAs of a run and of its steps that were not met at
+// now: the deadline passed and the run or step had not succeeded by then.
+func slaMisses(m models.Map, run models.MapRun, instances []models.StepRun, now time.Time) []models.SLAMiss {
+	var misses []models.SLAMiss
+	byStep := map[int]models.StepRun{}
+	for _, instance := range instances {
+		byStep[instance.StepID] = instance
+	}
+
+	if m.SLA > 0 {
+		deadline := m.SLADeadline(run, m.SLA)
+		succeeded, at := true, time.Time{}
+		for _, instance := range instances {
+			done, end := instanceSucceeded(instance)
+			succeeded = succeeded && done
+			if end.After(at) {
+				at = end
+			}
+		}
+		if !succeeded {
+			at = now
+		}
+		if at.After(deadline) {
+			misses = append(misses, models.SLAMiss{MapID: m.ID, RunID: run.ID, Deadline: deadline, State: run.State})
+		}
+	}
+
+	for _, step := range m.Steps {
+		instance, ok := byStep[step.ID]
+		if step.SLA <= 0 || !ok {
+			continue
+		}
+		deadline := m.SLADeadline(run, step.SLA)
+		at := now
+		if done, end := instanceSucceeded(instance); done {
+			at = end
+		}
+		if at.After(deadline) {
+			misses = append(misses, models.SLAMiss{MapID: m.ID, RunID: run.ID, StepID: step.ID, StepName: step.Name,
+				Deadline: deadline, State: instance.State})
+		}
+	}
+	return misses
+}
+
+// instanceSucceeded reports whether a step instance completed or was
+// skipped, and when.
+func instanceSucceeded(instance models.StepRun) (bool, time.Time) {
+	switch instance.State {
+	case "completed", "skipped":
+		return true, instance.EndDate
+	}
+	return false, time.Time{}
+}
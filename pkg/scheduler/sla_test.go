@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+func TestSLAMisses(t *testing.T) {
+	sixAM := time.Date(2024, time.March, 1, 6, 0, 0, 0, time.UTC)
+	m := models.Map{ID: 1, SLA: time.Hour, Steps: []models.Step{
+		{ID: 10, Name: "extract"},
+		{ID: 11, Name: "load", SLA: 30 * time.Minute},
+	}}
+	run := models.MapRun{ID: 5, MapID: 1, LogicalDate: sixAM, StartDate: sixAM.Add(10 * time.Minute), State: "running"}
+	instance := func(stepID int, state string, end time.Duration) models.StepRun {
+		return models.StepRun{StepID: stepID, State: state, EndDate: sixAM.Add(end)}
+	}
+
+	tests := []struct {
+		name      string
+		from      string
+		instances []models.StepRun
+		now       time.Duration
+		want      []int // step IDs of the misses, 0 for the map
+	}{
+		{"before the deadlines", "", []models.StepRun{instance(10, "running", 0), instance(11, "pending", 0)}, 20 * time.Minute, nil},
+		{"step not started", "", []models.StepRun{instance(10, "running", 0), instance(11, "pending", 0)}, 40 * time.Minute, []int{11}},
+		{"both late", "", []models.StepRun{instance(10, "completed", 50*time.Minute), instance(11, "queued", 0)}, 70 * time.Minute, []int{0, 11}},
+		{"succeeded in time", "", []models.StepRun{instance(10, "completed", 10*time.Minute), instance(11, "completed", 25*time.Minute)}, 3 * time.Hour, nil},
+		{"succeeded late", "", []models.StepRun{instance(10, "completed", 10*time.Minute), instance(11, "completed", 65*time.Minute)}, 3 * time.Hour, []int{0, 11}},
+		{"skipped counts as success", "", []models.StepRun{instance(10, "skipped", 10*time.Minute), instance(11, "skipped", 20*time.Minute)}, 3 * time.Hour, nil},
+		{"failed before the deadline", "", []models.StepRun{instance(10, "completed", 5*time.Minute), instance(11, "failed", 10*time.Minute)}, 35 * time.Minute, []int{11}},
+		{"from schedule time", models.SLAFromSchedule, []models.StepRun{instance(10, "running", 0), instance(11, "pending", 0)}, 35 * time.Minute, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.SLAFrom = tt.from
+			misses := slaMisses(m, run, tt.instances, sixAM.Add(tt.now))
+			var got []int
+			for _, miss := range misses {
+				got = append(got, miss.StepID)
+				if miss.RunID != run.ID || miss.MapID != m.ID {
+					t.Errorf("miss %+v is not for run %d of map %d", miss, run.ID, m.ID)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("misses for steps %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("misses for steps %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTickRecordsSLAMissesOnce(t *testing.T) {
+	s, db, ids := newTestScheduler(t)
+	m, err := db.GetMapByID(ids["map"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SLA = 30 * time.Minute
+	if err := db.UpdateMap(*m); err != nil {
+		t.Fatal(err)
+	}
+	load, err := db.GetStepByID(ids["load"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	load.SLA = 45 * time.Minute
+	if err := db.UpdateStep(*load); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Tick()
+	s.SetNowFunc(func() time.Time { return time.Date(2024, time.March, 1, 8, 0, 0, 0, time.UTC) })
+	s.Tick()
+	s.Tick()
+	runs, err := db.ListMapRuns(ids["map"], 0)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("runs = %v, %v", runs, err)
+	}
+	misses, err := db.GetSLAMisses(runs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(misses) != 2 {
+		t.Fatalf("got %d misses, want one for the map and one for load: %+v", len(misses), misses)
+	}
+	if misses[0].StepID != 0 || !misses[0].Deadline.Equal(time.Date(2024, time.March, 1, 7, 30, 0, 0, time.UTC)) {
+		t.Errorf("map miss = %+v", misses[0])
+	}
+	if misses[1].StepName != "load" || misses[1].State != "pending" {
+		t.Errorf("step miss = %+v", misses[1])
+	}
+}
@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"pilot/pkg/models"
+)
+
+var envNameSanitizer = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// connectionEnv resolves the connections referenced by a step and returns the
+// environment variables exposing them, along with the secret values that must
+// be masked in any captured output.
+func (w *Worker) connectionEnv(step models.Step) ([]string, []string, error) {
+	var env, secrets []string
+	if len(step.Connections) == 0 {
+		return env, secrets, nil
+	}
+	if w.DatabaseClient == nil {
+		return nil, nil, fmt.Errorf("step %v references connections but no database is configured", step.ID)
+	}
+
+	for _, name := range step.Connections {
+		c, err := w.DatabaseClient.GetConnection(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		extras, err := json.Marshal(c.Extras)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		prefix := connectionEnvPrefix(name)
+		env = append(env,
+			prefix+"_URI="+c.URI,
+			prefix+"_LOGIN="+c.Login,
+			prefix+"_PASSWORD="+c.Password,
+			prefix+"_EXTRAS="+string(extras),
+		)
+		secrets = append(secrets, c.Secrets()...)
+	}
+	return env, secrets, nil
+}
+
+// connectionEnvPrefix returns the prefix of the variables for a connection,
+// e.g. "warehouse-db" becomes "PILOT_CONN_WAREHOUSE_DB".
+func connectionEnvPrefix(name string) string {
+	return "PILOT_CONN_" + strings.Trim(envNameSanitizer.ReplaceAllString(strings.ToUpper(name), "_"), "_")
+}
+
+// maskSecrets replaces every occurrence of a secret in s with asterisks.
+func maskSecrets(s string, secrets []string) string {
+	var pairs []string
+	for _, secret := range secrets {
+		if secret != "" {
+			pairs = append(pairs, secret, "***")
+		}
+	}
+	if len(pairs) == 0 {
+		return s
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+// HookFunc is a Go function run as a step hook. It gets the outcome of the
+// step and is cancelled when the hook times out.
+type HookFunc func(ctx context.Context, outcome Outcome) error
+
+// Outcome is what happened to a step when its hooks run.
+type Outcome struct {
+	Event string      // one of models.HookEvents
+	Step  models.Step // the step, with its state and attempt
+	Error error       // the error of the failed attempt, nil on success
+}
+
+var (
+	hookFuncsMu sync.RWMutex
+	hookFuncs   = map[string]HookFunc{}
+)
+
+// RegisterHook makes fn available to the hooks of maps and steps under name,
+// e.g. `on_failure: [{func: name}]`. Functions must be registered in every
+// worker process before steps using them run.
+func RegisterHook(name string, fn HookFunc) {
+	hookFuncsMu.Lock()
+	defer hookFuncsMu.Unlock()
+	hookFuncs[name] = fn
+}
+
+func lookupHook(name string) (HookFunc, bool) {
+	hookFuncsMu.RLock()
+	defer hookFuncsMu.RUnlock()
+	fn, ok := hookFuncs[name]
+	return fn, ok
+}
+
+// runHooks runs the hooks of a step, then those of its map, for an outcome of
+// the step. Hooks run one after the other, each within its timeout, and their
+// failures are only logged.
+func (w *Worker) runHooks(ctx context.Context, event string, step models.Step, stepErr error) {
+	hooks := append([]models.Hook{}, step.Hooks...)
+	if w.DatabaseClient != nil {
+		m, err := w.DatabaseClient.GetMapByID(step.MapID)
+		if err != nil {
+			w.stepLogger(step).Error("Error getting map hooks", "error", err)
+		} else {
+			hooks = append(hooks, m.Hooks...)
+		}
+	}
+
+	outcome := Outcome{Event: event, Step: step, Error: stepErr}
+	for i, hook := range hooks {
+		if hook.On != event {
+			continue
+		}
+		logger := w.stepLogger(step).With("hook", event, "hook_index", i+1)
+		start := time.Now()
+		if err := w.runHook(ctx, hook, outcome); err != nil {
+			logger.Warn("Hook failed", "attempt", step.Attempt, "error", err)
+			continue
+		}
+		logger.Debug("Hook completed", "attempt", step.Attempt, "duration", time.Since(start))
+	}
+}
+
+// runHook runs a single hook within its timeout.
+func (w *Worker) runHook(ctx context.Context, hook models.Hook, outcome Outcome) error {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = models.DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if hook.Func != "" {
+		fn, ok := lookupHook(hook.Func)
+		if !ok {
+			return fmt.Errorf("no hook function registered as %q", hook.Func)
+		}
+		return callHook(ctx, fn, outcome)
+	}
+
+	cmd, secrets, err := w.scriptCommand(ctx, outcome.Step, hook.Command)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(cmd.Env, hookEnv(outcome)...)
+	cmd.WaitDelay = time.Second
+	output, err := cmd.CombinedOutput()
+	masked := maskSecrets(string(output), secrets)
+	if ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, masked)
+	}
+	w.stepLogger(outcome.Step).Debug("Hook output", "hook", outcome.Event, "output", masked)
+	return nil
+}
+
+// callHook calls a hook function, giving up when its context is done so a
+// function ignoring cancellation does not hold up the worker. Panics are
+// returned as errors.
+func callHook(ctx context.Context, fn HookFunc, outcome Outcome) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("hook panicked: %v", r)
+			}
+		}()
+		done <- fn(ctx, outcome)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out: %w", ctx.Err())
+	}
+}
+
+// hookEnv tells hook scripts about the outcome they run after.
+func hookEnv(outcome Outcome) []string {
+	env := []string{
+		"PILOT_HOOK=" + outcome.Event,
+		"PILOT_STEP=" + outcome.Step.Name,
+		"PILOT_STEP_STATE=" + outcome.Step.State,
+		"PILOT_ATTEMPT=" + strconv.Itoa(outcome.Step.Attempt),
+	}
+	if outcome.Error != nil {
+		env = append(env, "PILOT_STEP_ERROR="+outcome.Error.Error())
+	}
+	return env
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+func TestRunHooks(t *testing.T) {
+	var got []Outcome
+	RegisterHook("test-record", func(ctx context.Context, outcome Outcome) error {
+		got = append(got, outcome)
+		return nil
+	})
+	RegisterHook("test-fail", func(ctx context.Context, outcome Outcome) error {
+		return errors.New("boom")
+	})
+
+	step := models.Step{ID: 1, Name: "load", State: "failed", Attempt: 2, Hooks: []models.Hook{
+		{On: models.HookOnFailure, Func: "test-fail"},
+		{On: models.HookOnFailure, Func: "test-missing"},
+		{On: models.HookOnFailure, Func: "test-record"},
+		{On: models.HookOnSuccess, Func: "test-record"},
+	}}
+	w := Worker{}
+	w.runHooks(context.Background(), models.HookOnFailure, step, errors.New("exit status 1"))
+
+	// Failing hooks do not stop the next ones, hooks of other outcomes do not run
+	if len(got) != 1 {
+		t.Fatalf("recording hook ran %d times, want once", len(got))
+	}
+	if got[0].Event != models.HookOnFailure || got[0].Step.Name != "load" || got[0].Error == nil {
+		t.Errorf("hook got %+v", got[0])
+	}
+}
+
+func TestRunHookTimeout(t *testing.T) {
+	RegisterHook("test-block", func(ctx context.Context, outcome Outcome) error {
+		select {}
+	})
+	RegisterHook("test-panic", func(ctx context.Context, outcome Outcome) error {
+		panic("oops")
+	})
+	w := Worker{}
+
+	start := time.Now()
+	err := w.runHook(context.Background(), models.Hook{Func: "test-block", Timeout: 50 * time.Millisecond}, Outcome{})
+	if err == nil || time.Since(start) > time.Second {
+		t.Errorf("runHook() = %v after %v, want a timeout", err, time.Since(start))
+	}
+	if err := w.runHook(context.Background(), models.Hook{Func: "test-panic"}, Outcome{}); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("runHook() = %v, want the panic", err)
+	}
+}
+
+func TestRunHookScript(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not installed")
+	}
+	dir := t.TempDir()
+	script := "import os, sys\n" +
+		"open(os.path.join(os.path.dirname(__file__), 'out'), 'w').write(os.environ['PILOT_HOOK'] + ' ' + os.environ['PILOT_STEP_ERROR'])\n" +
+		"sys.exit(int(sys.argv[1]))\n"
+	if err := os.WriteFile(filepath.Join(dir, "hook.py"), []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PROJECT_PATH", dir)
+	w := Worker{}
+	outcome := Outcome{Event: models.HookOnRetry, Step: models.Step{Name: "load"}, Error: errors.New("exit status 1")}
+
+	if err := w.runHook(context.Background(), models.Hook{Command: "hook.py 0"}, outcome); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "on_retry exit status 1" {
+		t.Errorf("hook wrote %q", out)
+	}
+	if err := w.runHook(context.Background(), models.Hook{Command: "hook.py 3"}, outcome); err == nil {
+		t.Error("expected the exit status of the hook")
+	}
+}
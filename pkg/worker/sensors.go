@@ -0,0 +1,230 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"pilot/internal/tracing"
+	"pilot/pkg/models"
+)
+
+// pokeTimeout bounds a single check of a sensor condition.
+const pokeTimeout = 30 * time.Second
+
+// runSensor pokes the condition of a sensor step until it holds or the sensor
+// times out. In reschedule mode the worker is released between pokes and the
+// scheduler queues the step again when the next poke is due. Errors while
+// poking are logged and count as the condition not holding yet.
+func (w *Worker) runSensor(step models.Step) {
+	logger := w.stepLogger(step)
+	sensor := *step.Sensor
+
+	now := time.Now()
+	started, first := now, true
+	if step.RunID != 0 && w.DatabaseClient != nil {
+		var err error
+		if started, first, err = w.DatabaseClient.StartSensor(step.RunID, step.ID, now); err != nil {
+			logger.Error("Error starting sensor", "error", err)
+			started, first = now, true
+		}
+	}
+	if first || step.Attempt == 0 {
+		step.Attempt++
+		logger.Info("Starting sensor", "attempt", step.Attempt, "type", sensor.Type, "mode", sensor.Mode)
+	}
+	step.State = "running"
+	step.StartDate = started
+	w.saveState(step)
+
+	ctx, span := tracing.StartAttempt(w.traceContext(step), step)
+	deadline := sensor.Deadline(started)
+	for {
+		ok, err := w.poke(ctx, step)
+		if err != nil {
+			logger.Warn("Sensor poke failed", "type", sensor.Type, "error", err)
+		}
+		if ok {
+			tracing.EndAttempt(span, nil)
+			w.finishSensor(ctx, step, "completed", nil)
+			logger.Info("Sensor condition met", "waited", time.Since(started).Round(time.Second))
+			return
+		}
+
+		now := time.Now()
+		if !now.Before(deadline) {
+			err := fmt.Errorf("sensor timed out after %v", now.Sub(started).Round(time.Second))
+			tracing.EndAttempt(span, err)
+			if sensor.SoftFail {
+				logger.Warn("Sensor timed out, skipping step", "timeout", deadline.Sub(started))
+				w.finishSensor(ctx, step, "skipped", err)
+				return
+			}
+			logger.Error("Sensor timed out", "timeout", deadline.Sub(started))
+			w.finishSensor(ctx, step, "failed", err)
+			return
+		}
+
+		wait := sensor.Interval()
+		if left := deadline.Sub(now); left < wait {
+			wait = left
+		}
+		if sensor.Mode == models.SensorModeReschedule && step.RunID != 0 && w.DatabaseClient != nil {
+			next := now.Add(wait)
+			err := w.DatabaseClient.RescheduleStepRun(step.RunID, step.ID, next)
+			if err == nil {
+				tracing.EndAttempt(span, nil)
+				logger.Debug("Sensor rescheduled", "next_poke", next)
+				return
+			}
+			logger.Error("Error rescheduling sensor, poking in place", "error", err)
+		}
+		time.Sleep(wait)
+	}
+}
+
+// finishSensor records the final state of a sensor step and runs what follows
+// the outcome of any step: metrics, notifications and hooks. Skipped sensors
+// only wake the scheduler.
+func (w *Worker) finishSensor(ctx context.Context, step models.Step, state string, err error) {
+	step.State = state
+	step.EndDate = time.Now()
+	w.saveState(step)
+	if state == "completed" {
+		w.recordDatasets(step)
+	}
+	w.observe(step)
+	if state == "failed" {
+		w.notify(models.EventStepFailed, step, err)
+	}
+	w.notifyScheduler(step)
+	switch state {
+	case "completed":
+		w.runHooks(ctx, models.HookOnSuccess, step, nil)
+	case "failed":
+		w.runHooks(ctx, models.HookOnFailure, step, err)
+	}
+}
+
+// poke checks the condition of a sensor once. Paths, queries and URLs are
+// rendered as templates like step commands.
+func (w *Worker) poke(ctx context.Context, step models.Step) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, pokeTimeout)
+	defer cancel()
+
+	sensor := step.Sensor
+	data, err := w.templateData(step)
+	if err != nil {
+		return false, err
+	}
+	switch sensor.Type {
+	case models.SensorFile:
+		pattern, err := renderCommand(sensor.Path, data)
+		if err != nil {
+			return false, err
+		}
+		return pokeFile(pattern)
+	case models.SensorSQL:
+		query, err := renderCommand(sensor.Query, data)
+		if err != nil {
+			return false, err
+		}
+		if w.DatabaseClient == nil {
+			return false, errors.New("sql sensors need a database to look their connection up")
+		}
+		conn, err := w.DatabaseClient.GetConnection(sensor.Connection)
+		if err != nil {
+			return false, fmt.Errorf("connection %q: %w", sensor.Connection, err)
+		}
+		return pokeSQL(ctx, *conn, query)
+	case models.SensorHTTP:
+		target, err := renderCommand(sensor.URL, data)
+		if err != nil {
+			return false, err
+		}
+		return pokeHTTP(ctx, target, sensor.Status)
+	default:
+		return false, fmt.Errorf("unknown sensor type %q", sensor.Type)
+	}
+}
+
+// pokeFile reports whether a path or glob pattern matches a file. Relative
+// patterns are resolved against PROJECT_PATH, like step scripts.
+func pokeFile(pattern string) (bool, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(os.Getenv("PROJECT_PATH"), pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	return len(matches) > 0, err
+}
+
+// pokeSQL reports whether a query returns at least one row.
+func pokeSQL(ctx context.Context, conn models.Connection, query string) (bool, error) {
+	driver, dsn, err := sqlSource(conn)
+	if err != nil {
+		return false, err
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+// sqlSource returns the database/sql driver and data source name for a
+// connection. The driver is named after the scheme of its URI, e.g.
+// postgres://warehouse/sales, and must be linked into the worker. SQLite
+// databases are given as sqlite:///path/to/file.db.
+func sqlSource(conn models.Connection) (string, string, error) {
+	u, err := url.Parse(conn.URI)
+	if err != nil || u.Scheme == "" {
+		return "", "", fmt.Errorf("connection %q has no URI scheme naming its SQL driver", conn.Name)
+	}
+	switch u.Scheme {
+	case "sqlite", "sqlite3":
+		return "sqlite3", strings.TrimPrefix(conn.URI, u.Scheme+"://"), nil
+	}
+	driver := u.Scheme
+	if driver == "postgresql" {
+		driver = "postgres"
+	}
+	if !slices.Contains(sql.Drivers(), driver) {
+		return "", "", fmt.Errorf("no SQL driver %q is linked into the worker", driver)
+	}
+	if u.User == nil && conn.Login != "" {
+		u.User = url.UserPassword(conn.Login, conn.Password)
+	}
+	return driver, u.String(), nil
+}
+
+// pokeHTTP reports whether a GET of target answers with the expected status.
+func pokeHTTP(ctx context.Context, target string, status int) (bool, error) {
+	if status == 0 {
+		status = models.DefaultSensorStatus
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == status, nil
+}
@@ -0,0 +1,191 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pilot/internal/database"
+	"pilot/pkg/models"
+	"pilot/pkg/scheduler"
+)
+
+func TestPokeFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROJECT_PATH", dir)
+	if ok, err := pokeFile(filepath.Join(dir, "sales_*.csv")); ok || err != nil {
+		t.Fatalf("pokeFile() = %t, %v before the file landed", ok, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sales_2024-03-01.csv"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{filepath.Join(dir, "sales_*.csv"), "sales_2024-03-01.csv"} {
+		if ok, err := pokeFile(pattern); !ok || err != nil {
+			t.Errorf("pokeFile(%q) = %t, %v", pattern, ok, err)
+		}
+	}
+}
+
+func TestPokeSQL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warehouse.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE partitions (ds TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	conn := models.Connection{Name: "warehouse", URI: "sqlite://" + path}
+	query := `SELECT 1 FROM partitions WHERE ds = '2024-03-01'`
+
+	if ok, err := pokeSQL(context.Background(), conn, query); ok || err != nil {
+		t.Fatalf("pokeSQL() = %t, %v on an empty table", ok, err)
+	}
+	if _, err := db.Exec(`INSERT INTO partitions VALUES ('2024-03-01')`); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := pokeSQL(context.Background(), conn, query); !ok || err != nil {
+		t.Errorf("pokeSQL() = %t, %v once the partition exists", ok, err)
+	}
+
+	_, err = pokeSQL(context.Background(), models.Connection{Name: "oracle", URI: "oracle://db/sales"}, query)
+	if err == nil || !strings.Contains(err.Error(), `no SQL driver "oracle"`) {
+		t.Errorf("pokeSQL() = %v, want a missing driver error", err)
+	}
+}
+
+func TestPokeHTTP(t *testing.T) {
+	status := http.StatusNotFound
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	if ok, err := pokeHTTP(context.Background(), srv.URL, 0); ok || err != nil {
+		t.Fatalf("pokeHTTP() = %t, %v on a 404", ok, err)
+	}
+	status = http.StatusOK
+	if ok, err := pokeHTTP(context.Background(), srv.URL, 0); !ok || err != nil {
+		t.Errorf("pokeHTTP() = %t, %v on a 200", ok, err)
+	}
+	if ok, _ := pokeHTTP(context.Background(), srv.URL, http.StatusNoContent); ok {
+		t.Error("pokeHTTP() met a 204 sensor on a 200")
+	}
+}
+
+func TestPokeHTTPLeavesVariables(t *testing.T) {
+	t.Setenv("PILOT_SECRET_KEY", "s3cret")
+	queries := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.RawQuery
+	}))
+	defer srv.Close()
+
+	w := &Worker{}
+	step := models.Step{Name: "wait", Sensor: &models.Sensor{Type: models.SensorHTTP, URL: srv.URL + "/ready?key=$PILOT_SECRET_KEY"}}
+	if _, err := w.poke(context.Background(), step); err != nil {
+		t.Fatal(err)
+	}
+	if query := <-queries; strings.Contains(query, "s3cret") {
+		t.Errorf("sensor URL expanded PILOT_SECRET_KEY: %q", query)
+	}
+}
+
+// sensorRun registers a map with a single sensor step and returns the worker
+// and the queued instance of the step in a new run.
+func sensorRun(t *testing.T, sensor models.Sensor) (*Worker, *database.DB, models.Step) {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := models.NewMap("sales", "0 6 * * *", time.Time{}, time.Time{}, []models.Step{{Name: "wait", Sensor: &sensor}})
+	if m.ID, _, err = db.SyncMap(*m, nil); err != nil {
+		t.Fatal(err)
+	}
+	run, err := scheduler.CreateRun(db, *m, "manual", time.Now(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances, err := db.GetStepRuns(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.TransitionStepRun(run.ID, instances[0].StepID, "pending", "queued"); err != nil {
+		t.Fatal(err)
+	}
+	step, err := db.NextQueuedStep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Worker{DatabaseClient: db}, db, *step
+}
+
+func stepRun(t *testing.T, db *database.DB, step models.Step) models.StepRun {
+	t.Helper()
+	instances, err := db.GetStepRuns(step.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instances[0]
+}
+
+func TestSensorReschedule(t *testing.T) {
+	dir := t.TempDir()
+	w, db, step := sensorRun(t, models.Sensor{Type: models.SensorFile, Path: filepath.Join(dir, "*.csv"),
+		PokeInterval: time.Hour, Mode: models.SensorModeReschedule})
+
+	w.ExecuteTask(step)
+	if instance := stepRun(t, db, step); instance.State != "up_for_reschedule" || instance.Attempt != 1 {
+		t.Fatalf("after the first poke the step is %s, attempt %d", instance.State, instance.Attempt)
+	}
+	at, err := db.StepRunRescheduleAt(step.RunID, step.ID)
+	if err != nil || time.Until(at) < 59*time.Minute {
+		t.Fatalf("next poke at %v, %v, want in an hour", at, err)
+	}
+	started, first, err := db.StartSensor(step.RunID, step.ID, time.Now())
+	if err != nil || first {
+		t.Fatalf("StartSensor() = %v, %t, %v, want the first poke", started, first, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "sales.csv"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.TransitionStepRun(step.RunID, step.ID, "up_for_reschedule", "queued"); err != nil {
+		t.Fatal(err)
+	}
+	next, err := db.NextQueuedStep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.ExecuteTask(*next)
+	instance := stepRun(t, db, step)
+	if instance.State != "completed" || instance.Attempt != 1 {
+		t.Errorf("after the file landed the step is %s, attempt %d", instance.State, instance.Attempt)
+	}
+	if !instance.StartDate.Equal(started) {
+		t.Errorf("start date %v, want the first poke at %v", instance.StartDate, started)
+	}
+}
+
+func TestSensorTimeout(t *testing.T) {
+	for _, softFail := range []bool{false, true} {
+		w, db, step := sensorRun(t, models.Sensor{Type: models.SensorFile, Path: filepath.Join(t.TempDir(), "missing"),
+			PokeInterval: time.Millisecond, Timeout: 20 * time.Millisecond, SoftFail: softFail})
+		w.ExecuteTask(step)
+		want := "failed"
+		if softFail {
+			want = "skipped"
+		}
+		if instance := stepRun(t, db, step); instance.State != want {
+			t.Errorf("soft_fail %t: step is %s, want %s", softFail, instance.State, want)
+		}
+	}
+}
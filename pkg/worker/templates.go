@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"pilot/pkg/models"
+)
+
+// TemplateData holds the fields available to step commands, which are
+// rendered as Go templates, e.g. "load.py --date {{ .Ds }}".
+type TemplateData struct {
+	MapID       int
+	RunID       int
+	RunType     string
+	LogicalDate time.Time
+	Ds          string // logical date as YYYY-MM-DD
+	Conf        map[string]any
+}
+
+// templateData collects the details of the map run a step belongs to. Steps
+// queued outside of a map run get the current time as logical date.
+func (w *Worker) templateData(step models.Step) (TemplateData, error) {
+	data := TemplateData{MapID: step.MapID, LogicalDate: time.Now(), Conf: map[string]any{}}
+	if step.RunID != 0 && w.DatabaseClient != nil {
+		run, err := w.DatabaseClient.GetMapRun(step.RunID)
+		if err != nil {
+			return data, err
+		}
+		data.RunID = run.ID
+		data.RunType = run.RunType
+		data.LogicalDate = run.LogicalDate
+		if run.Conf != nil {
+			data.Conf = run.Conf
+		}
+	}
+	data.Ds = data.LogicalDate.Format("2006-01-02")
+	return data, nil
+}
+
+// renderCommand expands the template fields in a step command.
+func renderCommand(command string, data TemplateData) (string, error) {
+	if !strings.Contains(command, "{{") {
+		return command, nil
+	}
+	tmpl, err := template.New("command").Option("missingkey=error").Parse(command)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// renderArgs splits a step command into arguments and expands the template
+// fields of each one. The command is split before rendering, so that values
+// holding spaces, such as conf values, stay a single argument.
+func renderArgs(command string, data TemplateData) ([]string, error) {
+	args := splitCommand(command)
+	for i, arg := range args {
+		rendered, err := renderCommand(arg, data)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = rendered
+	}
+	return args, nil
+}
+
+// splitCommand splits a command on whitespace like strings.Fields, keeping
+// template actions such as "{{ .Ds }}" within a single argument.
+func splitCommand(command string) []string {
+	var args []string
+	var arg strings.Builder
+	depth := 0
+	for i := 0; i < len(command); i++ {
+		switch {
+		case strings.HasPrefix(command[i:], "{{"):
+			depth++
+			arg.WriteString("{{")
+			i++
+		case depth > 0 && strings.HasPrefix(command[i:], "}}"):
+			depth--
+			arg.WriteString("}}")
+			i++
+		case depth == 0 && strings.IndexByte(" \t\n\v\f\r", command[i]) >= 0:
+			if arg.Len() > 0 {
+				args = append(args, arg.String())
+				arg.Reset()
+			}
+		default:
+			arg.WriteByte(command[i])
+		}
+	}
+	if arg.Len() > 0 {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// runEnv exposes the template data to the step process.
+func runEnv(data TemplateData) []string {
+	conf, _ := json.Marshal(data.Conf)
+	return []string{
+		"PILOT_MAP_ID=" + strconv.Itoa(data.MapID),
+		"PILOT_RUN_ID=" + strconv.Itoa(data.RunID),
+		"PILOT_RUN_TYPE=" + data.RunType,
+		"PILOT_LOGICAL_DATE=" + data.LogicalDate.Format(time.RFC3339),
+		"PILOT_DS=" + data.Ds,
+		"PILOT_RUN_CONF=" + string(conf),
+	}
+}
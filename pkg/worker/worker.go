@@ -1,15 +1,20 @@
 package worker
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"pilot/internal/database"
+	"pilot/internal/metrics"
+	"pilot/internal/tracing"
 	"pilot/pkg/models"
+	"pilot/pkg/notify"
 	"pilot/pkg/scheduler"
+	"time"
 	// Other necessary imports
 )
 
@@ -17,55 +22,281 @@ type Worker struct {
 	TaskQueue      chan models.Step
 	DatabaseClient *database.DB
 	Scheduler      *scheduler.Scheduler
-	Logger         *log.Logger
+	Logger         *slog.Logger
 	// Other fields as needed
 }
 
 func (w *Worker) ExecuteTask(step models.Step) {
-	// Log task start
-	w.Logger.Printf("Starting task: %v\n", step.ID)
-	fmt.Printf("Starting task: %v\n", step.ID)
+	logger := w.stepLogger(step)
 
-	err := w.performTaskAction(step)
+	// Steps of a map run may be picked up by several workers, only run the
+	// ones this worker manages to claim
+	if step.RunID != 0 && w.DatabaseClient != nil {
+		claimed, err := w.DatabaseClient.ClaimStepRun(step.RunID, step.ID)
+		if err != nil {
+			logger.Error("Error claiming step", "error", err)
+			return
+		}
+		if !claimed {
+			return
+		}
+	}
+
+	metrics.Workers.WithLabelValues("idle").Dec()
+	metrics.Workers.WithLabelValues("busy").Inc()
+	defer func() {
+		metrics.Workers.WithLabelValues("busy").Dec()
+		metrics.Workers.WithLabelValues("idle").Inc()
+	}()
+
+	if step.Sensor != nil {
+		w.runSensor(step)
+		return
+	}
+
+	step.State = "running"
+	step.StartDate = time.Now()
+	step.Attempt++
+	logger.Info("Starting step", "attempt", step.Attempt)
+	w.saveState(step)
+
+	ctx := w.traceContext(step)
+	err := w.attempt(ctx, step)
+	for attempt := 1; err != nil && attempt <= step.Retries; attempt++ {
+		logger.Warn("Step failed, retrying", "attempt", step.Attempt, "retry", attempt, "retries", step.Retries,
+			"retry_delay", step.RetryDelay, "error", err)
+		metrics.StepRetries.WithLabelValues(w.mapName(step), step.Name).Inc()
+		w.notify(models.EventStepRetry, step, err)
+		w.runHooks(ctx, models.HookOnRetry, step, err)
+		time.Sleep(step.RetryDelay)
+		step.Attempt++
+		w.saveState(step)
+		err = w.attempt(ctx, step)
+	}
 	if err != nil {
-		// Handle error, log it, and possibly update task state to 'failed'
-		w.Logger.Printf("Error executing task %v: %v\n", step.ID, err)
+		logger.Error("Step failed", "attempt", step.Attempt, "error", err)
+		step.State = "failed"
+		step.EndDate = time.Now()
+		w.saveState(step)
+		w.observe(step)
+		w.notify(models.EventStepFailed, step, err)
+		w.notifyScheduler(step)
+		w.runHooks(ctx, models.HookOnFailure, step, err)
 		return
 	}
 
 	step.State = "completed"
-	err = w.DatabaseClient.UpdateStep(step)
+	step.EndDate = time.Now()
+	w.saveState(step)
+	w.recordDatasets(step)
+	w.observe(step)
+	w.notifyScheduler(step)
+	logger.Info("Completed step", "attempt", step.Attempt, "duration", step.EndDate.Sub(step.StartDate))
+	w.runHooks(ctx, models.HookOnSuccess, step, nil)
+}
+
+// traceContext returns the context parenting the spans of the attempts of a
+// step: the root span of its map run. The time the step waited in the queue
+// is recorded along the way.
+func (w *Worker) traceContext(step models.Step) context.Context {
+	ctx := context.Background()
+	if step.RunID == 0 || w.DatabaseClient == nil {
+		return ctx
+	}
+	run, err := w.DatabaseClient.GetMapRun(step.RunID)
 	if err != nil {
-		w.Logger.Printf("Error updating Step: %v\n", err)
+		w.stepLogger(step).Error("Error getting map run", "error", err)
+		return ctx
+	}
+	ctx = tracing.RunContext(ctx, run.TraceParent)
+	if queuedAt, err := w.DatabaseClient.StepRunQueuedAt(step.RunID, step.ID); err == nil {
+		tracing.QueueWait(ctx, step, queuedAt, step.StartDate)
 	}
-	w.Scheduler.TaskCompleted <- step.ID
-	w.Logger.Printf("Completed task: %v\n", step.ID)
+	return ctx
 }
 
-func (w *Worker) performTaskAction(step models.Step) error {
-	// Retrieve the base path for scripts from an environment variable
-	basePath := os.Getenv("PROJECT_PATH")
-	if basePath == "" {
-		log.Println("PROJECT_PATH environment variable is not set.")
-		return errors.New("script base path not configured")
+// attempt runs the command of a step once, within its own span.
+func (w *Worker) attempt(ctx context.Context, step models.Step) error {
+	ctx, span := tracing.StartAttempt(ctx, step)
+	err := w.performTaskAction(ctx, step)
+	tracing.EndAttempt(span, err)
+	return err
+}
+
+// saveState persists the state of a step when the worker has a database.
+func (w *Worker) saveState(step models.Step) {
+	if w.DatabaseClient == nil {
+		return
+	}
+	var err error
+	if step.RunID != 0 {
+		err = w.DatabaseClient.SetStepRunState(step)
+	} else {
+		err = w.DatabaseClient.SetStepState(step)
 	}
+	if err != nil {
+		w.stepLogger(step).Error("Error updating step state", "state", step.State, "error", err)
+	}
+}
 
-	// Construct the full script path
-	scriptPath := filepath.Join(basePath, step.Command)
+// recordDatasets records an update of each dataset produced by a step that
+// completed in a map run, before the scheduler is woken up so that it can
+// start the maps consuming them.
+func (w *Worker) recordDatasets(step models.Step) {
+	if step.RunID == 0 || w.DatabaseClient == nil {
+		return
+	}
+	for _, dataset := range step.Produces {
+		e := &models.DatasetEvent{Dataset: dataset, MapID: step.MapID, RunID: step.RunID, StepID: step.ID}
+		if err := w.DatabaseClient.RecordDatasetEvent(e); err != nil {
+			w.stepLogger(step).Error("Error recording dataset update", "dataset", dataset, "error", err)
+		}
+	}
+}
+
+// observe records the duration of a finished step.
+func (w *Worker) observe(step models.Step) {
+	metrics.StepDuration.WithLabelValues(w.mapName(step), step.Name, step.State).
+		Observe(step.EndDate.Sub(step.StartDate).Seconds())
+}
+
+// mapName returns the name of the map of a step for the metric labels,
+// falling back to its ID.
+func (w *Worker) mapName(step models.Step) string {
+	if w.DatabaseClient != nil {
+		if m, err := w.DatabaseClient.GetMapByID(step.MapID); err == nil {
+			return m.Name
+		}
+	}
+	return fmt.Sprint(step.MapID)
+}
+
+// logger returns the logger of the worker, or the default one.
+func (w *Worker) logger() *slog.Logger {
+	if w.Logger == nil {
+		return slog.Default()
+	}
+	return w.Logger
+}
+
+// stepLogger returns a logger carrying the map, run and step of a step.
+func (w *Worker) stepLogger(step models.Step) *slog.Logger {
+	return w.logger().With("map_id", step.MapID, "run_id", step.RunID, "step_id", step.ID, "step", step.Name)
+}
+
+// notify tells the notifiers of the map of a step about its failure.
+func (w *Worker) notify(event string, step models.Step, err error) {
+	notify.Send(w.DatabaseClient, notify.Event{
+		Type:    event,
+		MapID:   step.MapID,
+		RunID:   step.RunID,
+		StepID:  step.ID,
+		Step:    step.Name,
+		Attempt: step.Attempt,
+		State:   step.State,
+		Error:   err.Error(),
+	})
+}
+
+// notifyScheduler wakes the scheduler up so it can queue the dependents of a
+// finished step without waiting for its next poll.
+func (w *Worker) notifyScheduler(step models.Step) {
+	if w.Scheduler == nil {
+		return
+	}
+	select {
+	case w.Scheduler.TaskCompleted <- step.ID:
+	default:
+	}
+}
+
+// Poll executes the step instances queued in the database, for workers
+// running in a different process than the scheduler. It never returns.
+func (w *Worker) Poll(interval time.Duration) {
+	metrics.Workers.WithLabelValues("idle").Inc()
+	for {
+		step, err := w.DatabaseClient.NextQueuedStep()
+		if err != nil {
+			w.logger().Error("Error fetching queued step", "error", err)
+		}
+		if step == nil {
+			time.Sleep(interval)
+			continue
+		}
+		w.ExecuteTask(*step)
+	}
+}
+
+func (w *Worker) performTaskAction(ctx context.Context, step models.Step) error {
+	cmd, secrets, err := w.scriptCommand(ctx, step, step.Command)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(cmd.Env, tracing.Env(ctx)...)
 
 	// Execute the script
-	cmd := exec.Command("python", scriptPath)
 	output, err := cmd.CombinedOutput()
+	masked := maskSecrets(string(output), secrets)
+	w.saveLog(step, masked)
 	if err != nil {
-		log.Printf("Error executing script: %s, Output: %s\n", err, output)
+		w.stepLogger(step).Error("Error executing script", "attempt", step.Attempt, "error", err, "output", masked)
 		return err
 	}
 
-	log.Printf("Output: %s\n", output)
+	w.stepLogger(step).Debug("Script output", "attempt", step.Attempt, "output", masked)
 	return nil
 }
 
-func (w *Worker) StartWorker(taskQueue chan models.Step, dbClient *database.DB, scheduler *scheduler.Scheduler, logger *log.Logger) {
+// scriptCommand prepares a script of a step, its command or one of its hooks,
+// to run with the connections of the step and the details of its map run. The
+// secrets to mask in the output are returned along with it.
+func (w *Worker) scriptCommand(ctx context.Context, step models.Step, command string) (*exec.Cmd, []string, error) {
+	// Retrieve the base path for scripts from an environment variable
+	basePath := os.Getenv("PROJECT_PATH")
+	if basePath == "" {
+		w.stepLogger(step).Error("PROJECT_PATH environment variable is not set")
+		return nil, nil, errors.New("script base path not configured")
+	}
+
+	// Resolve the connections the step references
+	connEnv, secrets, err := w.connectionEnv(step)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Render the command with the details of the map run
+	data, err := w.templateData(step)
+	if err != nil {
+		return nil, nil, err
+	}
+	args, err := renderArgs(command, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(args) == 0 {
+		return nil, nil, errors.New("step has no command")
+	}
+
+	// Construct the full script path
+	scriptPath := filepath.Join(basePath, args[0])
+
+	cmd := exec.CommandContext(ctx, "python", append([]string{scriptPath}, args[1:]...)...)
+	cmd.Env = append(append(os.Environ(), connEnv...), runEnv(data)...)
+	return cmd, secrets, nil
+}
+
+// saveLog keeps the output of an attempt of a map run step so it can be
+// retrieved later.
+func (w *Worker) saveLog(step models.Step, output string) {
+	if step.RunID == 0 || w.DatabaseClient == nil {
+		return
+	}
+	if err := w.DatabaseClient.SaveStepLog(step.RunID, step.ID, step.Attempt, output); err != nil {
+		w.stepLogger(step).Error("Error saving step output", "attempt", step.Attempt, "error", err)
+	}
+}
+
+func (w *Worker) StartWorker(taskQueue chan models.Step, dbClient *database.DB, scheduler *scheduler.Scheduler, logger *slog.Logger) {
 	worker := Worker{
 		TaskQueue:      taskQueue,
 		DatabaseClient: dbClient,
@@ -73,7 +304,9 @@ func (w *Worker) StartWorker(taskQueue chan models.Step, dbClient *database.DB,
 		Logger:         logger,
 	}
 
+	metrics.Workers.WithLabelValues("idle").Inc()
 	for task := range worker.TaskQueue {
+		metrics.TaskQueueDepth.Set(float64(len(worker.TaskQueue)))
 		worker.ExecuteTask(task)
 	}
 }
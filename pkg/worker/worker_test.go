@@ -2,9 +2,10 @@ package worker
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"pilot/pkg/models"
+	"reflect"
 	"testing"
 )
 
@@ -18,7 +19,7 @@ func TestExecuteTask(t *testing.T) {
 		Command: "main.py", // Use the mock step script
 	}
 
-	logger := log.New(os.Stdout, "test-logger: ", log.LstdFlags)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	worker := Worker{
 		TaskQueue:      make(chan models.Step, 1),
 		DatabaseClient: nil, // Provide a mock or test database if needed
@@ -30,3 +31,46 @@ func TestExecuteTask(t *testing.T) {
 	fmt.Printf("Starting task here")
 	worker.ExecuteTask(mockStep)
 }
+
+func TestMaskSecrets(t *testing.T) {
+	output := "connecting to postgres://etl:hunter2@db with hunter2\n"
+	got := maskSecrets(output, []string{"postgres://etl:hunter2@db", "hunter2", ""})
+	want := "connecting to *** with ***\n"
+	if got != want {
+		t.Errorf("maskSecrets = %q, want %q", got, want)
+	}
+}
+
+func TestConnectionEnvPrefix(t *testing.T) {
+	if got := connectionEnvPrefix("warehouse-db"); got != "PILOT_CONN_WAREHOUSE_DB" {
+		t.Errorf("connectionEnvPrefix = %q", got)
+	}
+}
+
+func TestRenderCommand(t *testing.T) {
+	data := TemplateData{RunID: 7, Ds: "2024-03-01", Conf: map[string]any{"region": "eu"}}
+
+	got, err := renderCommand("load.py --date {{ .Ds }} --region {{ .Conf.region }}", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "load.py --date 2024-03-01 --region eu"; got != want {
+		t.Errorf("renderCommand = %q, want %q", got, want)
+	}
+
+	if _, err := renderCommand("load.py {{ .Conf.missing }}", data); err == nil {
+		t.Error("expected an error for a missing conf key")
+	}
+}
+
+func TestRenderArgs(t *testing.T) {
+	data := TemplateData{Ds: "2024-03-01", Conf: map[string]any{"region": "eu --drop-all"}}
+
+	got, err := renderArgs("load.py  --date {{ .Ds }} --region {{ .Conf.region }}", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"load.py", "--date", "2024-03-01", "--region", "eu --drop-all"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("renderArgs = %q, want %q", got, want)
+	}
+}